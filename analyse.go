@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// searches a position given as a FEN, printing what each depth of the search finds, or every
+// position of the games of a PGN file, writing them annotated
+func StartAnalyse(args []string) {
+	fs := commandFlags("analyse")
+	depth := fs.Int("depth", MaxPly, "maximum search depth")
+	moveTime := fs.Duration("time", 2*time.Second, "search time per position (0 for no limit)")
+	syzygyPath := fs.String("syzygy", "", "directories of Syzygy tablebases, separated by colons")
+	out := fs.String("out", "", "file to write annotated games to (default: standard output)")
+	an := DefaultAnnotator()
+	fs.IntVar(&an.inaccuracy, "inaccuracy", an.inaccuracy, "centipawns a move must lose to be marked ?!")
+	fs.IntVar(&an.mistake, "mistake", an.mistake, "centipawns a move must lose to be marked ?")
+	fs.IntVar(&an.blunder, "blunder", an.blunder, "centipawns a move must lose to be marked ??")
+	fs.Parse(args)
+	if fs.NArg() == 0 || *depth < 1 || *depth > MaxPly || (*moveTime == 0 && *depth == MaxPly) {
+		usageError(fs)
+	}
+	limits := Limits{depth: *depth}
+	if *syzygyPath != "" {
+		tb, err := OpenTablebase(*syzygyPath)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		limits.tablebase = tb
+	}
+	// a FEN may be given unquoted, as several arguments
+	arg := strings.Join(fs.Args(), " ")
+	if fs.NArg() > 1 || !fileExists(arg) {
+		if err := CheckFEN(arg); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if *moveTime > 0 {
+			limits.deadline = time.Now().Add(*moveTime)
+		}
+		limits.halfmoves = NewGame(arg, nil, nil).HalfmoveClock()
+		Analyse(os.Stdout, LoadInitialPosition(arg), limits)
+		return
+	}
+
+	f, err := os.Open(arg)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	games, err := ReadPGN(f)
+	f.Close()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	w, progress := io.Writer(os.Stdout), io.Writer(os.Stderr)
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		w, progress = file, os.Stdout
+	}
+	an.limits, an.moveTime = limits, *moveTime
+	for i, g := range games {
+		fmt.Fprintf(progress, "game %d: %s - %s, %d half-moves\n", i+1, g.players[White].name, g.players[Black].name, g.Ply())
+		marks := an.Annotate(g)
+		for _, c := range []PieceColour{White, Black} {
+			fmt.Fprintf(progress, "  %v: %s\n", c, marks[c])
+		}
+		if err := g.WritePGN(w); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+}
+
+// Annotator marks the moves of games by the centipawns they lose against the engine's best
+type Annotator struct {
+	limits     Limits        // of the search of every position, but for the deadline
+	moveTime   time.Duration // search time per position, 0 for no limit
+	inaccuracy int           // centipawns lost by a move marked ?!
+	mistake    int           // ?
+	blunder    int           // ??
+	pvPlies    int           // of the engine's line given as the alternative to a marked move
+}
+
+func DefaultAnnotator() Annotator {
+	return Annotator{
+		limits:     Limits{depth: 6},
+		inaccuracy: 50,
+		mistake:    100,
+		blunder:    300,
+		pvPlies:    6,
+	}
+}
+
+// scores beyond this many centipawns count as this many, so that moves of a decided game
+// are not marked
+const annotationScoreCap = 1000
+
+// MoveMarks counts the marked moves of one side
+type MoveMarks struct {
+	inaccuracies, mistakes, blunders int
+}
+
+func (mm MoveMarks) String() string {
+	return fmt.Sprintf("inaccuracies %d, mistakes %d, blunders %d", mm.inaccuracies, mm.mistakes, mm.blunders)
+}
+
+// searches every position of the game, then gives each move the evaluation after it and
+// marks the moves that lose enough with ?!, ? or ??, adding the engine's line as a
+// variation. Comments, clock times and other NAGs are kept
+func (an Annotator) Annotate(g *Game) map[PieceColour]*MoveMarks {
+	pos := LoadInitialPosition(g.initialFen)
+	positions := []Position{pos}
+	var moves []Move
+	for _, s := range strings.Fields(g.moves) {
+		m := pos.StringToMove(s)
+		pos = pos.ProcessMove(m)
+		positions = append(positions, pos)
+		moves = append(moves, m)
+	}
+
+	// the evaluation and the principal variation of every position, from white's point of view
+	// and without the pawn term's bias, so that the moves of either side are held to the same
+	// thresholds
+	evals := make([]int, len(positions))
+	lines := make([][]Move, len(positions))
+	limits := an.limits
+	limits.tables = NewSearchTables(TTMaxSize)
+	halfmoves := 0
+	if fields := strings.Fields(g.initialFen); len(fields) > 4 {
+		halfmoves, _ = strconv.Atoi(fields[4])
+	}
+	for i, p := range positions {
+		if i > 0 {
+			halfmoves++
+			if resetsHalfmoves(positions[i-1], moves[i-1]) {
+				halfmoves = 0
+			}
+		}
+		if len(p.LegalMoves()) == 0 {
+			if p.InCheck() {
+				evals[i] = colourMultiplier[p.turn] * -checkmateValue
+			}
+			continue
+		}
+		limits.tables.Advance(i)
+		limits.halfmoves = halfmoves
+		if an.moveTime > 0 {
+			limits.deadline = time.Now().Add(an.moveTime)
+		}
+		tree := &MoveTree{position: p}
+		evals[i] = unbiased(ThinkUntil(tree, limits, nil), p)
+		lines[i] = tree.PrincipalVariation()
+	}
+
+	marks := map[PieceColour]*MoveMarks{White: {}, Black: {}}
+	score := func(eval int, c PieceColour) int {
+		cp := evalToCentipawns(eval) * colourMultiplier[c]
+		if cp > annotationScoreCap {
+			return annotationScoreCap
+		}
+		if cp < -annotationScoreCap {
+			return -annotationScoreCap
+		}
+		return cp
+	}
+	for i, m := range moves {
+		p := positions[i]
+		a := g.Annotation(i)
+		a.eval, a.hasEval = evals[i+1], true
+		if len(lines[i]) == 0 || lines[i][0] == m {
+			continue
+		}
+		loss := score(evals[i], p.turn) - score(evals[i+1], p.turn)
+		mark := marks[p.turn]
+		var nag int
+		switch {
+		case loss >= an.blunder:
+			nag = suffixNags["??"]
+			mark.blunders++
+		case loss >= an.mistake:
+			nag = suffixNags["?"]
+			mark.mistakes++
+		case loss >= an.inaccuracy:
+			nag = suffixNags["?!"]
+			mark.inaccuracies++
+		default:
+			continue
+		}
+		// the new mark replaces any ?, ?? or ?! the move had, and other NAGs stay
+		nags := []int{nag}
+		for _, old := range a.nags {
+			if old != suffixNags["?"] && old != suffixNags["??"] && old != suffixNags["?!"] {
+				nags = append(nags, old)
+			}
+		}
+		a.nags = nags
+		line := lines[i]
+		if len(line) > an.pvPlies {
+			line = line[:an.pvPlies]
+		}
+		variation := make(Variation, len(line))
+		for j, move := range line {
+			variation[j].move = move
+		}
+		variation[0].eval, variation[0].hasEval = evals[i], true
+		a.variations = append(a.variations, variation)
+	}
+	g.tags["Annotator"] = "stupid-horse"
+	return marks
+}
+
+// searches the position within the limits, writing the score, time and principal variation
+// of every completed depth, then the best move and the nodes searched
+func Analyse(w io.Writer, pos Position, limits Limits) int {
+	if len(pos.LegalMoves()) == 0 {
+		if pos.InCheck() {
+			fmt.Fprintln(w, "checkmate")
+			return colourMultiplier[pos.turn] * -checkmateValue
+		}
+		fmt.Fprintln(w, "stalemate")
+		return 0
+	}
+	tree := &MoveTree{position: pos}
+	nodes := 0
+	limits.nodes = &nodes
+	start := time.Now()
+	eval := ThinkUntil(tree, limits, func(depth, eval int) {
+		pv := sanLine(pos, tree.PrincipalVariation())
+		fmt.Fprintf(w, "depth %2d  score %7s  time %8v  pv %s\n", depth, FormatEval(eval), time.Since(start).Round(time.Millisecond), strings.Join(pv, " "))
+	})
+	elapsed := time.Since(start)
+	fmt.Fprintf(w, "best move %s, score %s, %d nodes in %v (%.0f nodes/s)\n", pos.MoveToSAN(tree.follow.move), FormatEval(eval), nodes, elapsed.Round(time.Millisecond), float64(nodes)/elapsed.Seconds())
+	return eval
+}
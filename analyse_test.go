@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnalyse(t *testing.T) {
+	var sb strings.Builder
+	pos := LoadInitialPosition("6k1/5ppp/8/8/8/8/5PPP/3R2K1 w - - 0 1")
+	eval := Analyse(&sb, pos, Limits{depth: 3})
+	lines := strings.Split(strings.TrimSpace(sb.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "depth  3") || !strings.Contains(lines[2], "pv Rd8#") {
+		t.Errorf("Analyse() wrote %q", sb.String())
+	}
+	if !strings.HasPrefix(lines[3], "best move Rd8#, score #1") || FormatEval(eval) != "#1" {
+		t.Errorf("Analyse() = %s, last line %q", FormatEval(eval), lines[3])
+	}
+	sb.Reset()
+	Analyse(&sb, LoadInitialPosition("7k/6Q1/6K1/8/8/8/8/8 b - - 0 1"), Limits{depth: 2})
+	if sb.String() != "checkmate\n" {
+		t.Errorf("Analyse() of a checkmate wrote %q", sb.String())
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	games, err := ReadPGN(strings.NewReader(`[White "a"]
+[Black "b"]
+
+1. e4! { nice } e5 2. Bc4 Nc6 3. Qh5 Nf6? 4. Qxf7#!? 1-0
+`))
+	if err != nil || len(games) != 1 {
+		t.Fatal(games, err)
+	}
+	g := games[0]
+	an := DefaultAnnotator()
+	an.limits.depth = 3
+	marks := an.Annotate(g)
+	if marks[Black].blunders != 1 {
+		t.Errorf("Annotate() marks = black %v; want 1 blunder", marks[Black])
+	}
+	if e4 := g.Annotation(0); e4.comment != "nice" || !e4.hasEval || len(e4.nags) != 1 || e4.nags[0] != suffixNags["!"] {
+		t.Errorf("1. e4 annotation = %+v; want the comment and ! kept", *e4)
+	}
+	nf6 := g.Annotation(5)
+	if len(nf6.nags) != 1 || nf6.nags[0] != suffixNags["??"] || len(nf6.variations) != 1 {
+		t.Fatalf("3... Nf6 annotation = %+v; want ?? and a variation", *nf6)
+	}
+	if v := nf6.variations[0]; len(v) == 0 || v[0].move.String() == "g8f6" || !v[0].hasEval {
+		t.Errorf("3... Nf6 variation = %+v", v)
+	}
+	// the ? of the input gives way to the new mark, while the best move keeps its !?
+	mate := g.Annotation(6)
+	if FormatEval(mate.eval) != "#0" || len(mate.nags) != 1 || mate.nags[0] != suffixNags["!?"] {
+		t.Errorf("4. Qxf7# annotation = %+v; want #0 and !? kept", *mate)
+	}
+	if g.tags["Annotator"] != "stupid-horse" {
+		t.Errorf("Annotate() Annotator tag = %q", g.tags["Annotator"])
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// positions searched by bench: openings, middlegames and endgames
+var benchFENs = []string{
+	StartFEN,
+	"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+	"r1bqkbnr/pppp1ppp/2n5/4p3/2B1P3/5N2/PPPP1PPP/RNBQK2R b KQkq - 3 3",
+	"r1bq1rk1/pp2bppp/2n1pn2/3p4/2PP4/2N1PN2/PP3PPP/R2QKB1R w KQ - 0 8",
+	"2rq1rk1/pb1nbppp/1p2pn2/2pp4/2PP4/1P1BPN2/PB1N1PPP/R2Q1RK1 w - - 0 11",
+	"r4rk1/1pp1qppp/p1np1n2/2b1p1B1/2B1P1b1/P1NP1N2/1PP1QPPP/R4RK1 w - - 0 10",
+	"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1",
+	"6k1/5ppp/8/8/8/8/5PPP/3R2K1 w - - 0 1",
+	"8/8/4k3/8/2p5/8/B2K4/8 w - - 0 1",
+	"4k3/8/8/8/8/8/4P3/4K3 w - - 0 1",
+}
+
+// searches every bench position to the depth with new tables, returning the nodes searched
+// and the time taken; the node count changes only with the search, so it identifies a
+// version of the engine
+func Bench(depth int, report func(fen string, nodes int, elapsed time.Duration)) (int, time.Duration) {
+	total, start := 0, time.Now()
+	for _, fen := range benchFENs {
+		nodes, posStart := 0, time.Now()
+		ThinkUntil(&MoveTree{position: LoadInitialPosition(fen)}, Limits{depth: depth, nodes: &nodes}, nil)
+		if report != nil {
+			report(fen, nodes, time.Since(posStart))
+		}
+		total += nodes
+	}
+	return total, time.Since(start)
+}
+
+func StartBench(args []string) {
+	fs := commandFlags("bench")
+	depth := fs.Int("depth", 5, "search depth of every position")
+	fs.Parse(args)
+	if fs.NArg() > 0 || *depth < 1 || *depth > MaxPly {
+		usageError(fs)
+	}
+	nodes, elapsed := Bench(*depth, func(fen string, nodes int, elapsed time.Duration) {
+		fmt.Printf("%10d nodes %8v  %s\n", nodes, elapsed.Round(time.Millisecond), fen)
+	})
+	fmt.Printf("%d nodes in %v: %.0f nodes/s\n", nodes, elapsed.Round(time.Millisecond), float64(nodes)/elapsed.Seconds())
+}
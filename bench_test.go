@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBench(t *testing.T) {
+	var fens []string
+	nodes, _ := Bench(2, func(fen string, nodes int, _ time.Duration) {
+		if nodes == 0 {
+			t.Errorf("Bench() searched no nodes of %s", fen)
+		}
+		fens = append(fens, fen)
+	})
+	if len(fens) != len(benchFENs) {
+		t.Errorf("Bench() reported %d positions; want %d", len(fens), len(benchFENs))
+	}
+	// the count identifies the search, so it must not vary between runs
+	if again, _ := Bench(2, nil); again != nodes {
+		t.Errorf("Bench() = %d nodes, then %d", nodes, again)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+)
+
+// size of an entry in a Polyglot book: key, move, weight and learn data
+const bookEntrySize = 16
+
+// the hash of a position in Polyglot books
+func (p Position) PolyglotKey() uint64 {
+	var key uint64
+	for _, square := range Squares {
+		piece := p.board[square]
+		if piece == NoPiece {
+			continue
+		}
+		// black pawn, white pawn, black knight, ... white king
+		kind := 2 * (int(piece.Type()) - 1)
+		if piece.Colour() == White {
+			kind++
+		}
+		key ^= polyglotRandom[64*kind+8*int(square.Rank())+int(square.File())]
+	}
+	// white h-side, white a-side, black h-side, black a-side
+	for i, rook := range []int{3, 2, 1, 0} {
+		c := White
+		if rook < 2 {
+			c = Black
+		}
+		if p.rookSquares[rook] != NoSquare && !p.kingMoved(c) {
+			key ^= polyglotRandom[768+i]
+		}
+	}
+	// the en passant file only counts if a pawn can capture there
+	if p.enPassantSquare != NoSquare {
+		file := p.enPassantSquare.File()
+		rank := Rank(int(p.enPassantSquare.Rank()) - pawnInfo[p.turn].forward)
+		pawn := CreatePiece(p.turn, Pawn)
+		if (file > 0 && p.board[ToSquare(file-1, rank)] == pawn) || (file < 7 && p.board[ToSquare(file+1, rank)] == pawn) {
+			key ^= polyglotRandom[772+int(file)]
+		}
+	}
+	if p.turn == White {
+		key ^= polyglotRandom[780]
+	}
+	return key
+}
+
+// encodes a move as Polyglot does, with castling as the king moving onto its rook
+func polyglotMove(m Move) uint16 {
+	square := func(s Square) uint16 {
+		return uint16(s.Rank())*8 + uint16(s.File())
+	}
+	promotions := map[PieceType]uint16{Knight: 1, Bishop: 2, Rook: 3, Queen: 4}
+	return square(m.to) | square(m.from)<<6 | promotions[m.promote]<<12
+}
+
+// decodes a Polyglot move in the position, failing unless it is legal there
+func (p Position) polyglotToMove(pm uint16) (Move, error) {
+	square := func(bits uint16) Square {
+		return ToSquare(File(bits&7), Rank(bits>>3&7))
+	}
+	moveString := square(pm>>6).String() + square(pm).String()
+	if promote := pm >> 12 & 7; promote > 0 {
+		moveString += []PieceType{Knight, Bishop, Rook, Queen}[(promote-1)%4].String()
+	}
+	m := p.StringToMove(moveString)
+	if !p.IsLegal(m) {
+		return NoMove, fmt.Errorf("illegal book move %s", moveString)
+	}
+	return m, nil
+}
+
+// BookEntry is a move stored in an opening book for some position
+type BookEntry struct {
+	move   Move
+	weight uint16
+}
+
+// Book is a Polyglot opening book: entries sorted by key, searched in place
+type Book struct {
+	r       io.ReaderAt
+	entries int64
+	maxPly  int // the book is not used after this many half-moves
+	rand    *rand.Rand
+}
+
+func OpenBook(filename string, maxPly int) (*Book, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size()%bookEntrySize != 0 {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a Polyglot book", filename)
+	}
+	return NewBook(f, info.Size()/bookEntrySize, maxPly), nil
+}
+
+func NewBook(r io.ReaderAt, entries int64, maxPly int) *Book {
+	return &Book{r: r, entries: entries, maxPly: maxPly, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (b *Book) entry(i int64) (key uint64, move, weight uint16, err error) {
+	var buf [bookEntrySize]byte
+	if _, err := b.r.ReadAt(buf[:], i*bookEntrySize); err != nil {
+		return 0, 0, 0, err
+	}
+	return binary.BigEndian.Uint64(buf[:8]), binary.BigEndian.Uint16(buf[8:10]), binary.BigEndian.Uint16(buf[10:12]), nil
+}
+
+// every legal book move for the position, in the book's order
+func (b *Book) Entries(pos Position) ([]BookEntry, error) {
+	key := pos.PolyglotKey()
+	// binary search for the first entry with the key
+	lo, hi := int64(0), b.entries
+	for lo < hi {
+		mid := (lo + hi) / 2
+		k, _, _, err := b.entry(mid)
+		if err != nil {
+			return nil, err
+		}
+		if k < key {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	var entries []BookEntry
+	for i := lo; i < b.entries; i++ {
+		k, pm, weight, err := b.entry(i)
+		if err != nil {
+			return nil, err
+		}
+		if k != key {
+			break
+		}
+		// moves that are illegal here belong to positions whose keys collide
+		if m, err := pos.polyglotToMove(pm); err == nil {
+			entries = append(entries, BookEntry{m, weight})
+		}
+	}
+	return entries, nil
+}
+
+// picks a book move at random in proportion to the entries' weights; ok is false when the
+// position is not in the book or the game is past the book's maximum depth
+func (b *Book) Pick(pos Position, ply int) (m Move, ok bool, err error) {
+	if ply >= b.maxPly {
+		return NoMove, false, nil
+	}
+	entries, err := b.Entries(pos)
+	if err != nil {
+		return NoMove, false, err
+	}
+	total := 0
+	for _, e := range entries {
+		total += int(e.weight)
+	}
+	if total == 0 {
+		return NoMove, false, nil
+	}
+	n := b.rand.Intn(total)
+	for _, e := range entries {
+		n -= int(e.weight)
+		if n < 0 {
+			return e.move, true, nil
+		}
+	}
+	return NoMove, false, nil
+}
+
+// BookBuilder collects moves from games, scoring each 2 for a win, 1 for a draw and 0 for
+// a loss of the side that played it
+type BookBuilder struct {
+	maxPly int
+	moves  map[uint64]map[uint16]*bookCount
+}
+
+type bookCount struct {
+	games, score int
+}
+
+func NewBookBuilder(maxPly int) *BookBuilder {
+	return &BookBuilder{maxPly: maxPly, moves: map[uint64]map[uint16]*bookCount{}}
+}
+
+func (bb *BookBuilder) AddGame(g *Game) {
+	points := map[string]map[PieceColour]int{
+		"1-0":     {White: 2, Black: 0},
+		"0-1":     {White: 0, Black: 2},
+		"1/2-1/2": {White: 1, Black: 1},
+	}[g.result]
+	if points == nil {
+		return
+	}
+	pos := LoadInitialPosition(g.initialFen)
+	for ply, line := 0, g.mainLine(); ply < len(line) && ply < bb.maxPly; ply++ {
+		key := pos.PolyglotKey()
+		if bb.moves[key] == nil {
+			bb.moves[key] = map[uint16]*bookCount{}
+		}
+		pm := polyglotMove(line[ply].move)
+		if bb.moves[key][pm] == nil {
+			bb.moves[key][pm] = &bookCount{}
+		}
+		bb.moves[key][pm].games++
+		bb.moves[key][pm].score += points[pos.turn]
+		pos = pos.ProcessMove(line[ply].move)
+	}
+}
+
+// writes the moves played in at least minGames games, weighted by their score and scaled
+// to fit Polyglot's 16-bit weights
+func (bb *BookBuilder) Write(w io.Writer, minGames int) (entries int, err error) {
+	type entry struct {
+		key          uint64
+		move, weight uint16
+	}
+	var book []entry
+	maxScore := 0
+	for _, moves := range bb.moves {
+		for _, c := range moves {
+			if c.games >= minGames && c.score > maxScore {
+				maxScore = c.score
+			}
+		}
+	}
+	for key, moves := range bb.moves {
+		for pm, c := range moves {
+			if c.games < minGames || c.score == 0 {
+				continue
+			}
+			weight := c.score
+			if maxScore > 0xffff {
+				weight = c.score * 0xffff / maxScore
+			}
+			if weight == 0 {
+				weight = 1
+			}
+			book = append(book, entry{key, pm, uint16(weight)})
+		}
+	}
+	sort.Slice(book, func(i, j int) bool {
+		if book[i].key != book[j].key {
+			return book[i].key < book[j].key
+		}
+		if book[i].weight != book[j].weight {
+			return book[i].weight > book[j].weight
+		}
+		return book[i].move < book[j].move
+	})
+	bw := bufio.NewWriter(w)
+	var buf [bookEntrySize]byte
+	for _, e := range book {
+		binary.BigEndian.PutUint64(buf[:8], e.key)
+		binary.BigEndian.PutUint16(buf[8:10], e.move)
+		binary.BigEndian.PutUint16(buf[10:12], e.weight)
+		if _, err := bw.Write(buf[:]); err != nil {
+			return 0, err
+		}
+	}
+	return len(book), bw.Flush()
+}
+
+// builds a Polyglot book from PGN collections
+func StartBook(args []string) {
+	fs := commandFlags("book")
+	out := fs.String("out", "book.bin", "Polyglot book to write")
+	maxPly := fs.Int("depth", 24, "half-moves of each game to include")
+	minGames := fs.Int("min-games", 2, "leave out moves played in fewer games")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		usageError(fs)
+	}
+	bb := NewBookBuilder(*maxPly)
+	games := 0
+	for _, filename := range fs.Args() {
+		f, err := os.Open(filename)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		pgnGames, err := ReadPGN(f)
+		f.Close()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, g := range pgnGames {
+			bb.AddGame(g)
+		}
+		games += len(pgnGames)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	entries, err := bb.Write(f, *minGames)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("wrote %d entries from %d games to %s\n", entries, games, *out)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPolyglotKey(t *testing.T) {
+	// keys from the Polyglot book format specification
+	tests := []struct {
+		moves string
+		key   uint64
+	}{
+		{"", 0x463b96181691fc9c},
+		{"e2e4", 0x823c9b50fd114196},
+		{"e2e4 d7d5", 0x0756b94461c50fb0},
+		{"e2e4 d7d5 e4e5", 0x662fafb965db29d4},
+		{"e2e4 d7d5 e4e5 f7f5", 0x22a48b5a8e47ff78},
+		{"e2e4 d7d5 e4e5 f7f5 e1e2", 0x652a607ca3f242c1},
+		{"e2e4 d7d5 e4e5 f7f5 e1e2 e8f7", 0x00fdd303c946bdd9},
+		{"a2a4 b7b5 h2h4 b5b4 c2c4", 0x3c8123ea7b067637},
+		{"a2a4 b7b5 h2h4 b5b4 c2c4 b4c3 a1a3", 0x5c3f9b829b279560},
+	}
+	for _, test := range tests {
+		g := NewGame(StartFEN, nil, nil)
+		g.AddMoves(test.moves)
+		if key := g.moveTree.position.PolyglotKey(); key != test.key {
+			t.Errorf("PolyglotKey() after %q = %016x; want %016x", test.moves, key, test.key)
+		}
+	}
+}
+
+const bookPGN = `[Result "1-0"]
+
+1. e4 e5 2. Nf3 Nc6 3. Bb5 1-0
+
+[Result "1/2-1/2"]
+
+1. e4 e5 2. Nf3 Nf6 1/2-1/2
+
+[Result "0-1"]
+
+1. d4 d5 2. c4 0-1
+
+[Result "1-0"]
+
+1. e4 c5 2. Nf3 1-0
+
+[Result "*"]
+
+1. c4 *
+`
+
+func TestBook(t *testing.T) {
+	games, err := ReadPGN(strings.NewReader(bookPGN))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bb := NewBookBuilder(4)
+	for _, g := range games {
+		bb.AddGame(g)
+	}
+	var buf bytes.Buffer
+	if _, err := bb.Write(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	book := NewBook(bytes.NewReader(buf.Bytes()), int64(buf.Len()/bookEntrySize), 4)
+
+	start := LoadInitialPosition(StartFEN)
+	entries, err := book.Entries(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1. e4 scored 5 points in three games, 1. d4 lost its only game, 1. c4 is unfinished
+	if len(entries) != 1 || entries[0].move.String() != "e2e4" || entries[0].weight != 5 {
+		t.Errorf("start position entries = %v; want e2e4 with weight 5", entries)
+	}
+	// black's replies to 1. e4: e5 scored 1 point, c5 none
+	afterE4 := start.ProcessMove(start.StringToMove("e2e4"))
+	entries, err = book.Entries(afterE4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].move.String() != "e7e5" || entries[0].weight != 1 {
+		t.Errorf("entries after 1. e4 = %v; want e7e5 with weight 1", entries)
+	}
+	for i := 0; i < 10; i++ {
+		m, ok, err := book.Pick(start, 0)
+		if err != nil || !ok || m.String() != "e2e4" {
+			t.Errorf("Pick() = %v, %v, %v; want e2e4", m, ok, err)
+		}
+	}
+	if _, ok, _ := book.Pick(start, 4); ok {
+		t.Errorf("Pick() past the maximum depth found a move")
+	}
+	// 3. Bb5 is the fifth half-move, beyond the book's depth
+	pos := LoadInitialPosition(StartFEN)
+	for _, move := range []string{"e2e4", "e7e5", "g1f3", "b8c6"} {
+		pos = pos.ProcessMove(pos.StringToMove(move))
+	}
+	if entries, _ := book.Entries(pos); len(entries) != 0 {
+		t.Errorf("entries after 2... Nc6 = %v; want none", entries)
+	}
+}
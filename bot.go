@@ -2,18 +2,59 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"time"
-
-	"github.com/joho/godotenv"
 )
 
 type Bot struct {
-	id    string
-	token string
-	game  *Game
+	id          string
+	api         LichessAPI
+	games       *GameManager
+	policy      ChallengePolicy
+	outcome     OutcomePolicy
+	chat        ChatSettings
+	chatPace    *Pacer          // Lichess limits how fast the bot may chat
+	queue       *ChallengeQueue // acceptable challenges waiting for a free game slot
+	archiveDir  string          // finished games are saved here as PGN
+	book        *Book           // nil without an opening book
+	tablebase   *Tablebase      // nil without endgame tablebases
+	hash        int             // transposition table entries per game
+	depth       int             // of the search for each move, at most
+	pondering   bool            // whether to search the expected reply on the opponent's time
+	overhead    time.Duration   // kept back from the bot's clock for network lag
+	maxMoveTime time.Duration   // the longest search for a move, 0 for no limit besides the clock
+	abortAfter  time.Duration   // without the opponent's first move before the bot aborts, 0 to wait forever
+	stall       time.Duration   // without even a keep-alive before a stream is reopened
+	reconnect   Backoff
+}
+
+// a bot with the default settings, playing one game at a time
+func NewBot(id string, api LichessAPI) *Bot {
+	policy := DefaultChallengePolicy()
+	return &Bot{
+		id:         id,
+		api:        api,
+		games:      NewGameManager(1, runtime.NumCPU()),
+		policy:     policy,
+		outcome:    DefaultOutcomePolicy(),
+		chat:       DefaultChatSettings(),
+		chatPace:   NewPacer(time.Second),
+		queue:      NewChallengeQueue(policy.queueSize),
+		archiveDir: "games",
+		hash:       TTMaxSize,
+		depth:      6,
+		pondering:  true,
+		abortAfter: 30 * time.Second,
+		stall:      streamStallTimeout,
+		reconnect:  defaultBackoff,
+	}
 }
 
 // converts Lichess game player data to struct used by the bot
@@ -27,86 +68,249 @@ func (b Bot) ToPlayer(lp LichessPlayer) Player {
 	}
 }
 
-func StartBot() {
-	err := godotenv.Load()
+// starts the bot with the config loaded from the flags in args, a config file and the
+// environment
+func StartBot(args []string) {
+	c, err := LoadConfig("lichess", args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		panic("could not load .env file")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if problems := c.Problems(); len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Println("Config error:", p)
+		}
+		os.Exit(1)
+	}
+	logLevel = logLevelNames[c.logLevel]
+	pieceValues = personalities[c.personality]
+	b := NewBot(c.botId, NewLichessClient(c.url, c.token))
+	if err := b.Configure(c); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if b.id == "" {
+		account, err := b.api.Account()
+		if err != nil {
+			fmt.Println("Could not find the bot's account:", err)
+			os.Exit(1)
+		}
+		b.id = account.Id
+	}
+	if err := b.Listen(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
-	b := Bot{id: os.Getenv("LICHESS_BOT_ID"), token: os.Getenv("LICHESS_KEY")}
-	b.Listen()
 }
 
-func (b *Bot) Listen() {
-	resp, err := b.request("GET", "stream/event")
-	if err != nil {
-		log.Fatal(err)
+// applies the config's settings for playing games, opening its book and tablebases
+func (b *Bot) Configure(c Config) error {
+	b.games = NewGameManager(c.maxGames, c.threads)
+	b.policy = c.challenge
+	b.queue = NewChallengeQueue(b.policy.queueSize)
+	b.outcome = c.outcome
+	b.chat = c.chat
+	b.archiveDir = c.archiveDir
+	b.hash = c.hash
+	b.depth = c.depth
+	b.pondering = c.ponder
+	b.overhead = c.overhead
+	b.maxMoveTime = c.maxMoveTime
+	b.abortAfter = c.abortAfter
+	var err error
+	if c.book != "" {
+		if b.book, err = OpenBook(c.book, c.bookDepth); err != nil {
+			return err
+		}
 	}
-	defer resp.Body.Close()
-	fmt.Println("Started listening")
-	lines := make(chan []byte)
-	go stream(resp, lines)
-	for line := range lines {
+	if c.syzygyPath != "" {
+		if b.tablebase, err = OpenTablebase(c.syzygyPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GameError is a failure that ends the bot's handling of one game, leaving the others be
+type GameError struct {
+	GameId string
+	Err    error
+}
+
+func (e *GameError) Error() string {
+	return fmt.Sprintf("game %s: %v", e.GameId, e.Err)
+}
+
+func (e *GameError) Unwrap() error {
+	return e.Err
+}
+
+var errMovesDiverged = errors.New("new moves do not build on old moves")
+
+// follows a game in its own goroutine, containing its errors and panics so that the bot's
+// other games go on
+func (b *Bot) superviseGame(g *LichessGame) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.report(&GameError{g.Id, fmt.Errorf("panic: %v", r)})
+		}
+		b.removeGame(g.Id)
+	}()
+	if err := b.loadGame(g); err != nil {
+		b.report(err)
+	}
+}
+
+// logs an error the bot carries on after
+func (b *Bot) report(err error) {
+	errorf("Error: %v", err)
+}
+
+// follows the event stream until it fails permanently, e.g. because the token is invalid
+func (b *Bot) Listen() error {
+	infof("Started listening")
+	err := followStream(b.api.StreamEvents, b.stall, b.reconnect, func(line []byte) bool {
 		var e LichessEvent
-		err := json.Unmarshal(line, &e)
-		if err != nil {
-			log.Fatal("Couldn't decode JSON")
+		if err := json.Unmarshal(line, &e); err != nil {
+			b.report(fmt.Errorf("could not decode event %s: %w", line, err))
+			return false
 		}
-		fmt.Printf("%s\n", line)
+		debugf("%s", line)
 		switch e.Type {
 		case "gameStart":
 			// load game to bot
-			go b.loadGame(e.Game)
+			if b.games.Start(e.Game.Id) {
+				go b.superviseGame(e.Game)
+			}
 		case "gameFinish":
 			// remove game from bot
-			fmt.Println("removing finished game")
-			b.game = nil
+			infof("removing finished game %s", e.Game.Id)
+			b.removeGame(e.Game.Id)
 		case "challenge":
 			b.considerChallenge(e.Challenge)
 		case "challengeCanceled", "challengeDeclined":
-			fmt.Printf("cancelled %s challenge (%s)\n", e.Challenge.Variant.Key, e.Challenge.Id)
-			// TODO: remove existing challenge in bot queue
+			infof("cancelled %s challenge (%s)", e.Challenge.Variant.Key, e.Challenge.Id)
+			if b.queue.Remove(e.Challenge.Id) {
+				infof("removed challenge from queue %s", e.Challenge.Id)
+			}
 		}
-	}
-	fmt.Println("Stopped listening")
+		return false
+	})
+	infof("Stopped listening")
+	return err
 }
 
 func (b *Bot) considerChallenge(c *LichessChallenge) {
-	fmt.Printf("considering %s challenge (%s)\n", c.Variant.Key, c.Id)
-	// TODO: instead of ignoring challenges, add them to a queue or decline
-	switch {
-	case b.game != nil: // game in progress
+	if c.Challenger != nil && c.Challenger.Id == b.id {
+		return // sent by the bot
+	}
+	infof("considering %s challenge (%s)", c.Variant.Key, c.Id)
+	switch reason := b.policy.Reason(c); {
+	case reason != "":
+		b.declineChallenge(c, reason)
+	case b.games.Reserve(c.Id):
+		b.acceptChallenge(c)
+	case b.queue.Push(c):
+		infof("queued challenge %s", c.Id)
+	default:
+		b.declineChallenge(c, "later")
+	}
+}
+
+func (b *Bot) acceptChallenge(c *LichessChallenge) {
+	if err := b.api.AcceptChallenge(c.Id); err != nil {
+		// most likely cancelled in the meantime
+		b.report(err)
+		b.removeGame(c.Id)
 		return
-	case c.Variant.Key != "standard" && c.Variant.Key != "chess960":
+	}
+	infof("accepted challenge %s", c.Id)
+}
+
+func (b *Bot) declineChallenge(c *LichessChallenge, reason string) {
+	if err := b.api.DeclineChallenge(c.Id, reason); err != nil {
+		b.report(err)
 		return
-	default:
-		resp, err := b.request("POST", "challenge/"+c.Id+"/accept")
-		if err != nil {
-			log.Fatal(err)
+	}
+	infof("declined challenge %s (%s)", c.Id, reason)
+}
+
+// frees the game's slot for the oldest queued challenge
+func (b *Bot) removeGame(id string) {
+	b.games.Remove(id)
+	for !b.games.Full() {
+		c := b.queue.Pop()
+		if c == nil {
+			return
+		}
+		if b.games.Reserve(c.Id) {
+			b.acceptChallenge(c)
 		}
-		defer resp.Body.Close()
 	}
 }
 
-func (b *Bot) loadGame(g *LichessGame) {
-	fmt.Printf("adding game %v\n", g.Id)
-	fmt.Println(*g)
-	resp, err := b.request("GET", "bot/game/stream/"+g.Id)
-	if err != nil {
-		log.Fatal(err)
+// follows a game until it ends or fails
+func (b *Bot) loadGame(g *LichessGame) error {
+	infof("adding game %v", g.Id)
+	debugf("%v", *g)
+	debugf("Started listening to game %s", g.Id)
+	var game *Game
+	var gameErr error
+	var abort *time.Timer // until the opponent's first move
+	var stopChat func()
+	defer func() {
+		if abort != nil {
+			abort.Stop()
+		}
+		if game != nil {
+			game.StopPondering()
+		}
+		if stopChat != nil {
+			stopChat()
+		}
+	}()
+	// handles a game state, reporting whether the game is over or failed
+	process := func(s LichessGameEvent) bool {
+		if gameErr = b.ProcessGameState(game, s); gameErr != nil {
+			return true
+		}
+		if s.Status != "started" && s.Status != "created" {
+			return true
+		}
+		// the game is aborted unless the opponent starts it in time
+		if game == nil || b.abortAfter <= 0 {
+			return false
+		}
+		if me, ok := game.BotColour(); ok {
+			switch moved := game.HasMoved(me.Flip()); {
+			case abort == nil && !moved:
+				abort = time.AfterFunc(b.abortAfter, func() { b.abortGame(g.Id) })
+			case abort != nil && moved:
+				abort.Stop()
+			}
+		}
+		return false
 	}
-	defer resp.Body.Close()
-	fmt.Println("Started listening")
-	lines := make(chan []byte)
-	go stream(resp, lines)
-	for line := range lines {
+	err := followStream(func() (io.ReadCloser, error) { return b.api.StreamGame(g.Id) }, b.stall, b.reconnect, func(line []byte) bool {
 		var e LichessGameEvent
-		err := json.Unmarshal(line, &e)
-		if err != nil {
-			log.Fatal("Couldn't decode JSON")
+		if err := json.Unmarshal(line, &e); err != nil {
+			b.report(&GameError{g.Id, fmt.Errorf("could not decode event %s: %w", line, err)})
+			return false
 		}
-		fmt.Printf("%s\n", line)
+		debugf("%s", line)
 		switch e.Type {
 		case "gameFull":
+			if e.White == nil || e.Black == nil || e.State == nil {
+				gameErr = fmt.Errorf("incomplete gameFull event %s", line)
+				return true
+			}
+			if game != nil {
+				// reconnected: catch up with the moves made in the meantime
+				return process(*e.State)
+			}
 			clock := map[PieceColour]uint{}
 			if e.Clock != nil {
 				clock = map[PieceColour]uint{
@@ -114,71 +318,273 @@ func (b *Bot) loadGame(g *LichessGame) {
 					Black: e.Clock.Initial,
 				}
 			}
-			b.game = NewGame(e.InitialFen,
+			game = NewGame(e.InitialFen,
 				map[PieceColour]Player{
 					White: b.ToPlayer(*e.White),
 					Black: b.ToPlayer(*e.Black),
 				}, clock)
-			b.game.id = g.Id
+			game.id = g.Id
+			if e.Variant != nil {
+				game.variant = e.Variant.Key
+			}
+			game.rated = e.Rated
+			game.speed = e.Speed
+			game.clock = e.Clock
+			game.started = time.Unix(0, e.CreatedAt*int64(time.Millisecond))
+			b.games.Set(g.Id, game)
+			stopChat = b.startChat(game)
+			// a game the bot is rejoining after a restart has been greeted already
+			if me, ok := game.BotColour(); ok && e.State.Status == "started" && !game.HasMoved(me) {
+				b.say(game, "player", b.chat.greeting)
+			}
 			// read state field
-			b.ProcessGameState(*e.State)
+			return process(*e.State)
 		case "gameState":
-			b.ProcessGameState(e)
+			return process(e)
 		case "chatLine":
-			fmt.Printf("%v said \"%v\" in %v", e.Username, e.Text, e.Room)
+			infof("%v said %q in %v", e.Username, e.Text, e.Room)
+			if game != nil && !strings.EqualFold(e.Username, b.id) {
+				b.say(game, e.Room, chatAnswer(game, e.Text))
+			}
 		}
+		return false
+	})
+	debugf("Stopped listening to game %s", g.Id)
+	if err == nil {
+		err = gameErr
+	}
+	if err != nil {
+		return &GameError{g.Id, err}
 	}
-	fmt.Println("Stopped listening")
+	return nil
 }
 
-func (b *Bot) ProcessGameState(s LichessGameEvent) {
-	if s.Type != "gameState" {
-		log.Fatal("Not a gameState")
+// aborts a game the opponent has not started, freeing its slot
+func (b *Bot) abortGame(id string) {
+	infof("Aborting game %s as the opponent has not moved", id)
+	if err := b.api.Abort(id); err != nil {
+		b.report(&GameError{id, err})
 	}
-	fmt.Println(s.Status)
-	if s.Status != "started" {
-		return
+}
+
+// updates the game from a game state and moves if it is the bot's turn
+func (b *Bot) ProcessGameState(game *Game, s LichessGameEvent) error {
+	if s.Type != "gameState" {
+		return fmt.Errorf("%q event is not a gameState", s.Type)
 	}
-	if b.game == nil {
-		fmt.Println("Game does not exist anymore")
-		return
+	debugf("%s", s.Status)
+	if game == nil {
+		warnf("Game does not exist anymore")
+		return nil
 	}
-	// update timers
-	currentMs := time.Now().UnixNano() / 1000
-	b.game.timers[White] = uint(currentMs) + s.Wtime
-	b.game.timers[Black] = uint(currentMs) + s.Btime
+	// remaining milliseconds on the clocks
+	game.timers[White] = s.Wtime
+	game.timers[Black] = s.Btime
 	// update moves
-	oldMoves := b.game.moves
+	oldMoves := game.moves
 	curMoves := s.Moves
-	fmt.Println("Old:", oldMoves)
-	fmt.Println("New:", curMoves)
+	debugf("Old: %s", oldMoves)
+	debugf("New: %s", curMoves)
 	if curMoves != oldMoves {
-		if len(curMoves) < len(oldMoves) {
-			log.Fatal("New moves do not build on old moves")
+		game.moved = false
+		if game.takeback && (curMoves == "" || strings.HasPrefix(oldMoves+" ", curMoves+" ")) {
+			// the moves the bot agreed to take back are gone
+			game.takeback = false
+			game.StopPondering()
+			game.TakeBack(game.Ply() - len(strings.Fields(curMoves)))
+		} else if len(curMoves) < len(oldMoves) || curMoves[:len(oldMoves)] != oldMoves {
+			return fmt.Errorf("%w: %q after %q", errMovesDiverged, curMoves, oldMoves)
+		} else {
+			newMoves := curMoves[len(oldMoves):]
+			game.AddMoves(newMoves)
+			// the clock of whoever made the last move
+			clock := s.Wtime
+			if game.moveTree.position.turn == White {
+				clock = s.Btime
+			}
+			game.Annotation(game.Ply() - 1).clock = time.Duration(clock) * time.Millisecond
 		}
-		if curMoves[:len(oldMoves)] != oldMoves {
-			log.Fatal("New moves do not build on old moves")
+	}
+	if s.Status != "started" {
+		if s.Status != "created" {
+			ending, ok := gameEndings[s.Status]
+			if !ok {
+				ending = "status " + s.Status
+			}
+			infof("Game %s over: %s", game.id, ending)
+			game.StopPondering()
+			game.Finish(s.Status, s.Winner)
+			// aborted games have nothing worth keeping
+			if !Aborted(s.Status) {
+				b.SaveGame(game)
+				if _, ok := game.BotColour(); ok {
+					b.say(game, "player", b.chat.goodbye)
+				}
+			}
 		}
-		newMoves := curMoves[len(oldMoves):]
-		b.game.AddMoves(newMoves)
+		return nil
+	}
+	if b.answerOffers(game, s) {
+		return nil
 	}
-	fmt.Println("Now", b.game.moveTree.position.turn, "to move")
-	if b.game.players[b.game.moveTree.position.turn].me {
-		b.Think()
+	debugf("Now %v to move", game.moveTree.position.turn)
+	if game.players[game.moveTree.position.turn].me && !game.moved {
+		// the game goes on, e.g. if the move crossed the opponent's resignation
+		if err := b.Think(game); err != nil {
+			b.report(&GameError{game.id, err})
+		}
 	}
+	return nil
 }
 
-func (b *Bot) Think() {
-	Think(b.game.moveTree, 6)
-	fmt.Println(b.game.moveTree.follow)
-	b.MakeMove(b.game.moveTree.follow.move)
+// answers the opponent's pending draw offer or takeback request, reporting whether the bot
+// agreed and so should wait for the game to change
+func (b *Bot) answerOffers(game *Game, s LichessGameEvent) bool {
+	me, ok := game.BotColour()
+	if !ok {
+		return false
+	}
+	draw, takeback := s.Bdraw, s.Btakeback
+	if me == Black {
+		draw, takeback = s.Wdraw, s.Wtakeback
+	}
+	if draw {
+		accept := b.outcome.AcceptDraw(game, me)
+		if err := b.api.AnswerDraw(game.id, accept); err != nil {
+			b.report(&GameError{game.id, err})
+		} else if accept {
+			infof("Accepted draw offer")
+			return true
+		}
+	}
+	if takeback {
+		accept := b.outcome.takebacks
+		if err := b.api.AnswerTakeback(game.id, accept); err != nil {
+			b.report(&GameError{game.id, err})
+		} else if accept {
+			infof("Accepted takeback")
+			game.takeback = true
+			return true
+		}
+	}
+	return false
 }
 
-func (b *Bot) MakeMove(m Move) {
-	resp, err := b.request("POST", "bot/game/"+b.game.id+"/move/"+m.String())
+// writes the game to the PGN archive
+func (b *Bot) SaveGame(game *Game) {
+	err := os.MkdirAll(b.archiveDir, 0755)
 	if err != nil {
-		log.Fatal(err)
+		warnf("Could not archive game: %v", err)
+		return
+	}
+	f, err := os.Create(filepath.Join(b.archiveDir, game.id+".pgn"))
+	if err != nil {
+		warnf("Could not archive game: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := game.WritePGN(f); err != nil {
+		warnf("Could not archive game: %v", err)
+		return
+	}
+	infof("Archived game %s", game.id)
+}
+
+func (b *Bot) Think(game *Game) error {
+	if game.tables == nil {
+		game.tables = NewSearchTables(b.hash)
+	}
+	limits := Limits{depth: b.depth, tablebase: b.tablebase, halfmoves: game.HalfmoveClock(), tables: game.tables}
+	var deadline time.Time
+	if remaining, ok := game.timers[game.moveTree.position.turn]; ok && game.clock != nil {
+		increment := time.Duration(game.clock.Increment) * time.Millisecond
+		deadline = time.Now().Add(b.moveTime(time.Duration(remaining)*time.Millisecond, increment))
+	}
+	var pondered *Ponder
+	if p := game.ponder; p != nil {
+		game.ponder = nil
+		if p.Finish(game.moveTree.position, deadline) {
+			pondered = p
+		}
+	}
+	if b.book != nil {
+		m, ok, err := b.book.Pick(game.moveTree.position, game.Ply())
+		if err != nil {
+			warnf("Could not read opening book: %v", err)
+		}
+		if ok {
+			game.Annotation(game.Ply()).comment = "book"
+			game.search = &SearchInfo{book: true}
+			return b.MakeMove(game, m)
+		}
+	}
+	var eval int
+	info := &SearchInfo{}
+	if pondered != nil {
+		debugf("Ponder hit")
+		game.moveTree.follow, game.moveTree.eval = pondered.tree.follow, pondered.tree.eval
+		eval, info.depth = pondered.eval, pondered.depth
+	} else {
+		// a missed ponder search has already aged the tables for this ply
+		if game.tables.ply != game.Ply() {
+			game.tables.Advance(game.Ply())
+		}
+		timed := limits
+		timed.deadline = deadline
+		b.games.Search(func() {
+			eval = ThinkUntil(game.moveTree, timed, func(depth, _ int) {
+				info.depth = depth
+			})
+		})
+	}
+	info.eval = eval
+	info.pv = sanLine(game.moveTree.position, game.moveTree.PrincipalVariation())
+	game.search = info
+	a := game.Annotation(game.Ply())
+	a.eval, a.hasEval = eval, true
+	turn := game.moveTree.position.turn
+	if b.outcome.Resign(game, turn) {
+		infof("Resigning at %s", FormatEval(eval))
+		return b.api.Resign(game.id)
+	}
+	debugf("%v", game.moveTree.follow)
+	if game.moveTree.follow == nil {
+		return errors.New("no move to play")
+	}
+	if err := b.MakeMove(game, game.moveTree.follow.move); err != nil {
+		return err
+	}
+	if b.pondering {
+		game.ponder = StartPonder(game.moveTree, limits, b.games.TrySearch)
+	}
+	if plies, ok := MateIn(eval); ok && eval*colourMultiplier[turn] > 0 && b.chat.announceMate && !game.mateSaid {
+		game.mateSaid = true
+		b.say(game, "player", fmt.Sprintf("Mate in %d.", (plies+1)/2))
+	}
+	if b.outcome.OfferDraw(game, turn) {
+		infof("Offering a draw at %s", FormatEval(eval))
+		return b.api.AnswerDraw(game.id, true)
+	}
+	return nil
+}
+
+// how long to search for a move with the time left on the bot's clock
+func (b *Bot) moveTime(remaining, increment time.Duration) time.Duration {
+	t := AllocateTime(remaining-b.overhead, increment)
+	if b.maxMoveTime > 0 && t > b.maxMoveTime {
+		t = b.maxMoveTime
+	}
+	if t < 0 {
+		return 0
+	}
+	return t
+}
+
+func (b *Bot) MakeMove(game *Game, m Move) error {
+	if err := b.api.Move(game.id, m.String()); err != nil {
+		return err
 	}
-	defer resp.Body.Close()
-	fmt.Println("Made move", m)
+	game.moved = true
+	infof("Made move %s", game.moveTree.position.MoveToSAN(m))
+	return nil
 }
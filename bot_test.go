@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessGameStateErrors(t *testing.T) {
+	b := &Bot{id: "bot"}
+	game := NewGame(StartFEN, map[PieceColour]Player{White: {id: "a"}, Black: {id: "b"}}, map[PieceColour]uint{})
+	if err := b.ProcessGameState(game, LichessGameEvent{Type: "gameState", Moves: "e2e4 e7e5", Status: "started"}); err != nil {
+		t.Fatalf("ProcessGameState() = %v", err)
+	}
+	for _, moves := range []string{"e2e4", "d2d4 e7e5 g1f3"} {
+		err := b.ProcessGameState(game, LichessGameEvent{Type: "gameState", Moves: moves, Status: "started"})
+		if !errors.Is(err, errMovesDiverged) {
+			t.Errorf("ProcessGameState() with moves %q = %v; want %v", moves, err, errMovesDiverged)
+		}
+	}
+	if err := b.ProcessGameState(game, LichessGameEvent{Type: "chatLine"}); err == nil {
+		t.Error("ProcessGameState() of a chatLine should fail")
+	}
+	if game.moves != "e2e4 e7e5" {
+		t.Errorf("moves = %q after failures; want %q", game.moves, "e2e4 e7e5")
+	}
+}
+
+func TestBotMoveTime(t *testing.T) {
+	b := &Bot{overhead: time.Second}
+	tests := []struct {
+		remaining, increment, max, want time.Duration
+	}{
+		{31 * time.Second, 0, 0, time.Second},
+		{31 * time.Second, 2 * time.Second, 0, 2500 * time.Millisecond},
+		{31 * time.Second, 0, 500 * time.Millisecond, 500 * time.Millisecond},
+		{500 * time.Millisecond, 0, 0, 0},
+	}
+	for _, test := range tests {
+		b.maxMoveTime = test.max
+		if got := b.moveTime(test.remaining, test.increment); got != test.want {
+			t.Errorf("moveTime(%v, %v) with max %v = %v; want %v", test.remaining, test.increment, test.max, got, test.want)
+		}
+	}
+}
+
+// an opponent that plays the first legal move it finds
+func firstLegalMove(fen string) func(moves []string) string {
+	return func(moves []string) string {
+		pos := LoadInitialPosition(fen)
+		for _, m := range moves {
+			pos = pos.ProcessMove(pos.StringToMove(m))
+		}
+		legal := pos.LegalMoves()
+		if len(legal) == 0 {
+			return ""
+		}
+		return legal[0].String()
+	}
+}
+
+var blitzChallenge = LichessChallenge{
+	Variant:     LichessVariant{Key: "standard"},
+	Speed:       "blitz",
+	TimeControl: LichessTimeControl{Type: "clock", Limit: 180, Increment: 2},
+}
+
+func TestBotPlaysGame(t *testing.T) {
+	f := newFakeLichess(t)
+	b := f.startBot(nil)
+	g := f.newGame("game0001", "startpos", true, firstLegalMove("startpos"))
+	f.challenge(g, blitzChallenge)
+	for i := 0; i < 4; i++ {
+		f.botMove(g)
+	}
+	f.opponentEnds(g, "resign")
+	var pgn []byte
+	f.waitFor("the game to be archived", func() bool {
+		var err error
+		pgn, err = os.ReadFile(filepath.Join(b.archiveDir, "game0001.pgn"))
+		return err == nil && b.games.Len() == 0
+	})
+	f.mu.Lock()
+	moves := len(g.moves)
+	f.mu.Unlock()
+	games, err := ReadPGN(bytes.NewReader(pgn))
+	if err != nil || len(games) != 1 {
+		t.Fatalf("ReadPGN() of the archived game = %d games, %v", len(games), err)
+	}
+	if games[0].result != "1-0" || games[0].Ply() != moves || !strings.Contains(string(pgn), "[%clk") {
+		t.Errorf("archived game with result %q and %d half-moves; want 1-0 after %d, with clock times:\n%s", games[0].result, games[0].Ply(), moves, pgn)
+	}
+}
+
+func TestBotMates(t *testing.T) {
+	f := newFakeLichess(t)
+	b := f.startBot(nil)
+	g := f.newGame("game0002", "k7/8/1K6/8/8/8/7Q/8 w - - 0 1", true, nil)
+	f.start(g)
+	if m := f.botMove(g); m != "h2h8" {
+		t.Errorf("bot played %s; want h2h8", m)
+	}
+	f.waitFor("the game to be removed", func() bool { return b.games.Len() == 0 && g.status == "mate" })
+}
+
+func TestBotChallengeQueue(t *testing.T) {
+	f := newFakeLichess(t)
+	b := f.startBot(nil)
+	first := f.newGame("first001", "startpos", false, nil)
+	f.challenge(first, blitzChallenge)
+	f.waitFor("the first challenge to be accepted", func() bool { return len(f.accepted) == 1 })
+
+	atomic := blitzChallenge
+	atomic.Variant.Key = "atomic"
+	f.challenge(f.newGame("atomic01", "startpos", false, nil), atomic)
+	f.challenge(f.newGame("second01", "startpos", false, nil), blitzChallenge)
+	f.challenge(f.newGame("cancel01", "startpos", false, nil), blitzChallenge)
+	f.waitFor("challenges to be queued", func() bool { return b.queue.Len() == 2 })
+	f.cancel("cancel01")
+	f.waitFor("the cancelled challenge to leave the queue", func() bool { return b.queue.Len() == 1 })
+
+	f.opponentEnds(first, "aborted")
+	f.waitFor("the queued challenge to be accepted", func() bool { return len(f.accepted) == 2 })
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.accepted[1] != "second01" || f.declined["atomic01"] != "variant" || len(f.declined) != 1 {
+		t.Errorf("accepted %v and declined %v; want second01 accepted and atomic01 declined for its variant", f.accepted, f.declined)
+	}
+}
+
+func TestBotReconnects(t *testing.T) {
+	f := newFakeLichess(t)
+	f.startBot(nil)
+	g := f.newGame("game0003", "startpos", false, nil)
+	f.start(g)
+	f.opponentMove(g, "e2e4")
+	reply := f.botMove(g)
+	f.disconnect(g)
+	f.opponentMove(g, firstLegalMove("startpos")([]string{"e2e4", reply}))
+	f.botMove(g)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if g.connections != 2 || len(g.moves) != 4 {
+		t.Errorf("%d connections and moves %v; want 2 connections and 4 moves", g.connections, g.moves)
+	}
+}
+
+func TestBotIsolatesGameErrors(t *testing.T) {
+	f := newFakeLichess(t)
+	b := f.startBot(func(b *Bot) {
+		b.games = NewGameManager(2, 1)
+	})
+	broken := f.newGame("broken01", "startpos", false, nil)
+	healthy := f.newGame("healthy1", "startpos", false, nil)
+	f.start(broken)
+	f.start(healthy)
+	f.opponentMove(broken, "e2e4")
+	f.botMove(broken)
+	// a move list that does not build on the last one
+	f.mu.Lock()
+	broken.send(map[string]interface{}{"type": "gameState", "moves": "d2d4", "status": "started"})
+	f.mu.Unlock()
+	f.waitFor("the broken game to be given up", func() bool { return b.games.Len() == 1 })
+
+	f.opponentMove(healthy, "d2d4")
+	f.botMove(healthy)
+}
+
+// black to move with a bare king against three queens
+const hopeless = "6k1/8/8/8/8/8/8/QQQ1K3 b - - 0 1"
+
+func TestBotResigns(t *testing.T) {
+	f := newFakeLichess(t)
+	f.startBot(func(b *Bot) {
+		b.outcome.resignMoves = 2
+	})
+	g := f.newGame("resign01", hopeless, false, func(moves []string) string {
+		if len(moves) == 1 {
+			return "e1e2"
+		}
+		return ""
+	})
+	f.start(g)
+	f.botMove(g)
+	f.waitFor("the bot to resign", func() bool { return g.status == "resign" && g.winner == "white" })
+}
+
+func TestBotAnswersDrawOffers(t *testing.T) {
+	f := newFakeLichess(t)
+	f.startBot(func(b *Bot) {
+		b.games = NewGameManager(2, 1)
+		b.outcome.resignMoves = 0
+	})
+	losing := f.newGame("losing01", hopeless, false, nil)
+	winning := f.newGame("winning1", strings.Replace(hopeless, " b ", " w ", 1), true, nil)
+	f.start(losing)
+	f.start(winning)
+	f.botMove(losing)
+	f.botMove(winning)
+	f.opponentOffers(losing, "draw")
+	f.opponentOffers(winning, "draw")
+	f.waitFor("the losing game to be drawn", func() bool { return losing.status == "draw" })
+	f.waitFor("the winning game's draw offer to be declined", func() bool { return winning.drawOffer == "" })
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if winning.status != "started" {
+		t.Errorf("winning game status %q; want it to go on", winning.status)
+	}
+}
+
+func TestBotTakebacks(t *testing.T) {
+	for _, accept := range []bool{false, true} {
+		f := newFakeLichess(t)
+		f.startBot(func(b *Bot) {
+			b.outcome.takebacks = accept
+		})
+		g := f.newGame("takeback", "startpos", true, nil)
+		f.start(g)
+		f.botMove(g)
+		f.opponentMove(g, "e7e5")
+		f.botMove(g)
+		f.opponentOffers(g, "takeback")
+		f.waitFor("the takeback to be answered", func() bool { return g.takeback == "" })
+		f.mu.Lock()
+		plies := len(g.moves)
+		f.mu.Unlock()
+		if want := map[bool]int{false: 3, true: 1}[accept]; plies != want {
+			t.Errorf("%d half-moves after answering a takeback with takebacks %t; want %d", plies, accept, want)
+		}
+		// the bot follows the game on from there
+		f.opponentMove(g, "d7d5")
+		f.botMove(g)
+	}
+}
+
+func TestBotGameEndings(t *testing.T) {
+	f := newFakeLichess(t)
+	b := f.startBot(nil)
+	for _, status := range []string{"outoftime", "timeout", "draw", "aborted", "noStart"} {
+		g := f.newGame(status, "startpos", true, nil)
+		f.start(g)
+		f.botMove(g)
+		f.opponentEnds(g, status)
+		f.waitFor("the "+status+" game to be removed", func() bool {
+			_, err := os.Stat(filepath.Join(b.archiveDir, status+".pgn"))
+			return b.games.Len() == 0 && (err == nil || Aborted(status))
+		})
+	}
+	for _, status := range []string{"aborted", "noStart"} {
+		if _, err := os.Stat(filepath.Join(b.archiveDir, status+".pgn")); err == nil {
+			t.Errorf("game ending with %s archived", status)
+		}
+	}
+}
+
+func TestBotAbortsUnstartedGames(t *testing.T) {
+	f := newFakeLichess(t)
+	b := f.startBot(func(b *Bot) {
+		b.games = NewGameManager(3, 1)
+		b.abortAfter = 50 * time.Millisecond
+	})
+	waiting := f.newGame("waiting1", "startpos", false, nil)
+	moved := f.newGame("moved001", "startpos", true, nil)
+	started := f.newGame("started1", "startpos", false, nil)
+	f.start(waiting)
+	f.start(moved)
+	f.start(started)
+	f.botMove(moved)
+	f.opponentMove(started, "e2e4")
+	f.botMove(started)
+	f.waitFor("the unstarted games to be aborted", func() bool {
+		return waiting.status == "aborted" && moved.status == "aborted" && b.games.Len() == 1
+	})
+	time.Sleep(100 * time.Millisecond)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if started.status != "started" {
+		t.Errorf("game the opponent started ended with %s", started.status)
+	}
+}
+
+func TestBotChats(t *testing.T) {
+	f := newFakeLichess(t)
+	b := f.startBot(func(b *Bot) {
+		b.chat.announceMate = true
+	})
+	g := f.newGame("chat0001", "startpos", true, nil)
+	f.start(g)
+	f.botMove(g)
+	f.opponentSays(g, "spectator", "!depth")
+	f.opponentSays(g, "player", "good luck!")
+	f.waitFor("an answer", func() bool { return len(f.chat) == 2 })
+	f.opponentEnds(g, "resign")
+	f.waitFor("a goodbye", func() bool { return b.games.Len() == 0 && len(f.chat) == 3 })
+
+	mate := f.newGame("chat0002", "k7/8/1K6/8/8/8/7Q/8 w - - 0 1", true, nil)
+	f.start(mate)
+	f.botMove(mate)
+	f.waitFor("the mate game to be removed", func() bool { return b.games.Len() == 0 && len(f.chat) == 6 })
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	greeting, goodbye := "player: "+DefaultChatSettings().greeting, "player: "+DefaultChatSettings().goodbye
+	want := []string{
+		greeting,
+		"spectator: I searched 2 plies deep for my last move.",
+		goodbye,
+		greeting,
+		"player: Mate in 1.",
+		goodbye,
+	}
+	if strings.Join(f.chat, "\n") != strings.Join(want, "\n") {
+		t.Errorf("chat:\n%s\nwant\n%s", strings.Join(f.chat, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// ChallengePolicy decides which challenges the bot accepts
+type ChallengePolicy struct {
+	variants      []string // Lichess variant keys
+	minInitial    int      // seconds
+	maxInitial    int
+	minIncrement  int // seconds
+	maxIncrement  int
+	rated, casual bool
+	minRating     int
+	maxRating     int
+	bots, humans  bool
+	allow, deny   []string // lowercase user IDs; without an allow list, anyone not denied may play
+	queueSize     int      // challenges waiting for a free game slot
+}
+
+func DefaultChallengePolicy() ChallengePolicy {
+	return ChallengePolicy{
+		variants:     []string{"standard", "chess960"},
+		maxInitial:   3 * 60 * 60,
+		maxIncrement: 180,
+		rated:        true,
+		casual:       true,
+		maxRating:    4000,
+		bots:         true,
+		humans:       true,
+		queueSize:    5,
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// the Lichess decline reason for a challenge the policy rejects, or "" if it is acceptable
+func (cp ChallengePolicy) Reason(c *LichessChallenge) string {
+	var challenger LichessPlayer
+	if c.Challenger != nil {
+		challenger = *c.Challenger
+	}
+	id := strings.ToLower(challenger.Id)
+	bot := challenger.Title == "BOT"
+	switch {
+	case contains(cp.deny, id), len(cp.allow) > 0 && !contains(cp.allow, id):
+		return "generic"
+	case !contains(cp.variants, strings.ToLower(c.Variant.Key)):
+		if len(cp.variants) == 1 && cp.variants[0] == "standard" {
+			return "standard"
+		}
+		return "variant"
+	case c.TimeControl.Type != "clock":
+		return "timeControl"
+	case c.TimeControl.Limit < cp.minInitial:
+		return "tooFast"
+	case c.TimeControl.Limit > cp.maxInitial:
+		return "tooSlow"
+	case c.TimeControl.Increment < cp.minIncrement, c.TimeControl.Increment > cp.maxIncrement:
+		return "timeControl"
+	case c.Rated && !cp.rated:
+		return "casual"
+	case !c.Rated && !cp.casual:
+		return "rated"
+	case bot && !cp.bots:
+		return "noBot"
+	case !bot && !cp.humans:
+		return "onlyBot"
+	case challenger.Rating < cp.minRating, challenger.Rating > cp.maxRating:
+		return "generic"
+	}
+	return ""
+}
+
+// ChallengeQueue holds acceptable challenges until a game slot is free, oldest first
+type ChallengeQueue struct {
+	mu         sync.Mutex
+	challenges []*LichessChallenge
+	size       int
+}
+
+func NewChallengeQueue(size int) *ChallengeQueue {
+	return &ChallengeQueue{size: size}
+}
+
+// adds the challenge unless the queue is full
+func (q *ChallengeQueue) Push(c *LichessChallenge) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.challenges) >= q.size {
+		return false
+	}
+	q.challenges = append(q.challenges, c)
+	return true
+}
+
+// removes and returns the oldest challenge, or nil
+func (q *ChallengeQueue) Pop() *LichessChallenge {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.challenges) == 0 {
+		return nil
+	}
+	c := q.challenges[0]
+	q.challenges = q.challenges[1:]
+	return c
+}
+
+// drops a cancelled challenge, reporting whether it was queued
+func (q *ChallengeQueue) Remove(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, c := range q.challenges {
+		if c.Id == id {
+			q.challenges = append(q.challenges[:i], q.challenges[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (q *ChallengeQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.challenges)
+}
@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestChallengePolicy(t *testing.T) {
+	human := &LichessPlayer{Id: "Someone", Rating: 1500}
+	bot := &LichessPlayer{Id: "otherbot", Rating: 2000, Title: "BOT"}
+	blitz := LichessTimeControl{Type: "clock", Limit: 180, Increment: 2}
+	cp := DefaultChallengePolicy()
+	cp.minInitial = 60
+	cp.maxInitial = 900
+	cp.maxIncrement = 10
+	cp.maxRating = 2500
+	strict := cp
+	strict.variants = []string{"standard"}
+	strict.rated, strict.bots = false, false
+	strict.deny = []string{"troll"}
+	friends := cp
+	friends.allow = []string{"someone"}
+	friends.humans = false
+
+	tests := []struct {
+		policy ChallengePolicy
+		c      LichessChallenge
+		reason string
+	}{
+		{cp, LichessChallenge{Challenger: human, Variant: LichessVariant{Key: "standard"}, TimeControl: blitz}, ""},
+		{cp, LichessChallenge{Challenger: bot, Variant: LichessVariant{Key: "chess960"}, Rated: true, TimeControl: blitz}, ""},
+		{cp, LichessChallenge{Challenger: human, Variant: LichessVariant{Key: "atomic"}, TimeControl: blitz}, "variant"},
+		{strict, LichessChallenge{Challenger: human, Variant: LichessVariant{Key: "chess960"}, TimeControl: blitz}, "standard"},
+		{cp, LichessChallenge{Challenger: human, Variant: LichessVariant{Key: "standard"}, TimeControl: LichessTimeControl{Type: "unlimited"}}, "timeControl"},
+		{cp, LichessChallenge{Challenger: human, Variant: LichessVariant{Key: "standard"}, TimeControl: LichessTimeControl{Type: "clock", Limit: 30}}, "tooFast"},
+		{cp, LichessChallenge{Challenger: human, Variant: LichessVariant{Key: "standard"}, TimeControl: LichessTimeControl{Type: "clock", Limit: 1800}}, "tooSlow"},
+		{cp, LichessChallenge{Challenger: human, Variant: LichessVariant{Key: "standard"}, TimeControl: LichessTimeControl{Type: "clock", Limit: 300, Increment: 30}}, "timeControl"},
+		{strict, LichessChallenge{Challenger: human, Variant: LichessVariant{Key: "standard"}, Rated: true, TimeControl: blitz}, "casual"},
+		{strict, LichessChallenge{Challenger: bot, Variant: LichessVariant{Key: "standard"}, TimeControl: blitz}, "noBot"},
+		{strict, LichessChallenge{Challenger: &LichessPlayer{Id: "Troll"}, Variant: LichessVariant{Key: "standard"}, TimeControl: blitz}, "generic"},
+		{cp, LichessChallenge{Challenger: &LichessPlayer{Id: "strong", Rating: 2800}, Variant: LichessVariant{Key: "standard"}, TimeControl: blitz}, "generic"},
+		{friends, LichessChallenge{Challenger: human, Variant: LichessVariant{Key: "standard"}, TimeControl: blitz}, "onlyBot"},
+		{friends, LichessChallenge{Challenger: bot, Variant: LichessVariant{Key: "standard"}, TimeControl: blitz}, "generic"},
+	}
+	for i, test := range tests {
+		if reason := test.policy.Reason(&test.c); reason != test.reason {
+			t.Errorf("%d: Reason() = %q; want %q", i, reason, test.reason)
+		}
+	}
+}
+
+func TestChallengeQueue(t *testing.T) {
+	q := NewChallengeQueue(2)
+	a, b, c := &LichessChallenge{Id: "a"}, &LichessChallenge{Id: "b"}, &LichessChallenge{Id: "c"}
+	if !q.Push(a) || !q.Push(b) || q.Push(c) {
+		t.Fatal("Push() should fill the queue at 2 challenges")
+	}
+	if !q.Remove("a") || q.Remove("c") || q.Len() != 1 {
+		t.Errorf("Remove() of a cancelled challenge left %d queued; want 1", q.Len())
+	}
+	q.Push(c)
+	if q.Pop() != b || q.Pop() != c || q.Pop() != nil {
+		t.Error("Pop() should return queued challenges oldest first")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChatSettings are what the bot says in games without being asked
+type ChatSettings struct {
+	greeting     string // when a game starts, "" to say nothing
+	goodbye      string // when a game that was not aborted ends
+	announceMate bool   // once the bot has found a forced mate
+}
+
+func DefaultChatSettings() ChatSettings {
+	return ChatSettings{
+		greeting: "Hi! I'm stupid-horse. Type !help to see what I answer to.",
+		goodbye:  "Good game!",
+	}
+}
+
+// SearchInfo is what the bot's latest search in a game found
+type SearchInfo struct {
+	book  bool // the move came from the opening book instead
+	depth int
+	eval  int      // from white's point of view
+	pv    []string // in SAN
+}
+
+// the moves in SAN, played one after another from the position
+func sanLine(p Position, moves []Move) []string {
+	var line []string
+	for _, m := range moves {
+		line = append(line, p.MoveToSAN(m))
+		p = p.ProcessMove(m)
+	}
+	return line
+}
+
+const chatHelp = "I answer to !eval, !pv, !depth, !name and !help."
+
+// the bot's answer to a chat message, or "" if it is not a command the bot knows
+func chatAnswer(game *Game, text string) string {
+	fields := strings.Fields(strings.ToLower(text))
+	if len(fields) == 0 {
+		return ""
+	}
+	info := game.search
+	switch fields[0] {
+	case "!help":
+		return chatHelp
+	case "!name":
+		return "stupid-horse by plin0009, a chess engine written in Go."
+	case "!eval", "!pv", "!depth":
+		if info == nil {
+			return "I have not searched anything yet."
+		}
+		if info.book {
+			return "I played my last move from my opening book."
+		}
+	default:
+		return ""
+	}
+	switch fields[0] {
+	case "!eval":
+		return fmt.Sprintf("Evaluation %s (from White's point of view) at depth %d.", FormatEval(info.eval), info.depth)
+	case "!pv":
+		if len(info.pv) == 0 {
+			return "I expect no more moves."
+		}
+		return "I expect " + strings.Join(info.pv, " ") + "."
+	default:
+		return fmt.Sprintf("I searched %d plies deep for my last move.", info.depth)
+	}
+}
+
+// Pacer spaces out requests to stay within a rate limit
+type Pacer struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func NewPacer(interval time.Duration) *Pacer {
+	return &Pacer{interval: interval}
+}
+
+// waits until the next request may be sent, without holding up other callers meanwhile
+func (p *Pacer) Wait() {
+	p.mu.Lock()
+	at := p.next
+	if now := time.Now(); at.Before(now) {
+		at = now
+	}
+	p.next = at.Add(p.interval)
+	p.mu.Unlock()
+	time.Sleep(time.Until(at))
+}
+
+// messages a game's chat holds back before it drops new ones, so that a flood of commands
+// cannot keep the bot chatting long after it
+const chatQueueSize = 4
+
+type chatMessage struct {
+	room, text string
+}
+
+// starts sending the game's chat messages in the background, spaced out from the bot's
+// other messages, until the returned function is called; messages queued by then are still
+// sent
+func (b *Bot) startChat(game *Game) (stop func()) {
+	queue := make(chan chatMessage, chatQueueSize)
+	game.chat = queue
+	go func() {
+		for m := range queue {
+			b.sendChat(game.id, m.room, m.text)
+		}
+	}()
+	return func() {
+		game.chat = nil
+		close(queue)
+	}
+}
+
+// queues a chat message in the game, dropping it if too many are waiting
+func (b *Bot) say(game *Game, room, text string) {
+	if text == "" {
+		return
+	}
+	if game.chat == nil {
+		// not followed by a game stream, so there is nothing to hold up
+		b.sendChat(game.id, room, text)
+		return
+	}
+	select {
+	case game.chat <- chatMessage{room, text}:
+	default:
+		warnf("Dropped chat message %q in game %s, as too many are waiting", text, game.id)
+	}
+}
+
+func (b *Bot) sendChat(gameId, room, text string) {
+	b.chatPace.Wait()
+	if err := b.api.Chat(gameId, room, text); err != nil {
+		b.report(&GameError{gameId, err})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChatAnswer(t *testing.T) {
+	g := NewGame(StartFEN, nil, nil)
+	if answer := chatAnswer(g, "!eval"); answer != "I have not searched anything yet." {
+		t.Errorf("chatAnswer(!eval) before a search = %q", answer)
+	}
+	g.search = &SearchInfo{depth: 4, eval: -12, pv: []string{"e4", "e5", "Nf3"}}
+	tests := []struct {
+		text, answer string
+	}{
+		{"!eval", "Evaluation -1.20 (from White's point of view) at depth 4."},
+		{"  !PV please", "I expect e4 e5 Nf3."},
+		{"!depth", "I searched 4 plies deep for my last move."},
+		{"!help", chatHelp},
+		{"!name", "stupid-horse by plin0009, a chess engine written in Go."},
+		{"good luck!", ""},
+		{"!resign", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if answer := chatAnswer(g, test.text); answer != test.answer {
+			t.Errorf("chatAnswer(%q) = %q; want %q", test.text, answer, test.answer)
+		}
+	}
+	g.search = &SearchInfo{book: true}
+	if answer := chatAnswer(g, "!pv"); !strings.Contains(answer, "book") {
+		t.Errorf("chatAnswer(!pv) after a book move = %q", answer)
+	}
+}
+
+func TestSANLine(t *testing.T) {
+	pos := LoadInitialPosition(StartFEN)
+	moves := []Move{pos.StringToMove("g1f3")}
+	next := pos.ProcessMove(moves[0])
+	moves = append(moves, next.StringToMove("g8f6"))
+	if line := strings.Join(sanLine(pos, moves), " "); line != "Nf3 Nf6" {
+		t.Errorf("sanLine() = %q; want %q", line, "Nf3 Nf6")
+	}
+}
+
+func TestPacer(t *testing.T) {
+	p := NewPacer(20 * time.Millisecond)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		p.Wait()
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("three paced requests took %v; want at least 40ms", elapsed)
+	}
+}
+
+func TestSayQueues(t *testing.T) {
+	b := &Bot{chatPace: NewPacer(time.Hour)}
+	queue := make(chan chatMessage, chatQueueSize)
+	g := NewGame(StartFEN, nil, nil)
+	g.chat = queue
+	// a flood of answers neither blocks the game's stream nor piles up
+	start := time.Now()
+	for i := 0; i < chatQueueSize+3; i++ {
+		b.say(g, "spectator", chatHelp)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("say() took %v", elapsed)
+	}
+	if len(queue) != chatQueueSize {
+		t.Errorf("say() queued %d messages; want %d", len(queue), chatQueueSize)
+	}
+}
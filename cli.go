@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// the binary's commands, in the order help lists them
+var commands = []struct {
+	name, args, summary string
+}{
+	{"lichess", "[flags]", "play on Lichess as a bot (the default without a command)"},
+	{"uci", "", "speak the Universal Chess Interface on standard input and output"},
+	{"perft", "[flags]", "count the leaf nodes of the move tree to check the move generator"},
+	{"analyse", "[flags] <fen | file.pgn>", "search a position, or annotate every game of a PGN file"},
+	{"bench", "[flags]", "search a fixed set of positions and report the nodes searched per second"},
+	{"epd", "[flags] file.epd ...", "run an EPD test suite"},
+	{"match", "[flags]", "play two engines against each other"},
+	{"selfplay", "[flags]", "play the engine against itself"},
+	{"play", "[flags]", "play against the engine in the terminal"},
+	{"book", "[flags] games.pgn ...", "build a Polyglot opening book from PGN files"},
+	{"config", "check [flags]", "print the bot's settings and what is wrong with them"},
+}
+
+// lists the commands
+func commandsUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: stupid-horse <command> [arguments]")
+	fmt.Fprintln(w)
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-9s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "stupid-horse <command> -help" for the arguments and flags of a command.`)
+}
+
+// describes the command named by the flag set, if it is one, on -help
+func setCommandUsage(fs *flag.FlagSet) {
+	for _, c := range commands {
+		if c.name == fs.Name() {
+			fs.Usage = func() {
+				fmt.Fprintf(fs.Output(), "usage: stupid-horse %s %s\n\n%s.\n\n", c.name, c.args, c.summary)
+				fs.PrintDefaults()
+			}
+			return
+		}
+	}
+}
+
+// the flag set of a command, exiting on bad flags
+func commandFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	setCommandUsage(fs)
+	return fs
+}
+
+// prints the command's usage and exits, e.g. for missing arguments
+func usageError(fs *flag.FlagSet) {
+	fs.Usage()
+	os.Exit(2)
+}
+
+// whether the argument asks for help, as the flag package's -h and -help do
+func isHelp(arg string) bool {
+	switch arg {
+	case "-h", "-help", "--help", "help":
+		return true
+	}
+	return false
+}
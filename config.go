@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// read if present when no other config file is given
+const defaultConfigFile = "stupid-horse.toml"
+
+// Config is everything the bot is set up with: the defaults, changed by a TOML config file,
+// then by environment variables, then by command-line flags
+type Config struct {
+	token       string
+	botId       string // looked up from the token if empty
+	url         string
+	hash        int // transposition table entries per game
+	threads     int
+	depth       int
+	ponder      bool
+	personality string
+	book        string
+	bookDepth   int
+	syzygyPath  string
+	overhead    time.Duration // kept back from the clock for network lag
+	maxMoveTime time.Duration // 0 for no limit besides the clock
+	maxGames    int
+	abortAfter  time.Duration
+	archiveDir  string
+	challenge   ChallengePolicy
+	outcome     OutcomePolicy
+	chat        ChatSettings
+	logLevel    string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		url:         "https://lichess.org",
+		hash:        TTMaxSize,
+		threads:     runtime.NumCPU(),
+		depth:       6,
+		ponder:      true,
+		personality: "stupid-horse",
+		bookDepth:   20,
+		overhead:    500 * time.Millisecond,
+		maxGames:    1,
+		abortAfter:  30 * time.Second,
+		archiveDir:  "games",
+		challenge:   DefaultChallengePolicy(),
+		outcome:     DefaultOutcomePolicy(),
+		chat:        DefaultChatSettings(),
+		logLevel:    "info",
+	}
+}
+
+// a setting of the config file, which the environment variable, if any, and the flag named
+// section.key also set
+type setting struct {
+	section, key string
+	env          string
+	usage        string
+	value        interface{} // *string, *int, *bool, *time.Duration or *[]string
+	secret       bool        // masked when printed
+}
+
+func (s *setting) name() string {
+	return s.section + "." + s.key
+}
+
+// the config's settings, in the order they are printed
+func (c *Config) settings() []*setting {
+	cp, op, cs := &c.challenge, &c.outcome, &c.chat
+	return []*setting{
+		{"lichess", "token", "LICHESS_KEY", "API token of the bot account", &c.token, true},
+		{"lichess", "bot_id", "LICHESS_BOT_ID", "ID of the bot account, looked up from the token if empty", &c.botId, false},
+		{"lichess", "url", "LICHESS_URL", "Lichess server", &c.url, false},
+		{"engine", "hash", "HASH", "transposition table entries per game", &c.hash, false},
+		{"engine", "threads", "THREADS", "search threads shared between games", &c.threads, false},
+		{"engine", "depth", "DEPTH", "deepest search for a move, in plies", &c.depth, false},
+		{"engine", "ponder", "PONDER", "search the expected reply on the opponent's time", &c.ponder, false},
+		{"engine", "personality", "PERSONALITY", "piece values: stupid-horse or classical", &c.personality, false},
+		{"engine", "book", "BOOK_FILE", "Polyglot opening book", &c.book, false},
+		{"engine", "book_depth", "BOOK_DEPTH", "half-moves to play from the book", &c.bookDepth, false},
+		{"engine", "syzygy_path", "SYZYGY_PATH", "Syzygy tablebase directories, separated by colons", &c.syzygyPath, false},
+		{"time", "move_overhead", "MOVE_OVERHEAD", "time kept back from the clock for network lag", &c.overhead, false},
+		{"time", "max_move_time", "MAX_MOVE_TIME", "longest search for a move, 0 for no limit", &c.maxMoveTime, false},
+		{"games", "max_games", "MAX_GAMES", "games played at once", &c.maxGames, false},
+		{"games", "abort_after", "ABORT_AFTER", "wait for the opponent's first move before aborting, 0 to wait forever", &c.abortAfter, false},
+		{"games", "archive_dir", "PGN_ARCHIVE_DIR", "directory finished games are saved to", &c.archiveDir, false},
+		{"challenge", "variants", "CHALLENGE_VARIANTS", "Lichess variant keys to accept", &cp.variants, false},
+		{"challenge", "min_initial", "CHALLENGE_MIN_INITIAL", "shortest initial clock, in seconds", &cp.minInitial, false},
+		{"challenge", "max_initial", "CHALLENGE_MAX_INITIAL", "longest initial clock, in seconds", &cp.maxInitial, false},
+		{"challenge", "min_increment", "CHALLENGE_MIN_INCREMENT", "smallest increment, in seconds", &cp.minIncrement, false},
+		{"challenge", "max_increment", "CHALLENGE_MAX_INCREMENT", "largest increment, in seconds", &cp.maxIncrement, false},
+		{"challenge", "rated", "CHALLENGE_RATED", "accept rated games", &cp.rated, false},
+		{"challenge", "casual", "CHALLENGE_CASUAL", "accept casual games", &cp.casual, false},
+		{"challenge", "min_rating", "CHALLENGE_MIN_RATING", "lowest challenger rating", &cp.minRating, false},
+		{"challenge", "max_rating", "CHALLENGE_MAX_RATING", "highest challenger rating", &cp.maxRating, false},
+		{"challenge", "bots", "CHALLENGE_BOTS", "accept challenges from bots", &cp.bots, false},
+		{"challenge", "humans", "CHALLENGE_HUMANS", "accept challenges from humans", &cp.humans, false},
+		{"challenge", "allow", "CHALLENGE_ALLOW", "the only users to accept, if any", &cp.allow, false},
+		{"challenge", "deny", "CHALLENGE_DENY", "users to decline", &cp.deny, false},
+		{"challenge", "queue", "CHALLENGE_QUEUE", "challenges waiting for a free game slot", &cp.queueSize, false},
+		{"outcome", "resign_score", "RESIGN_SCORE", "centipawns below which the bot is lost", &op.resignScore, false},
+		{"outcome", "resign_moves", "RESIGN_MOVES", "lost moves in a row before resigning, 0 to never resign", &op.resignMoves, false},
+		{"outcome", "draw_accept_score", "DRAW_ACCEPT_SCORE", "centipawns below which draw offers are accepted", &op.acceptDrawScore, false},
+		{"outcome", "draw_offer_score", "DRAW_OFFER_SCORE", "centipawns either way within which an endgame is dead equal", &op.offerDrawScore, false},
+		{"outcome", "draw_offer_moves", "DRAW_OFFER_MOVES", "dead-equal moves before offering a draw, 0 to never offer", &op.offerDrawMoves, false},
+		{"outcome", "draw_offer_after", "DRAW_OFFER_AFTER", "full moves before offering draws", &op.offerDrawAfter, false},
+		{"outcome", "draw_offer_pieces", "DRAW_OFFER_PIECES", "most pieces on the board of an endgame", &op.offerDrawPieces, false},
+		{"outcome", "takebacks", "ACCEPT_TAKEBACKS", "grant the opponent's takeback requests", &op.takebacks, false},
+		{"chat", "greeting", "CHAT_GREETING", "said when a game starts, empty to say nothing", &cs.greeting, false},
+		{"chat", "goodbye", "CHAT_GOODBYE", "said when a game ends, empty to say nothing", &cs.goodbye, false},
+		{"chat", "announce_mate", "CHAT_ANNOUNCE_MATE", "announce forced mates", &cs.announceMate, false},
+		{"log", "level", "LOG_LEVEL", "debug, info, warn or error", &c.logLevel, false},
+	}
+}
+
+// sets the value from its text in an environment variable or flag
+func (s *setting) parse(text string) error {
+	switch v := s.value.(type) {
+	case *string:
+		*v = text
+	case *int:
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a whole number", s.name(), text)
+		}
+		*v = n
+	case *bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not true or false", s.name(), text)
+		}
+		*v = b
+	case *time.Duration:
+		d, err := parseDuration(text)
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.name(), err)
+		}
+		*v = d
+	case *[]string:
+		*v = normalizeList(strings.Split(text, ","))
+	}
+	return nil
+}
+
+// sets the value from the config file
+func (s *setting) assign(value interface{}) error {
+	ok := true
+	switch v := s.value.(type) {
+	case *string:
+		*v, ok = value.(string)
+	case *int:
+		*v, ok = value.(int)
+	case *bool:
+		*v, ok = value.(bool)
+	case *time.Duration:
+		switch x := value.(type) {
+		case int:
+			*v = time.Duration(x) * time.Second
+		case string:
+			d, err := parseDuration(x)
+			if err != nil {
+				return fmt.Errorf("%s: %w", s.name(), err)
+			}
+			*v = d
+		default:
+			ok = false
+		}
+	case *[]string:
+		var items []interface{}
+		items, ok = value.([]interface{})
+		var list []string
+		for _, item := range items {
+			s, isString := item.(string)
+			ok = ok && isString
+			list = append(list, s)
+		}
+		*v = normalizeList(list)
+	}
+	if !ok {
+		return fmt.Errorf("%s: %s is not %s", s.name(), formatTOML(value), s.kind())
+	}
+	return nil
+}
+
+// describes the values the setting takes
+func (s *setting) kind() string {
+	switch s.value.(type) {
+	case *int:
+		return "a whole number"
+	case *bool:
+		return "true or false"
+	case *time.Duration:
+		return `a duration such as "30s"`
+	case *[]string:
+		return "a list of strings"
+	}
+	return "a string"
+}
+
+// the value as written in the config file
+func (s *setting) format() string {
+	switch v := s.value.(type) {
+	case *string:
+		if s.secret && *v != "" {
+			return formatTOML("********")
+		}
+		return formatTOML(*v)
+	case *int:
+		return formatTOML(*v)
+	case *bool:
+		return formatTOML(*v)
+	case *time.Duration:
+		return formatTOML(v.String())
+	case *[]string:
+		items := []interface{}{}
+		for _, item := range *v {
+			items = append(items, item)
+		}
+		return formatTOML(items)
+	}
+	return ""
+}
+
+// a duration such as "1m30s", or a whole number of seconds
+func parseDuration(text string) (time.Duration, error) {
+	if n, err := strconv.Atoi(text); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(text)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a duration", text)
+	}
+	return d, nil
+}
+
+// trims and lowercases the items, dropping empty ones
+func normalizeList(items []string) []string {
+	var list []string
+	for _, item := range items {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// a flag that is applied after the config file and the environment
+type settingFlag struct {
+	s    *setting
+	text string
+}
+
+func (f *settingFlag) String() string {
+	if f.s == nil {
+		return ""
+	}
+	return f.s.format()
+}
+
+func (f *settingFlag) Set(text string) error {
+	f.text = text
+	return nil
+}
+
+func (f *settingFlag) IsBoolFlag() bool {
+	if f.s == nil {
+		return false
+	}
+	_, ok := f.s.value.(*bool)
+	return ok
+}
+
+// loads the config from the file named by the -config flag (or stupid-horse.toml, if there is
+// one), the environment, including a .env file, and the rest of the flags in args
+func LoadConfig(name string, args []string) (Config, error) {
+	c := DefaultConfig()
+	settings := c.settings()
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	setCommandUsage(fs)
+	filename := fs.String("config", "", "TOML config file (default "+defaultConfigFile+" if there is one)")
+	for _, s := range settings {
+		fs.Var(&settingFlag{s: s}, s.name(), fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+	if fs.NArg() > 0 {
+		return c, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	if *filename != "" || fileExists(defaultConfigFile) {
+		if *filename == "" {
+			*filename = defaultConfigFile
+		}
+		f, err := os.Open(*filename)
+		if err != nil {
+			return c, err
+		}
+		defer f.Close()
+		if err := c.read(f); err != nil {
+			return c, fmt.Errorf("%s: %w", *filename, err)
+		}
+	}
+
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		return c, fmt.Errorf("could not load .env file: %w", err)
+	}
+	for _, s := range settings {
+		text, ok := os.LookupEnv(s.env)
+		if _, isString := s.value.(*string); !ok || text == "" && !isString {
+			continue
+		}
+		if err := s.parse(text); err != nil {
+			return c, fmt.Errorf("environment variable %s: %w", s.env, err)
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if sf, ok := f.Value.(*settingFlag); ok && err == nil {
+			if e := sf.s.parse(sf.text); e != nil {
+				err = fmt.Errorf("flag -%w", e)
+			}
+		}
+	})
+	return c, err
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// sets the settings found in a config file
+func (c *Config) read(r io.Reader) error {
+	values, err := parseTOML(r)
+	if err != nil {
+		return err
+	}
+	byName := map[string]*setting{}
+	for _, s := range c.settings() {
+		byName[s.name()] = s
+	}
+	for _, v := range values {
+		name := strings.TrimPrefix(v.section+"."+v.key, ".")
+		s, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("line %d: unknown setting %s", v.line, name)
+		}
+		if err := s.assign(v.value); err != nil {
+			return fmt.Errorf("line %d: %w", v.line, err)
+		}
+	}
+	return nil
+}
+
+// writes the settings in the format of the config file, with the token masked
+func (c *Config) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	section := ""
+	for _, s := range c.settings() {
+		if s.section != section {
+			if section != "" {
+				fmt.Fprintln(bw)
+			}
+			section = s.section
+			fmt.Fprintf(bw, "[%s]\n", section)
+		}
+		fmt.Fprintf(bw, "%s = %s\n", s.key, s.format())
+	}
+	return bw.Flush()
+}
+
+// what is wrong with the config, if anything
+func (c *Config) Problems() []string {
+	var problems []string
+	check := func(ok bool, format string, a ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, a...))
+		}
+	}
+	check(c.token != "", "lichess.token is not set")
+	u, err := url.Parse(c.url)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "lichess.url %q is not an http(s) URL", c.url)
+	check(c.hash >= 1, "engine.hash must be at least 1")
+	check(c.threads >= 1, "engine.threads must be at least 1")
+	check(c.depth >= 1 && c.depth <= MaxPly, "engine.depth must be between 1 and %d", MaxPly)
+	_, ok := personalities[c.personality]
+	check(ok, "engine.personality %q is not stupid-horse or classical", c.personality)
+	check(c.book == "" || fileExists(c.book), "engine.book %q does not exist", c.book)
+	check(c.bookDepth >= 0, "engine.book_depth must not be negative")
+	if c.syzygyPath != "" {
+		for _, dir := range strings.Split(c.syzygyPath, ":") {
+			check(fileExists(dir), "engine.syzygy_path directory %q does not exist", dir)
+		}
+	}
+	check(c.overhead >= 0, "time.move_overhead must not be negative")
+	check(c.maxMoveTime >= 0, "time.max_move_time must not be negative")
+	check(c.maxGames >= 1, "games.max_games must be at least 1")
+	check(c.abortAfter >= 0, "games.abort_after must not be negative")
+	check(c.archiveDir != "", "games.archive_dir is not set")
+	cp := c.challenge
+	check(len(cp.variants) > 0, "challenge.variants is empty")
+	for _, v := range cp.variants {
+		check(v == "standard" || v == "chess960" || v == "fromposition", "challenge.variants: the bot cannot play %s", v)
+	}
+	check(cp.minInitial <= cp.maxInitial, "challenge.min_initial is above challenge.max_initial")
+	check(cp.minIncrement <= cp.maxIncrement, "challenge.min_increment is above challenge.max_increment")
+	check(cp.minRating <= cp.maxRating, "challenge.min_rating is above challenge.max_rating")
+	check(cp.rated || cp.casual, "challenge.rated and challenge.casual are both false")
+	check(cp.bots || cp.humans, "challenge.bots and challenge.humans are both false")
+	check(cp.queueSize >= 0, "challenge.queue must not be negative")
+	op := c.outcome
+	check(op.resignMoves >= 0, "outcome.resign_moves must not be negative")
+	check(op.offerDrawMoves >= 0, "outcome.draw_offer_moves must not be negative")
+	check(op.offerDrawScore >= 0, "outcome.draw_offer_score must not be negative")
+	_, ok = logLevelNames[c.logLevel]
+	check(ok, "log.level %q is not debug, info, warn or error", c.logLevel)
+	return problems
+}
+
+// config check [-config file] [flags]: prints the settings the bot would start with and
+// what is wrong with them
+func StartConfig(args []string) {
+	if len(args) == 0 || args[0] != "check" {
+		fs := flag.NewFlagSet("config", flag.ExitOnError)
+		setCommandUsage(fs)
+		if len(args) > 0 && isHelp(args[0]) {
+			fs.Usage()
+			return
+		}
+		usageError(fs)
+	}
+	c, err := LoadConfig("config", args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	c.Write(os.Stdout)
+	problems := c.Problems()
+	if len(problems) == 0 {
+		fmt.Println("\n# the config is valid")
+		return
+	}
+	fmt.Println()
+	for _, p := range problems {
+		fmt.Println("# error:", p)
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTOML(t *testing.T) {
+	input := `# a comment
+top = 1
+
+[lichess]
+token = "lip_\"x\"é" # trailing comment
+url = 'https://example.org/#not-a-comment'
+[ engine ]
+hash = 1_000
+ponder = false
+list = ["a", 'b',]
+empty = []
+`
+	want := []tomlValue{
+		{2, "", "top", 1},
+		{5, "lichess", "token", `lip_"x"é`},
+		{6, "lichess", "url", "https://example.org/#not-a-comment"},
+		{8, "engine", "hash", 1000},
+		{9, "engine", "ponder", false},
+		{10, "engine", "list", []interface{}{"a", "b"}},
+		{11, "engine", "empty", []interface{}{}},
+	}
+	values, err := parseTOML(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("parseTOML() = %v; want %v", values, want)
+	}
+
+	tests := []struct {
+		input, err string
+	}{
+		{"key", "line 1: expected key = value"},
+		{"[section\nkey = 1", "line 1: bad section header"},
+		{"a b = 1", "line 1: bad key"},
+		{"a = 1\na = 2", "line 2: a is set twice"},
+		{"a = \"open", "line 1: unterminated string"},
+		{"a = [1, 2", "line 1: expected , or ]"},
+		{"a = 1 2", "line 1: unexpected 2 after the value"},
+		{"a = yes", "line 1: bad value yes"},
+		{"a =", "line 1: missing value"},
+	}
+	for _, test := range tests {
+		_, err := parseTOML(strings.NewReader(test.input))
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("parseTOML(%q) error = %v; want %q", test.input, err, test.err)
+		}
+	}
+}
+
+func TestConfigRead(t *testing.T) {
+	c := DefaultConfig()
+	err := c.read(strings.NewReader(`
+[engine]
+depth = 8
+[games]
+abort_after = 45
+[time]
+move_overhead = "250ms"
+[challenge]
+variants = [" Standard "]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.depth != 8 || c.abortAfter != 45*time.Second || c.overhead != 250*time.Millisecond || !reflect.DeepEqual(c.challenge.variants, []string{"standard"}) {
+		t.Errorf("read() = depth %d, abort_after %v, move_overhead %v, variants %v", c.depth, c.abortAfter, c.overhead, c.challenge.variants)
+	}
+
+	tests := []struct {
+		input, err string
+	}{
+		{"[engine]\nsize = 1", "line 2: unknown setting engine.size"},
+		{"depth = 1", "line 1: unknown setting depth"},
+		{"[engine]\ndepth = \"8\"", `line 2: engine.depth: "8" is not a whole number`},
+		{"[engine]\nponder = 1", "line 2: engine.ponder: 1 is not true or false"},
+		{"[games]\nabort_after = \"soon\"", `line 2: games.abort_after: "soon" is not a duration`},
+		{"[challenge]\nallow = [\"a\", 1]", "line 2: challenge.allow: [\"a\", 1] is not a list of strings"},
+	}
+	for _, test := range tests {
+		c := DefaultConfig()
+		if err := c.read(strings.NewReader(test.input)); err == nil || err.Error() != test.err {
+			t.Errorf("read(%q) error = %v; want %q", test.input, err, test.err)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "bot.toml")
+	err := os.WriteFile(filename, []byte(`
+[lichess]
+token = "from-file"
+[engine]
+depth = 8
+threads = 2
+hash = 1000
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("DEPTH", "7")
+	os.Setenv("THREADS", "3")
+	os.Setenv("CHAT_GOODBYE", "")
+	defer os.Unsetenv("DEPTH")
+	defer os.Unsetenv("THREADS")
+	defer os.Unsetenv("CHAT_GOODBYE")
+
+	c, err := LoadConfig("test", []string{"-config", filename, "-engine.depth", "9", "-engine.ponder=false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flags beat the environment, which beats the file, which beats the defaults
+	if c.token != "from-file" || c.hash != 1000 || c.threads != 3 || c.depth != 9 || c.ponder || c.chat.goodbye != "" || c.bookDepth != 20 {
+		t.Errorf("LoadConfig() = token %q, hash %d, threads %d, depth %d, ponder %t, goodbye %q, book_depth %d", c.token, c.hash, c.threads, c.depth, c.ponder, c.chat.goodbye, c.bookDepth)
+	}
+
+	errs := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-config", filename + ".missing"}, "no such file"},
+		{[]string{"-engine.depth", "deep"}, `flag -engine.depth: "deep" is not a whole number`},
+		{[]string{"extra"}, `unexpected argument "extra"`},
+	}
+	for _, test := range errs {
+		if _, err := LoadConfig("test", test.args); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("LoadConfig(%q) error = %v; want %q", test.args, err, test.err)
+		}
+	}
+	os.Setenv("THREADS", "many")
+	if _, err := LoadConfig("test", nil); err == nil || !strings.Contains(err.Error(), "environment variable THREADS") {
+		t.Errorf("LoadConfig() with THREADS=many error = %v", err)
+	}
+}
+
+func TestConfigProblems(t *testing.T) {
+	tests := []struct {
+		change  func(c *Config)
+		problem string
+	}{
+		{func(c *Config) {}, ""},
+		{func(c *Config) { c.token = "" }, "lichess.token is not set"},
+		{func(c *Config) { c.url = "lichess.org" }, "lichess.url"},
+		{func(c *Config) { c.depth = 0 }, "engine.depth must be between"},
+		{func(c *Config) { c.threads = 0 }, "engine.threads must be at least 1"},
+		{func(c *Config) { c.personality = "brave" }, "engine.personality"},
+		{func(c *Config) { c.book = "missing.bin" }, `engine.book "missing.bin" does not exist`},
+		{func(c *Config) { c.syzygyPath = "testdata:missing" }, `engine.syzygy_path directory "missing" does not exist`},
+		{func(c *Config) { c.overhead = -time.Second }, "time.move_overhead must not be negative"},
+		{func(c *Config) { c.challenge.variants = []string{"atomic"} }, "the bot cannot play atomic"},
+		{func(c *Config) { c.challenge.minRating = 5000 }, "challenge.min_rating is above"},
+		{func(c *Config) { c.logLevel = "loud" }, "log.level"},
+	}
+	for _, test := range tests {
+		c := DefaultConfig()
+		c.token = "lip_token"
+		test.change(&c)
+		problems := c.Problems()
+		if test.problem == "" {
+			if len(problems) > 0 {
+				t.Errorf("Problems() of a valid config = %v", problems)
+			}
+			continue
+		}
+		if len(problems) != 1 || !strings.Contains(problems[0], test.problem) {
+			t.Errorf("Problems() = %v; want one containing %q", problems, test.problem)
+		}
+	}
+}
+
+func TestConfigWrite(t *testing.T) {
+	c := DefaultConfig()
+	c.token = "lip_secret"
+	c.chat.greeting = `say "hi"`
+	var sb strings.Builder
+	if err := c.Write(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sb.String(), "lip_secret") {
+		t.Error("Write() printed the token")
+	}
+	// what is written reads back to the same config, but for the token
+	read := DefaultConfig()
+	if err := read.read(strings.NewReader(sb.String())); err != nil {
+		t.Fatal(err)
+	}
+	read.token = c.token
+	if !reflect.DeepEqual(read, c) {
+		t.Errorf("Write() then read() = %+v; want %+v", read, c)
+	}
+}
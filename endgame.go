@@ -0,0 +1,302 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// evaluation of an endgame that is won by technique, below tablebase wins and above any material count
+const knownWinValue = 10000
+
+// piece counts indexed by colour (white first) and piece type
+type materialCount [2][7]int
+
+func (mc *materialCount) add(piece Piece) {
+	mc[boolToInt(bool(piece.Colour()))][piece.Type()]++
+}
+
+func (mc *materialCount) of(c PieceColour) [7]int {
+	return mc[boolToInt(bool(c))]
+}
+
+// e.g. "KBN" for a king, bishop and knight
+func materialSignature(counts [7]int) string {
+	var sb strings.Builder
+	for _, pt := range []PieceType{King, Queen, Rook, Bishop, Knight, Pawn} {
+		sb.WriteString(strings.Repeat(strings.ToUpper(pt.String()), counts[pt]))
+	}
+	return sb.String()
+}
+
+// evaluates an endgame against a bare king from the strong side's point of view
+type endgameEval func(p Position, strong PieceColour) int
+
+// endgames recognised by the material of the strong side against a bare king
+var endgames = map[string]endgameEval{
+	"KQvK":  evalKXK,
+	"KRvK":  evalKXK,
+	"KBNvK": evalKBNK,
+	"KPvK":  evalKPK,
+	"KvK":   evalDraw,
+	"KNvK":  evalDraw,
+	"KBvK":  evalDraw,
+	"KNNvK": evalDraw,
+}
+
+// the evaluation from white's point of view of an endgame with specialised knowledge, if any
+func evalEndgame(p Position, mc *materialCount) (int, bool) {
+	for _, strong := range []PieceColour{White, Black} {
+		weak := mc.of(strong.Flip())
+		if weak != [7]int{King: 1} {
+			continue
+		}
+		us := mc.of(strong)
+		if eval, ok := endgames[materialSignature(us)+"vK"]; ok {
+			return colourMultiplier[strong] * eval(p, strong), true
+		}
+		// any other heavy piece or a pair of bishops mates the same way
+		if us[Queen] > 0 || us[Rook] > 0 || us[Bishop] > 1 {
+			return colourMultiplier[strong] * evalKXK(p, strong), true
+		}
+	}
+	return 0, false
+}
+
+// scales down an evaluation from white's point of view when the material makes a draw likely
+func scaleEndgame(score int, mc *materialCount, bishops [2]Square) int {
+	white, black := mc.of(White), mc.of(Black)
+	// with only opposite-coloured bishops left, a few extra pawns rarely win
+	if white[Bishop] == 1 && black[Bishop] == 1 && onlyBishops(white) && onlyBishops(black) &&
+		squareColour(bishops[0]) != squareColour(bishops[1]) {
+		return score / 2
+	}
+	// a side without pawns needs more than a minor piece to mate
+	if score > 0 && cannotWin(white) || score < 0 && cannotWin(black) {
+		return 0
+	}
+	return score
+}
+
+func onlyBishops(counts [7]int) bool {
+	return counts[Knight] == 0 && counts[Rook] == 0 && counts[Queen] == 0
+}
+
+func cannotWin(counts [7]int) bool {
+	return counts[Pawn] == 0 && counts[Rook] == 0 && counts[Queen] == 0 && counts[Knight]+counts[Bishop] <= 1
+}
+
+// true for light squares
+func squareColour(s Square) bool {
+	return (int(s.File())+int(s.Rank()))%2 == 1
+}
+
+// the number of king moves between two squares
+func squareDistance(s1, s2 Square) int {
+	files, ranks := Diff(s1, s2)
+	if files > ranks {
+		return files
+	}
+	return ranks
+}
+
+// 0 in the centre up to 6 in a corner
+func edgeDistance(s Square) int {
+	distance := func(x int) int {
+		if x < 4 {
+			return 3 - x
+		}
+		return x - 4
+	}
+	return distance(int(s.File())) + distance(int(s.Rank()))
+}
+
+func evalDraw(p Position, strong PieceColour) int {
+	return 0
+}
+
+// drives the weak king to the edge and brings the strong king closer
+func evalKXK(p Position, strong PieceColour) int {
+	score := knownWinValue
+	for _, square := range Squares {
+		if piece := p.board[square]; piece != NoPiece && piece.Colour() == strong && piece.Type() != King {
+			score += piece.Value()
+		}
+	}
+	king, weakKing := p.KingSquare(strong), p.KingSquare(strong.Flip())
+	return score + 10*edgeDistance(weakKing) + 5*(7-squareDistance(king, weakKing))
+}
+
+// drives the weak king to a corner the bishop can cover
+func evalKBNK(p Position, strong PieceColour) int {
+	light := false
+	for _, square := range Squares {
+		if p.board[square] == CreatePiece(strong, Bishop) {
+			light = squareColour(square)
+		}
+	}
+	corners := [2]Square{ToSquare(0, 0), ToSquare(7, 7)}
+	if light {
+		corners = [2]Square{ToSquare(0, 7), ToSquare(7, 0)}
+	}
+	weakKing := p.KingSquare(strong.Flip())
+	corner := squareDistance(weakKing, corners[0])
+	if d := squareDistance(weakKing, corners[1]); d < corner {
+		corner = d
+	}
+	return evalKXK(p, strong) + 20*(7-corner)
+}
+
+// exact with the bitbase: a won pawn is worth pushing, a drawn one nothing
+func evalKPK(p Position, strong PieceColour) int {
+	pawn := NoSquare
+	for _, square := range Squares {
+		if p.board[square] == CreatePiece(strong, Pawn) {
+			pawn = square
+		}
+	}
+	king, weakKing := p.KingSquare(strong), p.KingSquare(strong.Flip())
+	// seen from the strong side moving up the board on the queenside
+	if strong == Black {
+		pawn, king, weakKing = pawn^56, king^56, weakKing^56
+	}
+	if pawn.File() > 3 {
+		pawn, king, weakKing = pawn^7, king^7, weakKing^7
+	}
+	turn := White
+	if p.turn != strong {
+		turn = Black
+	}
+	if !KPKWins(turn, king, pawn, weakKing) {
+		return 0
+	}
+	return knownWinValue - 100 + 10*int(pawn.Rank())
+}
+
+// the KPK bitbase holds one bit per position with white's pawn on files a-d, set when white wins
+const kpkSize = 2 * 24 * 64 * 64
+
+const (
+	kpkInvalid = 0
+	kpkUnknown = 1
+	kpkDraw    = 2
+	kpkWin     = 4
+)
+
+var (
+	kpkOnce sync.Once
+	kpkBits [kpkSize / 64]uint64
+)
+
+func kpkIndex(turn PieceColour, king, pawn, weakKing Square) int {
+	return int(king) | int(weakKing)<<6 | boolToInt(bool(turn))<<12 | int(pawn.File())<<13 | (6-int(pawn.Rank()))<<15
+}
+
+func kpkPosition(idx int) (turn PieceColour, king, pawn, weakKing Square) {
+	turn = PieceColour(idx>>12&1 == 1)
+	pawn = ToSquare(File(idx>>13&3), Rank(6-idx>>15))
+	return turn, Square(idx & 63), pawn, Square(idx >> 6 & 63)
+}
+
+// whether white wins with the king, a pawn on files a-d and turn to move against the weak king
+func KPKWins(turn PieceColour, king, pawn, weakKing Square) bool {
+	kpkOnce.Do(buildKPK)
+	idx := kpkIndex(turn, king, pawn, weakKing)
+	return kpkBits[idx/64]&(1<<(idx%64)) != 0
+}
+
+// classifies every position by retrograde analysis until nothing changes
+func buildKPK() {
+	db := make([]uint8, kpkSize)
+	for idx := range db {
+		db[idx] = kpkInitial(idx)
+	}
+	for changed := true; changed; {
+		changed = false
+		for idx, result := range db {
+			if result == kpkUnknown {
+				if db[idx] = kpkClassify(db, idx); db[idx] != kpkUnknown {
+					changed = true
+				}
+			}
+		}
+	}
+	for idx, result := range db {
+		if result == kpkWin {
+			kpkBits[idx/64] |= 1 << (idx % 64)
+		}
+	}
+}
+
+func kingSteps(s Square) []Square {
+	var squares []Square
+	for _, m := range pieceMovements[King] {
+		if to, err := s.Move(m); err == nil {
+			squares = append(squares, to)
+		}
+	}
+	return squares
+}
+
+// whether white's pawn attacks the square
+func pawnAttacks(pawn, s Square) bool {
+	files, _ := Diff(pawn, s)
+	return files == 1 && s.Rank() == pawn.Rank()+1
+}
+
+// the result of positions decided without looking ahead
+func kpkInitial(idx int) uint8 {
+	turn, king, pawn, weakKing := kpkPosition(idx)
+	push := pawn - 8 // one rank up
+	switch {
+	case squareDistance(king, weakKing) <= 1 || king == pawn || weakKing == pawn || turn == White && pawnAttacks(pawn, weakKing):
+		return kpkInvalid
+	case turn == White && pawn.Rank() == 6 && king != push &&
+		(squareDistance(weakKing, push) > 1 || squareDistance(king, push) == 1):
+		// promotes without losing the queen
+		return kpkWin
+	case turn == Black && squareDistance(weakKing, pawn) == 1 && squareDistance(king, pawn) > 1:
+		// takes the pawn
+		return kpkDraw
+	case turn == Black:
+		for _, s := range kingSteps(weakKing) {
+			if squareDistance(king, s) > 1 && !pawnAttacks(pawn, s) {
+				return kpkUnknown
+			}
+		}
+		// stalemate
+		return kpkDraw
+	}
+	return kpkUnknown
+}
+
+// the result of a position from the results after each move
+func kpkClassify(db []uint8, idx int) uint8 {
+	turn, king, pawn, weakKing := kpkPosition(idx)
+	var r uint8
+	if turn == White {
+		for _, s := range kingSteps(king) {
+			r |= db[kpkIndex(Black, s, pawn, weakKing)]
+		}
+		if push := pawn - 8; pawn.Rank() < 6 {
+			r |= db[kpkIndex(Black, king, push, weakKing)]
+			if pawn.Rank() == 1 && push != king && push != weakKing {
+				r |= db[kpkIndex(Black, king, push-8, weakKing)]
+			}
+		}
+	} else {
+		for _, s := range kingSteps(weakKing) {
+			r |= db[kpkIndex(White, king, pawn, s)]
+		}
+	}
+	good, bad := uint8(kpkWin), uint8(kpkDraw)
+	if turn == Black {
+		good, bad = bad, good
+	}
+	switch {
+	case r&good != 0:
+		return good
+	case r&kpkUnknown != 0:
+		return kpkUnknown
+	}
+	return bad
+}
@@ -0,0 +1,82 @@
+package main
+
+import "testing"
+
+func TestKPK(t *testing.T) {
+	tests := []struct {
+		fen  string
+		eval int // sign only
+	}{
+		// king in front of the pawn on the sixth rank
+		{"3k4/8/3K4/3P4/8/8/8/8 w - - 0 1", 1},
+		{"3k4/8/3K4/3P4/8/8/8/8 b - - 0 1", 1},
+		// the rook pawn cannot drive the king out of the corner
+		{"k7/8/8/P7/8/8/8/K7 w - - 0 1", 0},
+		// outside the square of the pawn
+		{"8/8/8/8/P7/8/8/K6k w - - 0 1", 1},
+		{"8/8/8/8/P4k2/8/8/K7 b - - 0 1", 0},
+		// the pawn is lost
+		{"8/8/8/8/8/8/kP6/7K b - - 0 1", 0},
+		// the same for black, and on the kingside
+		{"8/8/8/8/8/4k3/4p3/4K3 b - - 0 1", -1},
+		{"8/8/8/8/8/4k3/4p3/4K3 w - - 0 1", 0}, // stalemate
+		{"k6K/8/8/p7/8/8/8/8 b - - 0 1", -1},
+		{"8/8/8/6p1/8/8/8/K6k w - - 0 1", -1},
+	}
+	for _, test := range tests {
+		eval := Eval(LoadInitialPosition(test.fen))
+		switch {
+		case test.eval == 0 && eval != 0,
+			test.eval > 0 && eval < knownWinValue-100,
+			test.eval < 0 && eval > -knownWinValue+100:
+			t.Errorf("Eval(%q) = %d; want sign %d", test.fen, eval, test.eval)
+		}
+	}
+}
+
+func TestEndgameEval(t *testing.T) {
+	tests := []struct {
+		name          string
+		better, worse string
+	}{
+		{"KQK edge", "3k4/8/8/8/8/8/8/Q3K3 w - - 0 1", "8/8/8/3k4/8/8/8/Q3K3 w - - 0 1"},
+		{"KRK kings close", "3k4/8/3K4/8/8/8/8/R7 w - - 0 1", "3k4/8/8/8/8/8/8/R3K3 w - - 0 1"},
+		{"KBNK bishop's corner", "8/8/8/8/4K3/8/8/k1B1N3 w - - 0 1", "8/8/8/8/4K3/8/8/2B1N2k w - - 0 1"},
+		{"KQRK keeps the rook", "3k4/8/8/8/8/8/8/QR2K3 w - - 0 1", "3k4/8/8/8/8/8/8/Q3K3 w - - 0 1"},
+		{"black mates too", "Q3k3/8/8/8/8/8/8/3K4 b - - 0 1", "q3k3/8/8/8/8/8/8/3K4 w - - 0 1"},
+	}
+	for _, test := range tests {
+		better, worse := Eval(LoadInitialPosition(test.better)), Eval(LoadInitialPosition(test.worse))
+		if better <= worse {
+			t.Errorf("%s: Eval(%q) = %d; want more than %d for %q", test.name, test.better, better, worse, test.worse)
+		}
+		if test.name != "black mates too" && worse < knownWinValue {
+			t.Errorf("%s: Eval(%q) = %d; want at least %d", test.name, test.worse, worse, knownWinValue)
+		}
+	}
+}
+
+func TestScaleEndgame(t *testing.T) {
+	tests := []struct {
+		fen    string
+		strong PieceColour // the side ahead in material, without pawns
+	}{
+		{"8/8/8/3k4/8/8/8/2N1K3 w - - 0 1", White},
+		{"8/8/8/3k4/3p4/8/8/2N1K3 w - - 0 1", White},
+		{"8/8/8/3k4/3p4/8/8/2B1K3 w - - 0 1", White},
+		{"8/8/3n4/3k4/8/8/3P4/4K3 b - - 0 1", Black},
+		// the stupid-horse values a knight above a rook, but a lone knight cannot mate, so
+		// winning the rook with a fork gains nothing here
+		{"r3k3/8/8/3N4/8/8/8/4K3 w - - 0 1", White},
+	}
+	for _, test := range tests {
+		if eval := Eval(LoadInitialPosition(test.fen)) * colourMultiplier[test.strong]; eval > 0 {
+			t.Errorf("Eval(%q) = %d for %v; want no advantage", test.fen, eval, test.strong)
+		}
+	}
+	opposite := Eval(LoadInitialPosition("8/4k3/8/2b5/8/3B4/3PP3/4K3 w - - 0 1"))
+	same := Eval(LoadInitialPosition("8/4k3/2b5/8/8/3B4/3PP3/4K3 w - - 0 1"))
+	if opposite != same/2 {
+		t.Errorf("Eval() with opposite-coloured bishops = %d; want %d", opposite, same/2)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EPD is one record of an Extended Position Description file: a position followed by
+// opcodes such as "bm Qxf7+", "id \"WAC.001\"" or the "D5 4865609" counts of perft suites
+type EPD struct {
+	fen        string
+	operations map[string]string
+	opcodes    []string // in the order they appeared
+}
+
+func ParseEPD(line string) (EPD, error) {
+	e := EPD{operations: map[string]string{}}
+	fields := strings.Fields(strings.SplitN(line, ";", 2)[0])
+	if len(fields) < 4 {
+		return e, errors.New("EPD needs board, turn, castling and en passant fields")
+	}
+	e.fen = strings.Join(fields[:4], " ")
+	rest := strings.TrimPrefix(line, strings.SplitN(line, ";", 2)[0])
+	// the first operation may share the FEN's segment, unless it holds move counters
+	first := fields[4:]
+	if len(first) > 0 {
+		if _, err := strconv.Atoi(first[0]); err == nil {
+			counters := first
+			if len(counters) > 2 {
+				counters = counters[:2]
+			}
+			e.fen += " " + strings.Join(counters, " ")
+			first = first[len(counters):]
+		}
+	}
+	if len(first) > 0 {
+		e.add(strings.Join(first, " "))
+	}
+	for _, op := range strings.Split(rest, ";") {
+		e.add(op)
+	}
+	return e, nil
+}
+
+func (e *EPD) add(op string) {
+	op = strings.TrimSpace(op)
+	if op == "" {
+		return
+	}
+	fields := strings.SplitN(op, " ", 2)
+	opcode := fields[0]
+	operand := ""
+	if len(fields) == 2 {
+		operand = strings.Trim(strings.TrimSpace(fields[1]), "\"")
+	}
+	if _, ok := e.operations[opcode]; !ok {
+		e.opcodes = append(e.opcodes, opcode)
+	}
+	e.operations[opcode] = operand
+}
+
+// reads every non-empty, non-comment line of an EPD file
+func ReadEPDs(r io.Reader) ([]EPD, error) {
+	var epds []EPD
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		e, err := ParseEPD(line)
+		if err != nil {
+			return nil, err
+		}
+		epds = append(epds, e)
+	}
+	return epds, scanner.Err()
+}
+
+// EPDResult is the outcome of searching one test position
+type EPDResult struct {
+	id       string
+	best     string // SAN of the move the search settled on
+	depth    int
+	elapsed  time.Duration
+	solved   bool
+	solvedAt time.Duration // time of the iteration from which the best move stayed a solution
+}
+
+// searches the position until depth or moveTime is reached and checks the best move
+// against the bm (best moves) and am (avoid moves) opcodes
+func (e EPD) Solve(depth int, moveTime time.Duration) (EPDResult, error) {
+	r := EPDResult{id: e.operations["id"]}
+	pos := LoadInitialPosition(e.fen)
+	bm, err := parseMoveList(pos, e.operations["bm"])
+	if err != nil {
+		return r, err
+	}
+	am, err := parseMoveList(pos, e.operations["am"])
+	if err != nil {
+		return r, err
+	}
+	if len(bm) == 0 && len(am) == 0 {
+		return r, errors.New("EPD has neither bm nor am")
+	}
+	isSolution := func(m Move) bool {
+		for _, move := range am {
+			if m == move {
+				return false
+			}
+		}
+		if len(bm) == 0 {
+			return true
+		}
+		for _, move := range bm {
+			if m == move {
+				return true
+			}
+		}
+		return false
+	}
+
+	start := time.Now()
+	var deadline time.Time
+	if moveTime > 0 {
+		deadline = start.Add(moveTime)
+	}
+	tree := &MoveTree{position: pos}
+	solving := false
+	ThinkUntil(tree, Limits{depth: depth, deadline: deadline}, func(d, eval int) {
+		r.depth = d
+		if tree.follow == nil {
+			return
+		}
+		if isSolution(tree.follow.move) {
+			if !solving {
+				r.solvedAt = time.Since(start)
+			}
+			solving = true
+		} else {
+			solving = false
+		}
+	})
+	r.elapsed = time.Since(start)
+	if tree.follow == nil {
+		return r, errors.New("no legal moves")
+	}
+	r.best = pos.MoveToSAN(tree.follow.move)
+	r.solved = solving
+	return r, nil
+}
+
+func parseMoveList(pos Position, sans string) ([]Move, error) {
+	var moves []Move
+	for _, san := range strings.Fields(sans) {
+		move, err := pos.ParseSAN(san)
+		if err != nil {
+			return nil, err
+		}
+		moves = append(moves, move)
+	}
+	return moves, nil
+}
+
+// runs a tactical test suite such as WAC or ECM, printing one line per position and a summary
+func StartEPD(args []string) {
+	fs := commandFlags("epd")
+	depth := fs.Int("depth", MaxPly, "maximum search depth per position")
+	moveTime := fs.Duration("time", time.Second, "search time per position (0 for no limit)")
+	fs.Parse(args)
+	if fs.NArg() == 0 || (*moveTime == 0 && *depth == MaxPly) {
+		usageError(fs)
+	}
+	solved, total := 0, 0
+	for _, filename := range fs.Args() {
+		f, err := os.Open(filename)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		epds, err := ReadEPDs(f)
+		f.Close()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for i, e := range epds {
+			total++
+			r, err := e.Solve(*depth, *moveTime)
+			if r.id == "" {
+				r.id = fmt.Sprintf("%s #%d", filename, i+1)
+			}
+			if err != nil {
+				fmt.Printf("%-12s error: %v\n", r.id, err)
+				continue
+			}
+			status := "failed"
+			if r.solved {
+				solved++
+				status = fmt.Sprintf("solved in %v", r.solvedAt.Round(time.Millisecond))
+			}
+			expected := "bm " + e.operations["bm"]
+			if e.operations["bm"] == "" {
+				expected = "am " + e.operations["am"]
+			}
+			fmt.Printf("%-12s %-16s found %-8s depth %-3d %-10v %s\n",
+				r.id, expected, r.best, r.depth, r.elapsed.Round(time.Millisecond), status)
+		}
+	}
+	fmt.Printf("solved %d of %d\n", solved, total)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseEPD(t *testing.T) {
+	tests := []struct {
+		line       string
+		fen        string
+		operations map[string]string
+	}{
+		{
+			"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - ;D1 20 ;D2 400",
+			"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -",
+			map[string]string{"D1": "20", "D2": "400"},
+		},
+		{
+			"bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9 ;D1 21",
+			"bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9",
+			map[string]string{"D1": "21"},
+		},
+		{
+			`2rr3k/pp3pp1/1nnqbN1p/3pN3/2pP4/2P3Q1/PPB4P/R4RK1 w - - bm Qg6; id "WAC.001";`,
+			"2rr3k/pp3pp1/1nnqbN1p/3pN3/2pP4/2P3Q1/PPB4P/R4RK1 w - -",
+			map[string]string{"bm": "Qg6", "id": "WAC.001"},
+		},
+	}
+	for _, test := range tests {
+		e, err := ParseEPD(test.line)
+		if err != nil {
+			t.Errorf("ParseEPD(%q) error: %v", test.line, err)
+			continue
+		}
+		if e.fen != test.fen {
+			t.Errorf("ParseEPD(%q).fen = %q; want %q", test.line, e.fen, test.fen)
+		}
+		if len(e.operations) != len(test.operations) {
+			t.Errorf("ParseEPD(%q).operations = %v; want %v", test.line, e.operations, test.operations)
+		}
+		for opcode, operand := range test.operations {
+			if e.operations[opcode] != operand {
+				t.Errorf("ParseEPD(%q) %s = %q; want %q", test.line, opcode, e.operations[opcode], operand)
+			}
+		}
+	}
+	if _, err := ParseEPD("8/8/8 w"); err == nil {
+		t.Errorf("ParseEPD of a short line should fail")
+	}
+}
+
+func TestSolveTactics(t *testing.T) {
+	f, err := os.Open("testdata/tactics.epd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	epds, err := ReadEPDs(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range epds {
+		r, err := e.Solve(4, 0)
+		if err != nil {
+			t.Errorf("%s: %v", e.operations["id"], err)
+			continue
+		}
+		if !r.solved || r.depth != 4 {
+			t.Errorf("%s: found %s at depth %d, want a solution at depth 4", r.id, r.best, r.depth)
+		}
+	}
+}
+
+func TestSolveTimeLimit(t *testing.T) {
+	e, err := ParseEPD(`r3k3/8/8/3N4/8/8/8/4K3 w - - bm Nc7+; id "fork.001";`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := e.Solve(MaxPly, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.depth < 1 || r.depth >= MaxPly || r.elapsed > time.Second {
+		t.Errorf("Solve(MaxPly, 100ms) reached depth %d in %v", r.depth, r.elapsed)
+	}
+}
@@ -1,114 +1,300 @@
 package main
 
+import (
+	"fmt"
+	"time"
+)
+
 const checkmateValue = 9999999
 
+// the piece values of each evaluation personality, by piece type
+var personalities = map[string][King + 1]int{
+	"stupid-horse": {Pawn: 10, Knight: 70, Bishop: 30, Rook: 50, Queen: 90, King: 999}, // stupid-horse!
+	"classical":    {Pawn: 10, Knight: 30, Bishop: 30, Rook: 50, Queen: 90, King: 999},
+}
+
+// the piece values of the personality the bot plays with, set once at startup
+var pieceValues = personalities["stupid-horse"]
+
 func (p Piece) Value() int {
-	switch p.Type() {
-	case Pawn:
-		return 10
-	case Knight:
-		return 70 // stupid-horse!
-	case Bishop:
-		return 30
-	case Rook:
-		return 50
-	case Queen:
-		return 90
-	case King:
-		return 999
-	case NoPieceType:
-		return 0
+	t := p.Type()
+	if t > King {
+		panic("Invalid piece")
 	}
-	panic("Invalid piece")
+	return pieceValues[t]
 }
 
 var colourMultiplier = map[PieceColour]int{White: 1, Black: -1}
 
 func Eval(p Position) int {
+	return evaluate(p, true)
+}
+
+// the part of Eval that its pawn term adds: every pawn's distance from the middle of its
+// promotion rank counts for white, as much as 128 at the start
+func pawnBias(p Position) int {
+	return Eval(p) - evaluate(p, false)
+}
+
+// a search score of position p with the pawn term's bias towards white taken out, so that
+// the scores of either side can be held against the same threshold; mates are kept
+func unbiased(eval int, p Position) int {
+	if _, ok := MateIn(eval); ok {
+		return eval
+	}
+	return eval - pawnBias(p)
+}
+
+func evaluate(p Position, pawnTerm bool) int {
 	//fmt.Println(p.board)
 	score := 0
+	var mc materialCount
+	var bishops [2]Square
 	for _, square := range Squares {
 		piece := p.board[square]
 		// TODO: make use of piece square
 		if piece == NoPiece {
 			continue
 		}
+		mc.add(piece)
 		score += colourMultiplier[piece.Colour()] * piece.Value()
-		if piece.Type() == Pawn {
-			fileDiff, rankDiff := Diff(ToSquare(File(4), pawnInfo[piece.Colour()].promotionRank), square)
-			score += fileDiff + rankDiff
+		switch piece.Type() {
+		case Pawn:
+			if pawnTerm {
+				fileDiff, rankDiff := Diff(ToSquare(File(4), pawnInfo[piece.Colour()].promotionRank), square)
+				score += fileDiff + rankDiff
+			}
+		case Bishop:
+			bishops[boolToInt(bool(piece.Colour()))] = square
 		}
 	}
-	return score
+	if eval, ok := evalEndgame(p, &mc); ok {
+		return eval
+	}
+	return scaleEndgame(score, &mc, bishops)
 }
 
-func SortByCapture(moves []Move) {
-	lastCapture := 0
-	for i, move := range moves {
-		if move.capture {
-			temp := moves[lastCapture]
-			moves[lastCapture] = move
-			moves[i] = temp
-			lastCapture++
-		}
+type search struct {
+	tt        *TranspositionTable
+	history   *History
+	deadline  time.Time // zero for no time limit
+	stop      <-chan struct{}
+	tablebase *Tablebase // nil without endgame tablebases
+	nodes     int
+	stopped   bool
+}
+
+func newSearch() *search {
+	return &search{
+		tt:      NewTranspositionTable(TTMaxSize),
+		history: new(History),
 	}
 }
 
 func Think(mt *MoveTree, depth int) int {
-	var minimax func(alpha, beta int) func(*MoveTree, int, *TranspositionTable) int
-	minimax = func(alpha, beta int) func(*MoveTree, int, *TranspositionTable) int {
-		return func(mt *MoveTree, depth int, tt *TranspositionTable) int {
-			if depth == 0 {
-				mt.eval = Eval(mt.position)
-				return mt.eval
-			}
-			mt.eval = colourMultiplier[mt.position.turn] * -checkmateValue * 10
-			SortByCapture(mt.candidateMoves)
-			for _, move := range mt.candidateMoves {
-				child := new(MoveTree)
-				child.parent = mt
-				child.move = move
-				child.position = mt.position.ProcessMove(move)
-				child.FindMoves(depth-1, tt, minimax(alpha, beta))
-				if !child.legal {
-					continue
-				}
-				switch child.state {
-				case Stalemate:
-					child.eval = 0
-				case WhiteWon:
-					child.eval = colourMultiplier[White] * checkmateValue
-				case BlackWon:
-					child.eval = colourMultiplier[Black] * checkmateValue
-				}
-				if mt.position.turn == White {
-					if child.eval > mt.eval {
-						mt.eval = child.eval
-						mt.follow = child
-					}
-					if child.eval > alpha {
-						alpha = child.eval
-					}
-					if alpha >= beta {
-						break
-					}
-				} else {
-					if child.eval < mt.eval {
-						mt.eval = child.eval
-						mt.follow = child
-					}
-					if child.eval < beta {
-						beta = child.eval
-					}
-					if beta <= alpha {
-						break
-					}
-				}
+	return newSearch().minimax(mt, depth, 0, -checkmateValue*10, checkmateValue*10)
+}
+
+// Limits bound an iterative deepening search
+type Limits struct {
+	depth     int
+	deadline  time.Time       // zero for no time limit
+	stop      <-chan struct{} // closed to end the search early
+	tablebase *Tablebase      // probed at the root and at interior nodes, if set
+	halfmoves int             // since the last capture or pawn move, for tablebase probes
+	tables    *SearchTables   // kept from earlier searches of the game if set, instead of new ones
+	nodes     *int            // the nodes searched are added to it, if set
+}
+
+// SearchTables carry what the searches of a game learn over to its next searches
+type SearchTables struct {
+	tt      *TranspositionTable
+	history *History
+	ply     int // of the game at the latest search
+}
+
+// with room for capacity transposition table entries
+func NewSearchTables(capacity int) *SearchTables {
+	return &SearchTables{
+		tt:      NewTranspositionTable(capacity),
+		history: new(History),
+	}
+}
+
+// ages the tables for a search at the given ply of the game
+func (st *SearchTables) Advance(ply int) {
+	st.tt.Age()
+	st.history.Age(ply - st.ply)
+	st.ply = ply
+}
+
+// searches one ply deeper at a time until the depth limit is completed, the deadline passes
+// or the search is stopped, calling report after every completed depth; the first depth
+// always completes. Won or lost tablebase positions are not searched: the move that
+// converts soonest is played
+func ThinkUntil(mt *MoveTree, limits Limits, report func(depth, eval int)) int {
+	if limits.tablebase != nil {
+		if m, wdl, ok := limits.tablebase.RootMove(mt.position, limits.halfmoves); ok && wdl != 0 {
+			mt.follow = &MoveTree{parent: mt, move: m, position: mt.position.ProcessMove(m)}
+			mt.eval = colourMultiplier[mt.position.turn] * tablebaseScore(wdl, 0)
+			if report != nil {
+				report(1, mt.eval)
 			}
-			mt.eval = mt.eval * 99 / 100 // soften the evaluation of less immediate lines
 			return mt.eval
 		}
 	}
-	tt := NewTranspositionTable(TTMaxSize)
-	return mt.FindMoves(depth, tt, minimax(-checkmateValue*10, checkmateValue*10))
+	s := newSearch()
+	s.tablebase = limits.tablebase
+	if limits.tables != nil {
+		s.tt, s.history = limits.tables.tt, limits.tables.history
+	}
+	eval := 0
+	var best *MoveTree
+	for depth := 1; depth <= limits.depth; depth++ {
+		e := s.minimax(mt, depth, 0, -checkmateValue*10, checkmateValue*10)
+		if s.stopped {
+			break
+		}
+		eval, best = e, mt.follow
+		if report != nil {
+			report(depth, eval)
+		}
+		s.deadline = limits.deadline
+		s.stop = limits.stop
+	}
+	if limits.nodes != nil {
+		*limits.nodes += s.nodes
+	}
+	mt.eval = eval
+	mt.follow = best
+	return eval
+}
+
+// how long to think about a move given the remaining time and increment
+func AllocateTime(remaining, increment time.Duration) time.Duration {
+	t := remaining/30 + increment*3/4
+	if t > remaining/2 {
+		t = remaining / 2
+	}
+	return t
+}
+
+func (s *search) minimax(mt *MoveTree, depth, ply, alpha, beta int) int {
+	s.nodes++
+	if s.nodes%1024 == 0 {
+		if !s.deadline.IsZero() && time.Now().After(s.deadline) {
+			s.stopped = true
+		}
+		select {
+		case <-s.stop:
+			s.stopped = true
+		default:
+		}
+	}
+	if s.stopped {
+		return 0
+	}
+	if ply > 0 && s.tablebase != nil {
+		if wdl, ok := s.tablebase.ProbeWDL(mt.position); ok {
+			mt.eval = colourMultiplier[mt.position.turn] * tablebaseScore(wdl, ply)
+			return mt.eval
+		}
+	}
+	if depth == 0 {
+		mt.eval = Eval(mt.position)
+		return mt.eval
+	}
+	hashMove := NoMove
+	if cached := s.tt.Get(mt.position); cached != nil && cached.follow != nil {
+		hashMove = cached.follow.move
+	}
+	mt.legal = true
+	mt.legalMoves = nil
+	mt.follow = nil
+	mt.eval = colourMultiplier[mt.position.turn] * -checkmateValue * 10
+	mp := NewMovePicker(mt.position, hashMove, ply, s.history)
+	for move, ok := mp.Next(); ok; move, ok = mp.Next() {
+		child := new(MoveTree)
+		child.parent = mt
+		child.move = move
+		child.position = mt.position.ProcessMove(move)
+		if !child.position.LegalAfter(move) {
+			continue
+		}
+		mt.legalMoves = append(mt.legalMoves, move)
+		s.minimax(child, depth-1, ply+1, alpha, beta)
+		if s.stopped {
+			return 0
+		}
+		if mt.position.turn == White {
+			if child.eval > mt.eval {
+				mt.eval = child.eval
+				mt.follow = child
+			}
+			if child.eval > alpha {
+				alpha = child.eval
+			}
+		} else {
+			if child.eval < mt.eval {
+				mt.eval = child.eval
+				mt.follow = child
+			}
+			if child.eval < beta {
+				beta = child.eval
+			}
+		}
+		if alpha >= beta {
+			s.history.Update(mt.position, move, depth, ply)
+			break
+		}
+	}
+	if len(mt.legalMoves) == 0 {
+		// check if stalemate or checkmate
+		if mt.position.InCheck() {
+			mt.state = WinFor(mt.position.turn.Flip())
+			mt.eval = colourMultiplier[mt.position.turn.Flip()] * checkmateValue
+		} else {
+			mt.state = Stalemate
+			mt.eval = 0
+		}
+		return mt.eval
+	}
+	mt.eval = mt.eval * 99 / 100 // soften the evaluation of less immediate lines
+	s.tt.Add(mt)
+	return mt.eval
+}
+
+// the moves the search expects to be played from here
+func (mt *MoveTree) PrincipalVariation() []Move {
+	var pv []Move
+	for node := mt.follow; node != nil && len(pv) < MaxPly; node = node.follow {
+		pv = append(pv, node.move)
+	}
+	return pv
+}
+
+// number of half-moves to mate for mate scores, which are softened once per ply
+func MateIn(eval int) (plies int, ok bool) {
+	if eval < 0 {
+		eval = -eval
+	}
+	if eval < checkmateValue/10 {
+		return 0, false
+	}
+	for v := checkmateValue; v > eval; v = v * 99 / 100 {
+		plies++
+	}
+	return plies, true
+}
+
+// formats an evaluation from white's point of view in pawns, or as "#3"/"#-3" for mates
+func FormatEval(eval int) string {
+	if plies, ok := MateIn(eval); ok {
+		moves := (plies + 1) / 2
+		if eval < 0 {
+			moves = -moves
+		}
+		return fmt.Sprintf("#%d", moves)
+	}
+	return fmt.Sprintf("%.2f", float64(eval)/10)
 }
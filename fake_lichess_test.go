@@ -0,0 +1,478 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLichess serves the parts of the Lichess bot API the bot uses, with challenges, opponent
+// moves, clocks, aborts and dropped connections scripted by tests
+type fakeLichess struct {
+	t      *testing.T
+	server *httptest.Server
+	client *LichessClient
+	botId  string
+
+	mu         sync.Mutex
+	events     chan string          // lines for the event stream
+	dropEvents chan struct{}        // ends the event stream connection
+	challenges map[string]*fakeGame // games that start once their challenge is accepted
+	games      map[string]*fakeGame
+	accepted   []string          // challenge IDs
+	declined   map[string]string // challenge ID to reason
+	chat       []string          // "room: text"
+	closed     bool              // every request is refused once the test is over
+}
+
+type fakeGame struct {
+	id          string
+	fen         string
+	white       string // user IDs
+	black       string
+	moves       []string
+	status      string
+	winner      string
+	wtime       int // milliseconds
+	btime       int
+	increment   int
+	drawOffer   string        // user ID of whoever offers a draw, or ""
+	takeback    string        // user ID of whoever asks to take back moves, or ""
+	botOffers   int           // draws the bot offered
+	updates     chan string   // lines for the game stream
+	drop        chan struct{} // ends the game stream connection
+	connections int
+	// the opponent's reply to the moves so far, or "" to wait for the test to move
+	opponent func(moves []string) string
+	botMoves chan string
+}
+
+func newFakeLichess(t *testing.T) *fakeLichess {
+	f := &fakeLichess{
+		t:          t,
+		botId:      "stupid-horse",
+		events:     make(chan string, 100),
+		dropEvents: make(chan struct{}, 1),
+		challenges: map[string]*fakeGame{},
+		games:      map[string]*fakeGame{},
+		declined:   map[string]string{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/stream/event", f.streamEvents)
+	mux.HandleFunc("/api/bot/game/stream/", f.streamGame)
+	mux.HandleFunc("/api/bot/game/", f.gameAction)
+	mux.HandleFunc("/api/challenge/", f.challengeAction)
+	mux.HandleFunc("/api/account", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"id":%q,"username":%q,"title":"BOT"}`, f.botId, f.botId)
+	})
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		closed := f.closed
+		f.mu.Unlock()
+		if closed {
+			http.Error(w, "closed", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	f.client = NewLichessClient(f.server.URL, "token")
+	f.client.retry = Backoff{Min: time.Millisecond, Max: 10 * time.Millisecond}
+	return f
+}
+
+// a bot playing against the fake at a low depth, listening until the test ends
+func (f *fakeLichess) startBot(configure func(b *Bot)) *Bot {
+	b := NewBot(f.botId, f.client)
+	b.depth = 2
+	b.archiveDir = f.t.TempDir()
+	b.stall = time.Minute
+	b.reconnect = Backoff{Min: time.Millisecond, Max: 10 * time.Millisecond}
+	b.chatPace = NewPacer(0)
+	if configure != nil {
+		configure(b)
+	}
+	done := make(chan struct{})
+	go func() {
+		b.Listen()
+		close(done)
+	}()
+	f.t.Cleanup(func() {
+		f.mu.Lock()
+		f.closed = true
+		for _, g := range f.games {
+			close(g.drop)
+		}
+		f.mu.Unlock()
+		f.dropEvents <- struct{}{}
+		<-done
+		f.server.Close()
+	})
+	return b
+}
+
+func (f *fakeLichess) sendEvent(event interface{}) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.events <- string(line)
+}
+
+func (f *fakeLichess) streamEvents(w http.ResponseWriter, r *http.Request) {
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case line := <-f.events:
+			fmt.Fprintln(w, line)
+			w.(http.Flusher).Flush()
+		case <-f.dropEvents:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// a game against the opponent, who plays black unless the bot does
+func (f *fakeLichess) newGame(id, fen string, botWhite bool, opponent func(moves []string) string) *fakeGame {
+	g := &fakeGame{
+		id:       id,
+		fen:      fen,
+		white:    f.botId,
+		black:    "opponent",
+		status:   "started",
+		wtime:    60000,
+		btime:    60000,
+		updates:  make(chan string, 100),
+		drop:     make(chan struct{}),
+		opponent: opponent,
+		botMoves: make(chan string, 100),
+	}
+	if !botWhite {
+		g.white, g.black = g.black, g.white
+	}
+	return g
+}
+
+// sends a challenge that starts the game once accepted
+func (f *fakeLichess) challenge(g *fakeGame, c LichessChallenge) {
+	f.mu.Lock()
+	f.challenges[g.id] = g
+	f.mu.Unlock()
+	c.Id = g.id
+	if c.Challenger == nil {
+		c.Challenger = &LichessPlayer{Id: "opponent", Name: "opponent", Rating: 1500}
+	}
+	f.sendEvent(map[string]interface{}{"type": "challenge", "challenge": c})
+}
+
+// starts a game without a challenge, as if the bot had challenged the opponent
+func (f *fakeLichess) start(g *fakeGame) {
+	f.mu.Lock()
+	f.games[g.id] = g
+	f.mu.Unlock()
+	f.sendEvent(map[string]interface{}{"type": "gameStart", "game": map[string]string{"id": g.id}})
+}
+
+// the gameState of a game, with the lock held
+func (g *fakeGame) state() map[string]interface{} {
+	return map[string]interface{}{
+		"type":      "gameState",
+		"moves":     strings.Join(g.moves, " "),
+		"wtime":     g.wtime,
+		"btime":     g.btime,
+		"winc":      g.increment,
+		"binc":      g.increment,
+		"status":    g.status,
+		"winner":    g.winner,
+		"wdraw":     g.drawOffer != "" && g.drawOffer == g.white,
+		"bdraw":     g.drawOffer != "" && g.drawOffer == g.black,
+		"wtakeback": g.takeback != "" && g.takeback == g.white,
+		"btakeback": g.takeback != "" && g.takeback == g.black,
+	}
+}
+
+func (g *fakeGame) full() map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "gameFull",
+		"id":         g.id,
+		"variant":    map[string]string{"key": "standard", "name": "Standard"},
+		"rated":      false,
+		"speed":      "rapid",
+		"createdAt":  time.Now().UnixNano() / int64(time.Millisecond),
+		"clock":      map[string]int{"initial": 60000, "increment": g.increment},
+		"white":      map[string]interface{}{"id": g.white, "name": g.white, "rating": 1500},
+		"black":      map[string]interface{}{"id": g.black, "name": g.black, "rating": 1500},
+		"initialFen": g.fen,
+		"state":      g.state(),
+	}
+}
+
+// queues an update for the game stream, with the lock held
+func (g *fakeGame) send(event interface{}) {
+	line, _ := json.Marshal(event)
+	g.updates <- string(line)
+}
+
+func (f *fakeLichess) game(id string) *fakeGame {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.games[id]
+}
+
+func (f *fakeLichess) streamGame(w http.ResponseWriter, r *http.Request) {
+	g := f.game(strings.TrimPrefix(r.URL.Path, "/api/bot/game/stream/"))
+	if g == nil {
+		http.NotFound(w, r)
+		return
+	}
+	f.mu.Lock()
+	g.connections++
+	// a new connection starts from the full game
+	for len(g.updates) > 0 {
+		<-g.updates
+	}
+	line, _ := json.Marshal(g.full())
+	f.mu.Unlock()
+	fmt.Fprintln(w, string(line))
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case line := <-g.updates:
+			fmt.Fprintln(w, line)
+			w.(http.Flusher).Flush()
+		case <-g.drop:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// plays a move, updating the clocks and the status, with the lock held
+func (f *fakeLichess) play(g *fakeGame, move string) error {
+	pos := LoadInitialPosition(g.fen)
+	for _, m := range g.moves {
+		pos = pos.ProcessMove(pos.StringToMove(m))
+	}
+	if len(move) < 4 || !pos.IsLegal(pos.StringToMove(move)) {
+		return fmt.Errorf("illegal move %s", move)
+	}
+	pos = pos.ProcessMove(pos.StringToMove(move))
+	g.moves = append(g.moves, move)
+	// moving declines the other side's offers
+	g.drawOffer, g.takeback = "", ""
+	if pos.turn == White {
+		g.btime -= 1000
+	} else {
+		g.wtime -= 1000
+	}
+	if len(pos.LegalMoves()) == 0 {
+		g.status = "stalemate"
+		if pos.InCheck() {
+			g.status, g.winner = "mate", pos.turn.Flip().String()
+		}
+	}
+	g.send(g.state())
+	if g.status != "started" {
+		f.sendEvent(map[string]interface{}{"type": "gameFinish", "game": map[string]string{"id": g.id}})
+	}
+	return nil
+}
+
+// whose turn it is, with the lock held
+func (g *fakeGame) toMove() string {
+	pos := LoadInitialPosition(g.fen)
+	if (len(g.moves)%2 == 0) == (pos.turn == White) {
+		return g.white
+	}
+	return g.black
+}
+
+// POST /api/bot/game/{id}/{action}/...
+func (f *fakeLichess) gameAction(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/bot/game/"), "/")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g := f.games[parts[0]]
+	if g == nil || len(parts) < 2 {
+		http.NotFound(w, r)
+		return
+	}
+	switch parts[1] {
+	case "move":
+		if g.status != "started" || g.toMove() != f.botId {
+			http.Error(w, "not your turn", http.StatusBadRequest)
+			return
+		}
+		if err := f.play(g, parts[2]); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		g.botMoves <- parts[2]
+		if g.status == "started" && g.opponent != nil {
+			if reply := g.opponent(g.moves); reply != "" {
+				if err := f.play(g, reply); err != nil {
+					f.t.Errorf("opponent: %v", err)
+				}
+			}
+		}
+	case "chat":
+		r.ParseForm()
+		f.chat = append(f.chat, r.PostForm.Get("room")+": "+r.PostForm.Get("text"))
+	case "abort", "resign":
+		if g.status != "started" {
+			http.Error(w, "game over", http.StatusBadRequest)
+			return
+		}
+		f.end(g, map[string]string{"abort": "aborted", "resign": "resign"}[parts[1]], f.botId)
+	case "draw":
+		switch {
+		case parts[2] == "yes" && g.drawOffer == "opponent":
+			f.end(g, "draw", "")
+			return
+		case parts[2] == "yes":
+			g.drawOffer = f.botId
+			g.botOffers++
+		case g.drawOffer == "opponent":
+			g.drawOffer = ""
+		}
+		g.send(g.state())
+	case "takeback":
+		if g.takeback != "opponent" {
+			http.Error(w, "no takeback to answer", http.StatusBadRequest)
+			return
+		}
+		g.takeback = ""
+		if parts[2] == "yes" {
+			// back to the opponent's move
+			plies := 1
+			if g.toMove() == "opponent" {
+				plies = 2
+			}
+			g.moves = g.moves[:len(g.moves)-plies]
+		}
+		g.send(g.state())
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// ends the game, with the lock held; the loser is ignored for aborts and draws
+func (f *fakeLichess) end(g *fakeGame, status, loser string) {
+	g.status = status
+	if status != "aborted" && status != "draw" {
+		g.winner = "white"
+		if loser == g.white {
+			g.winner = "black"
+		}
+	}
+	g.send(g.state())
+	f.sendEvent(map[string]interface{}{"type": "gameFinish", "game": map[string]string{"id": g.id}})
+}
+
+// POST /api/challenge/{id}/{accept,decline}
+func (f *fakeLichess) challengeAction(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/challenge/"), "/")
+	f.mu.Lock()
+	g := f.challenges[parts[0]]
+	delete(f.challenges, parts[0])
+	f.mu.Unlock()
+	if g == nil || len(parts) < 2 {
+		http.NotFound(w, r)
+		return
+	}
+	switch parts[1] {
+	case "accept":
+		f.mu.Lock()
+		f.accepted = append(f.accepted, g.id)
+		f.mu.Unlock()
+		f.start(g)
+	case "decline":
+		r.ParseForm()
+		f.mu.Lock()
+		f.declined[g.id] = r.PostForm.Get("reason")
+		f.mu.Unlock()
+	}
+}
+
+// the opponent plays a move, which must be theirs
+func (f *fakeLichess) opponentMove(g *fakeGame, move string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.play(g, move); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// ends the game on the opponent's side: "aborted", "resign", "outoftime", ...
+func (f *fakeLichess) opponentEnds(g *fakeGame, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.end(g, status, "opponent")
+}
+
+// the opponent offers a draw or asks to take back moves
+func (f *fakeLichess) opponentOffers(g *fakeGame, offer string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if offer == "draw" {
+		g.drawOffer = "opponent"
+	} else {
+		g.takeback = "opponent"
+	}
+	g.send(g.state())
+}
+
+// the opponent writes in a game's chat room
+func (f *fakeLichess) opponentSays(g *fakeGame, room, text string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g.send(map[string]string{"type": "chatLine", "username": "opponent", "text": text, "room": room})
+}
+
+// cuts the game stream, as a flaky connection would
+func (f *fakeLichess) disconnect(g *fakeGame) {
+	g.drop <- struct{}{}
+}
+
+// cancels a challenge not accepted yet
+func (f *fakeLichess) cancel(id string) {
+	f.mu.Lock()
+	delete(f.challenges, id)
+	f.mu.Unlock()
+	f.sendEvent(map[string]interface{}{"type": "challengeCanceled", "challenge": map[string]string{"id": id}})
+}
+
+// the next move the bot plays in the game
+func (f *fakeLichess) botMove(g *fakeGame) string {
+	select {
+	case m := <-g.botMoves:
+		return m
+	case <-time.After(10 * time.Second):
+		f.t.Fatalf("the bot did not move in game %s", g.id)
+		return ""
+	}
+}
+
+// waits until the condition holds, checked with the lock held
+func (f *fakeLichess) waitFor(what string, cond func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		f.mu.Lock()
+		ok := cond()
+		f.mu.Unlock()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			f.t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
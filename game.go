@@ -1,6 +1,10 @@
 package main
 
-import "strings"
+import (
+	"strconv"
+	"strings"
+	"time"
+)
 
 type Game struct {
 	id         string
@@ -9,6 +13,26 @@ type Game struct {
 	timers     map[PieceColour]uint
 	initialFen string
 	moves      string
+
+	// details kept for the PGN archive
+	variant     string // Lichess variant key, e.g. "standard" or "chess960"
+	rated       bool
+	speed       string // Lichess speed, e.g. "blitz"
+	clock       *LichessClock
+	started     time.Time
+	result      string // PGN result: "1-0", "0-1", "1/2-1/2" or "*" while in progress
+	tags        map[string]string
+	comment     string       // comment before the first move
+	annotations []Annotation // indexed by half-move
+
+	// the bot's side of a Lichess game
+	moved    bool               // the bot has sent its move in the current position
+	takeback bool               // the bot has granted a takeback that the moves do not show yet
+	search   *SearchInfo        // the bot's latest search, nil before its first move
+	mateSaid bool               // the bot has announced a forced mate
+	ponder   *Ponder            // the bot's search on the opponent's time, if any
+	tables   *SearchTables      // the bot's search tables, kept for the whole game
+	chat     chan<- chatMessage // messages for the bot to send in the game's chat, if queued
 }
 
 type Player struct {
@@ -19,6 +43,24 @@ type Player struct {
 	me     bool
 }
 
+// Annotation holds what PGN can attach to a half-move besides the move itself
+type Annotation struct {
+	comment    string
+	nags       []int
+	eval       int // engine units, see Eval
+	hasEval    bool
+	clock      time.Duration // mover's remaining time after the move, 0 if unknown
+	variations []Variation   // alternatives to this half-move
+}
+
+// Variation is a line of moves played instead of a half-move of its parent line
+type Variation []AnnotatedMove
+
+type AnnotatedMove struct {
+	move Move
+	Annotation
+}
+
 func NewGame(fen string, players map[PieceColour]Player, clock map[PieceColour]uint) *Game {
 	pos := LoadInitialPosition(fen)
 	mt := new(MoveTree)
@@ -29,6 +71,9 @@ func NewGame(fen string, players map[PieceColour]Player, clock map[PieceColour]u
 		timers:     clock,
 		initialFen: fen,
 		moves:      "",
+		variant:    "standard",
+		result:     "*",
+		tags:       map[string]string{},
 	}
 	return g
 }
@@ -48,3 +93,142 @@ func (g *Game) AddMoves(movesString string) {
 	g.moveTree = child
 	g.moveTree.Peek()
 }
+
+// takes back the last half-moves
+func (g *Game) TakeBack(plies int) {
+	moves := strings.Fields(g.moves)
+	if plies > len(moves) {
+		plies = len(moves)
+	}
+	moves = moves[:len(moves)-plies]
+	g.moveTree = &MoveTree{position: LoadInitialPosition(g.initialFen)}
+	g.moves = ""
+	g.AddMoves(strings.Join(moves, " "))
+	if len(g.annotations) > len(moves) {
+		g.annotations = g.annotations[:len(moves)]
+	}
+}
+
+// number of half-moves played so far
+func (g *Game) Ply() int {
+	return len(strings.Fields(g.moves))
+}
+
+// the position before every half-move played, then the current one
+func (g *Game) Positions() []Position {
+	pos := LoadInitialPosition(g.initialFen)
+	positions := []Position{pos}
+	for _, moveString := range strings.Fields(g.moves) {
+		pos = pos.ProcessMove(pos.StringToMove(moveString))
+		positions = append(positions, pos)
+	}
+	return positions
+}
+
+// annotation of a half-move, which may not have been played yet
+func (g *Game) Annotation(ply int) *Annotation {
+	for len(g.annotations) <= ply {
+		g.annotations = append(g.annotations, Annotation{})
+	}
+	return &g.annotations[ply]
+}
+
+// how a game ended, by Lichess status
+var gameEndings = map[string]string{
+	"aborted":       "aborted",
+	"noStart":       "aborted, as the first move was not made in time",
+	"mate":          "checkmate",
+	"resign":        "resignation",
+	"stalemate":     "stalemate",
+	"draw":          "draw",
+	"outoftime":     "time forfeit",
+	"timeout":       "abandoned",
+	"cheat":         "cheat detected",
+	"variantEnd":    "variant ending",
+	"unknownFinish": "unknown ending",
+}
+
+// whether a game that ended with the given Lichess status was called off without a result
+func Aborted(status string) bool {
+	return status == "aborted" || status == "noStart"
+}
+
+// whether the player of the given colour has made a move
+func (g *Game) HasMoved(c PieceColour) bool {
+	first := LoadInitialPosition(g.initialFen).turn
+	return g.Ply() > 1 || g.Ply() == 1 && first == c
+}
+
+// the colour the bot plays, if it plays in the game
+func (g *Game) BotColour() (PieceColour, bool) {
+	for _, c := range []PieceColour{White, Black} {
+		if g.players[c].me {
+			return c, true
+		}
+	}
+	return White, false
+}
+
+// records the final result of a game that ended with the given Lichess status
+func (g *Game) Finish(status, winner string) {
+	switch {
+	case Aborted(status):
+		g.result = "*"
+	case winner == "white":
+		g.result = "1-0"
+	case winner == "black":
+		g.result = "0-1"
+	default:
+		g.result = "1/2-1/2"
+	}
+}
+
+// whether the move is a capture or a pawn move, which restart the count towards the
+// fifty-move rule
+func resetsHalfmoves(p Position, m Move) bool {
+	return m.capture || p.board[m.from].Type() == Pawn
+}
+
+// replays the game, returning the position, the half-moves since the last capture or pawn
+// move and how often each position since then has occurred
+func (g *Game) replay() (pos Position, halfmoves int, seen map[Position]int) {
+	pos = LoadInitialPosition(g.initialFen)
+	if fields := strings.Fields(g.initialFen); len(fields) > 4 {
+		halfmoves, _ = strconv.Atoi(fields[4])
+	}
+	// positions can only repeat since the last capture or pawn move
+	seen = map[Position]int{pos: 1}
+	for _, moveString := range strings.Fields(g.moves) {
+		m := pos.StringToMove(moveString)
+		if resetsHalfmoves(pos, m) {
+			halfmoves = 0
+			seen = map[Position]int{}
+		} else {
+			halfmoves++
+		}
+		pos = pos.ProcessMove(m)
+		seen[pos]++
+	}
+	return pos, halfmoves, seen
+}
+
+// the half-moves played since the last capture or pawn move
+func (g *Game) HalfmoveClock() int {
+	_, halfmoves, _ := g.replay()
+	return halfmoves
+}
+
+// reports which rule draws the game in its current position: "threefold repetition",
+// "fifty-move rule" or "insufficient material", or "" if none applies
+func (g *Game) DrawReason() string {
+	pos, halfmoves, seen := g.replay()
+	switch {
+	case seen[pos] >= 3:
+		return "threefold repetition"
+	case halfmoves >= 100 && g.moveTree.state == Active:
+		return "fifty-move rule"
+	case pos.InsufficientMaterial():
+		return "insufficient material"
+	}
+	return ""
+}
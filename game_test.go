@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+func TestDrawReason(t *testing.T) {
+	tests := []struct {
+		fen    string
+		moves  string
+		reason string
+	}{
+		{StartFEN, "g1f3 g8f6 f3g1 f6g8 g1f3 g8f6 f3g1", ""},
+		{StartFEN, "g1f3 g8f6 f3g1 f6g8 g1f3 g8f6 f3g1 f6g8", "threefold repetition"},
+		// the pawn move means the earlier positions can never recur
+		{StartFEN, "g1f3 g8f6 f3g1 f6g8 e2e3 g8f6 g1f3 f6g8 f3g1 g8f6 g1f3", ""},
+		{"4k3/8/8/8/8/8/4P3/R3K3 w - - 98 80", "a1a2", ""},
+		{"4k3/8/8/8/8/8/4P3/R3K3 w - - 98 80", "a1a2 e8d8", "fifty-move rule"},
+		{"4k3/8/8/8/8/8/4P3/R3K3 w - - 98 80", "e2e3 e8d8", ""},
+		// checkmate on the hundredth half-move takes precedence
+		{"k7/8/1K6/8/8/8/8/7R w - - 99 80", "h1h8", ""},
+		{"4k3/8/8/8/8/8/8/4K1N1 w - - 0 1", "", "insufficient material"},
+		{"4k3/8/8/2b5/8/8/8/2B1K3 w - - 0 1", "", "insufficient material"},
+		{"4k3/8/8/3b4/8/8/8/2B1K3 w - - 0 1", "", ""},
+		{"4k3/8/8/8/8/8/8/1NN1K3 w - - 0 1", "", ""},
+		{"4k3/8/8/8/8/8/4p3/4K3 w - - 0 1", "", ""},
+	}
+	for _, test := range tests {
+		g := NewGame(test.fen, nil, nil)
+		g.AddMoves(test.moves)
+		if reason := g.DrawReason(); reason != test.reason {
+			t.Errorf("DrawReason() after %q from %q = %q; want %q", test.moves, test.fen, reason, test.reason)
+		}
+	}
+}
+
+func TestTakeBack(t *testing.T) {
+	g := NewGame(StartFEN, nil, nil)
+	g.AddMoves("e2e4 e7e5 g1f3 b8c6 f1c4")
+	g.Annotation(4).comment = "italian"
+	g.TakeBack(2)
+	if g.moves != "e2e4 e7e5 g1f3" || g.moveTree.position.turn != Black || len(g.annotations) > 3 {
+		t.Errorf("TakeBack(2) left moves %q with %v to move and %d annotations", g.moves, g.moveTree.position.turn, len(g.annotations))
+	}
+	g.AddMoves("g8f6")
+	if g.moves != "e2e4 e7e5 g1f3 g8f6" || g.Ply() != 4 {
+		t.Errorf("moves = %q after a takeback and a new move", g.moves)
+	}
+	g.TakeBack(10)
+	if g.moves != "" || g.moveTree.position != LoadInitialPosition(StartFEN) {
+		t.Errorf("TakeBack(10) left moves %q", g.moves)
+	}
+}
+
+func TestHasMoved(t *testing.T) {
+	tests := []struct {
+		fen, moves   string
+		white, black bool
+	}{
+		{StartFEN, "", false, false},
+		{StartFEN, "e2e4", true, false},
+		{StartFEN, "e2e4 e7e5", true, true},
+		{"4k3/8/8/8/8/8/4P3/4K3 b - - 0 1", "e8d8", false, true},
+		{"4k3/8/8/8/8/8/4P3/4K3 b - - 0 1", "e8d8 e2e4", true, true},
+	}
+	for _, test := range tests {
+		g := NewGame(test.fen, nil, nil)
+		g.AddMoves(test.moves)
+		if white, black := g.HasMoved(White), g.HasMoved(Black); white != test.white || black != test.black {
+			t.Errorf("HasMoved() after %q from %q = %t, %t; want %t, %t", test.moves, test.fen, white, black, test.white, test.black)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import "sync"
+
+// GameManager tracks the bot's games by Lichess game ID and shares the search threads between them
+type GameManager struct {
+	mu       sync.Mutex
+	games    map[string]*managedGame
+	maxGames int
+	threads  chan struct{} // holds a token for every search running
+}
+
+type managedGame struct {
+	game      *Game // nil until the game stream sends gameFull
+	streaming bool
+}
+
+func NewGameManager(maxGames, threads int) *GameManager {
+	if threads < 1 {
+		threads = 1
+	}
+	return &GameManager{
+		games:    map[string]*managedGame{},
+		maxGames: maxGames,
+		threads:  make(chan struct{}, threads),
+	}
+}
+
+// takes a slot for a game about to start, unless the bot is already playing the most games it may
+func (gm *GameManager) Reserve(id string) bool {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	if _, ok := gm.games[id]; ok {
+		return true
+	}
+	if len(gm.games) >= gm.maxGames {
+		return false
+	}
+	gm.games[id] = &managedGame{}
+	return true
+}
+
+// marks a started game as streamed, whether or not a slot was reserved for it; false if it
+// already is
+func (gm *GameManager) Start(id string) bool {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	mg, ok := gm.games[id]
+	if !ok {
+		mg = &managedGame{}
+		gm.games[id] = mg
+	}
+	if mg.streaming {
+		return false
+	}
+	mg.streaming = true
+	return true
+}
+
+// stores the game once it is loaded
+func (gm *GameManager) Set(id string, g *Game) {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	if mg, ok := gm.games[id]; ok {
+		mg.game = g
+	}
+}
+
+// the game with the ID, or nil if it is not tracked or not loaded yet
+func (gm *GameManager) Get(id string) *Game {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	if mg, ok := gm.games[id]; ok {
+		return mg.game
+	}
+	return nil
+}
+
+// frees the game's slot
+func (gm *GameManager) Remove(id string) {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	delete(gm.games, id)
+}
+
+func (gm *GameManager) Len() int {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	return len(gm.games)
+}
+
+func (gm *GameManager) Full() bool {
+	return gm.Len() >= gm.maxGames
+}
+
+// runs a search once one of the shared threads is free
+func (gm *GameManager) Search(search func()) {
+	gm.threads <- struct{}{}
+	defer func() { <-gm.threads }()
+	search()
+}
+
+// runs a search if one of the shared threads is free right away, reporting whether it ran
+func (gm *GameManager) TrySearch(search func()) bool {
+	select {
+	case gm.threads <- struct{}{}:
+	default:
+		return false
+	}
+	defer func() { <-gm.threads }()
+	search()
+	return true
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGameManagerSlots(t *testing.T) {
+	gm := NewGameManager(2, 1)
+	if !gm.Reserve("a") || !gm.Reserve("b") {
+		t.Fatal("Reserve() failed below the limit")
+	}
+	if !gm.Reserve("a") {
+		t.Error("Reserve() of a game already reserved failed")
+	}
+	if gm.Reserve("c") || !gm.Full() {
+		t.Error("Reserve() succeeded above the limit")
+	}
+	// games started without a challenge are played anyway
+	if !gm.Start("d") || gm.Start("d") || gm.Len() != 3 {
+		t.Errorf("Start() of a new game twice, Len() = %d; want 3", gm.Len())
+	}
+	if !gm.Start("a") {
+		t.Error("Start() of a reserved game failed")
+	}
+	g := NewGame(StartFEN, nil, nil)
+	gm.Set("a", g)
+	gm.Set("c", g)
+	if gm.Get("a") != g || gm.Get("b") != nil || gm.Get("c") != nil {
+		t.Error("Get() did not return the games set")
+	}
+	gm.Remove("d")
+	gm.Remove("b")
+	if gm.Full() || !gm.Reserve("c") {
+		t.Error("Reserve() failed after games were removed")
+	}
+}
+
+func TestGameManagerConcurrent(t *testing.T) {
+	gm := NewGameManager(10, 1)
+	var wg sync.WaitGroup
+	reserved := make(chan string, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if gm.Reserve(id) {
+				reserved <- id
+			}
+		}(string(rune('A' + i)))
+	}
+	wg.Wait()
+	close(reserved)
+	if n := len(reserved); n != 10 || gm.Len() != 10 {
+		t.Errorf("%d of 50 concurrent reservations succeeded, Len() = %d; want 10", n, gm.Len())
+	}
+}
+
+func TestGameManagerThreads(t *testing.T) {
+	gm := NewGameManager(4, 2)
+	var mu sync.Mutex
+	running, most := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gm.Search(func() {
+				mu.Lock()
+				running++
+				if running > most {
+					most = running
+				}
+				mu.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				mu.Lock()
+				running--
+				mu.Unlock()
+			})
+		}()
+	}
+	wg.Wait()
+	if most != 2 {
+		t.Errorf("%d searches ran at once; want 2", most)
+	}
+}
+
+func TestGameManagerTrySearch(t *testing.T) {
+	gm := NewGameManager(2, 1)
+	ran := false
+	gm.Search(func() {
+		if gm.TrySearch(func() { ran = true }) {
+			t.Error("TrySearch() ran without a free thread")
+		}
+	})
+	if !gm.TrySearch(func() { ran = true }) || !ran {
+		t.Error("TrySearch() did not run with a free thread")
+	}
+}
@@ -2,4 +2,4 @@ module github.com/plin0009/stupid-horse
 
 go 1.16
 
-require github.com/joho/godotenv v1.3.0 // indirect
+require github.com/joho/godotenv v1.3.0
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-const url = "https://lichess.org/api/"
-
 type LichessEvent struct {
 	Type      string
 	Game      *LichessGame
@@ -21,13 +26,16 @@ type LichessGame struct {
 type LichessChallenge struct {
 	Id          string
 	Status      string
+	Challenger  *LichessPlayer
 	Variant     LichessVariant
 	Rated       bool
+	Speed       string
 	TimeControl LichessTimeControl
 	Color       string
 }
 type LichessVariant struct {
-	Key string
+	Key  string
+	Name string
 }
 type LichessTimeControl struct {
 	Type      string
@@ -42,6 +50,8 @@ type LichessGameEvent struct {
 	// gameFull
 	Variant    *LichessVariant
 	Rated      bool
+	Speed      string
+	CreatedAt  int64
 	Clock      *LichessClock
 	White      *LichessPlayer
 	Black      *LichessPlayer
@@ -56,6 +66,11 @@ type LichessGameEvent struct {
 	Binc   uint
 	Status string
 	Winner string
+	// pending offers by each side
+	Wdraw     bool
+	Bdraw     bool
+	Wtakeback bool
+	Btakeback bool
 
 	// chatLine
 	Username string
@@ -73,36 +88,208 @@ type LichessClock struct {
 	Increment uint
 }
 
-func (b Bot) request(mode string, path string) (resp *http.Response, err error) {
-	req, err := http.NewRequest(mode, url+path, nil)
+// LichessAPI is the part of the Lichess bot API the bot uses
+type LichessAPI interface {
+	StreamEvents() (io.ReadCloser, error)
+	StreamGame(gameId string) (io.ReadCloser, error)
+	Move(gameId string, m string) error // the move in UCI notation
+	AcceptChallenge(id string) error
+	DeclineChallenge(id, reason string) error
+	Chat(gameId, room, text string) error // room is "player" or "spectator"
+	Resign(gameId string) error
+	Abort(gameId string) error
+	AnswerDraw(gameId string, accept bool) error
+	AnswerTakeback(gameId string, accept bool) error
+	Account() (*LichessAccount, error)
+}
+
+type LichessAccount struct {
+	Id       string
+	Username string
+	Title    string
+}
+
+// LichessClient talks to a Lichess server over HTTP
+type LichessClient struct {
+	baseURL       string // e.g. "https://lichess.org"
+	token         string
+	client        *http.Client // for requests, with a timeout
+	streamClient  *http.Client // streams stay open as long as they send keep-alives
+	maxAttempts   int          // at a request that fails temporarily, e.g. with a server error
+	retry         Backoff
+	rateLimitWait time.Duration // Lichess asks clients that were rate limited to wait a minute
+
+	mu          sync.Mutex
+	pausedUntil time.Time // no requests are sent until then after a rate limit
+}
+
+func NewLichessClient(baseURL, token string) *LichessClient {
+	return &LichessClient{
+		baseURL:       strings.TrimSuffix(baseURL, "/"),
+		token:         token,
+		client:        &http.Client{Timeout: 15 * time.Second},
+		streamClient:  &http.Client{},
+		maxAttempts:   3,
+		retry:         defaultBackoff,
+		rateLimitWait: time.Minute,
+	}
+}
+
+// APIError is an unsuccessful response from Lichess
+type APIError struct {
+	Method, Path string
+	StatusCode   int
+	Status       string
+	RetryAfter   time.Duration // how long to wait before retrying, for rate limits
+}
+
+func (lc *LichessClient) newAPIError(resp *http.Response, method, path string) *APIError {
+	e := &APIError{Method: method, Path: path, StatusCode: resp.StatusCode, Status: resp.Status}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		e.RetryAfter = lc.rateLimitWait
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			e.RetryAfter = time.Duration(seconds) * time.Second
+		}
+	}
+	return e
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Method, e.Path, e.Status)
+}
+
+// whether the same request may succeed later
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// waits out a rate limit
+func (lc *LichessClient) wait() {
+	lc.mu.Lock()
+	until := lc.pausedUntil
+	lc.mu.Unlock()
+	time.Sleep(time.Until(until))
+}
+
+func (lc *LichessClient) pause(d time.Duration) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if until := time.Now().Add(d); until.After(lc.pausedUntil) {
+		lc.pausedUntil = until
+	}
+}
+
+// sends a request with form-encoded parameters, if any, checking the status of the response
+func (lc *LichessClient) request(client *http.Client, method string, path string, form url.Values) (*http.Response, error) {
+	lc.wait()
+	req, err := http.NewRequest(method, lc.baseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", "Bearer "+lc.token)
+	if form != nil {
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Authorization", "Bearer "+b.token)
-	return http.DefaultClient.Do(req)
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		apiErr := lc.newAPIError(resp, method, path)
+		lc.pause(apiErr.RetryAfter)
+		return nil, apiErr
+	}
+	return resp, nil
 }
 
-func stream(resp *http.Response, lines chan<- []byte) {
-	for {
-		bytes := make([]byte, 8192)
-		_, err := resp.Body.Read(bytes)
-		if err == io.EOF {
-			close(lines)
-			return
-		}
-		if err != nil {
-			log.Fatal(err)
-		}
-		start := 0
-		var line []byte
-		for i, v := range bytes {
-			if v == 10 {
-				line = bytes[start:i]
-				if len(line) == 0 {
-					break
-				}
-				lines <- line
+// sends a request, retrying network errors, rate limits and server errors, and decodes the
+// JSON response into v unless it is nil
+func (lc *LichessClient) call(method string, path string, form url.Values, v interface{}) error {
+	retry := lc.retry
+	for attempt := 1; ; attempt++ {
+		resp, err := lc.request(lc.client, method, path, form)
+		if err == nil {
+			defer resp.Body.Close()
+			if v == nil {
+				return nil
 			}
+			return json.NewDecoder(resp.Body).Decode(v)
 		}
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && !apiErr.Temporary() || attempt == lc.maxAttempts {
+			return err
+		}
+		wait := retry.Next()
+		warnf("%v, retrying in %v", err, wait)
+		time.Sleep(wait)
+	}
+}
+
+// connects to a streaming endpoint; errors that reconnecting cannot fix are permanent
+func (lc *LichessClient) stream(path string) (io.ReadCloser, error) {
+	resp, err := lc.request(lc.streamClient, "GET", path, nil)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && !apiErr.Temporary() {
+		return nil, permanentError{err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (lc *LichessClient) StreamEvents() (io.ReadCloser, error) {
+	return lc.stream("/api/stream/event")
+}
+
+func (lc *LichessClient) StreamGame(gameId string) (io.ReadCloser, error) {
+	return lc.stream("/api/bot/game/stream/" + gameId)
+}
+
+func (lc *LichessClient) Move(gameId string, m string) error {
+	return lc.call("POST", "/api/bot/game/"+gameId+"/move/"+m, nil, nil)
+}
+
+func (lc *LichessClient) AcceptChallenge(id string) error {
+	return lc.call("POST", "/api/challenge/"+id+"/accept", nil, nil)
+}
+
+func (lc *LichessClient) DeclineChallenge(id, reason string) error {
+	return lc.call("POST", "/api/challenge/"+id+"/decline", url.Values{"reason": {reason}}, nil)
+}
+
+func (lc *LichessClient) Chat(gameId, room, text string) error {
+	return lc.call("POST", "/api/bot/game/"+gameId+"/chat", url.Values{"room": {room}, "text": {text}}, nil)
+}
+
+func (lc *LichessClient) Resign(gameId string) error {
+	return lc.call("POST", "/api/bot/game/"+gameId+"/resign", nil, nil)
+}
+
+func (lc *LichessClient) Abort(gameId string) error {
+	return lc.call("POST", "/api/bot/game/"+gameId+"/abort", nil, nil)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func (lc *LichessClient) AnswerDraw(gameId string, accept bool) error {
+	return lc.call("POST", "/api/bot/game/"+gameId+"/draw/"+yesNo(accept), nil, nil)
+}
+
+func (lc *LichessClient) AnswerTakeback(gameId string, accept bool) error {
+	return lc.call("POST", "/api/bot/game/"+gameId+"/takeback/"+yesNo(accept), nil, nil)
+}
+
+func (lc *LichessClient) Account() (*LichessAccount, error) {
+	var a LichessAccount
+	if err := lc.call("GET", "/api/account", nil, &a); err != nil {
+		return nil, err
 	}
+	return &a, nil
 }
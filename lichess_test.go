@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// the requests a server received
+type requestLog struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (rl *requestLog) add(r *http.Request) {
+	r.ParseForm()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.requests = append(rl.requests, fmt.Sprintf("%s %s %s %s", r.Method, r.URL.Path, r.PostForm.Encode(), r.Header.Get("Authorization")))
+}
+
+// returns the requests received since the last call
+func (rl *requestLog) take() []string {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	requests := rl.requests
+	rl.requests = nil
+	return requests
+}
+
+// records every request and answers with the handler
+func recordingServer(t *testing.T, handler http.HandlerFunc) (*LichessClient, *requestLog) {
+	requests := new(requestLog)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.add(r)
+		handler(w, r)
+	}))
+	t.Cleanup(s.Close)
+	lc := NewLichessClient(s.URL+"/", "token")
+	lc.retry = Backoff{Min: time.Millisecond, Max: time.Millisecond}
+	lc.rateLimitWait = 10 * time.Millisecond
+	return lc, requests
+}
+
+func TestLichessClientRequests(t *testing.T) {
+	lc, requests := recordingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/account" {
+			io.WriteString(w, `{"id":"stupid-horse","username":"stupid-horse","title":"BOT"}`)
+		}
+	})
+	tests := []struct {
+		call    func() error
+		request string
+	}{
+		{func() error { return lc.Move("g1", "e2e4") }, "POST /api/bot/game/g1/move/e2e4  Bearer token"},
+		{func() error { return lc.AcceptChallenge("c1") }, "POST /api/challenge/c1/accept  Bearer token"},
+		{func() error { return lc.DeclineChallenge("c1", "tooFast") }, "POST /api/challenge/c1/decline reason=tooFast Bearer token"},
+		{func() error { return lc.Chat("g1", "player", "good game") }, "POST /api/bot/game/g1/chat room=player&text=good+game Bearer token"},
+		{func() error { return lc.Resign("g1") }, "POST /api/bot/game/g1/resign  Bearer token"},
+		{func() error { return lc.Abort("g1") }, "POST /api/bot/game/g1/abort  Bearer token"},
+		{func() error { return lc.AnswerDraw("g1", true) }, "POST /api/bot/game/g1/draw/yes  Bearer token"},
+		{func() error { return lc.AnswerTakeback("g1", false) }, "POST /api/bot/game/g1/takeback/no  Bearer token"},
+		{func() error {
+			body, err := lc.StreamGame("g1")
+			if err == nil {
+				body.Close()
+			}
+			return err
+		}, "GET /api/bot/game/stream/g1  Bearer token"},
+	}
+	for _, test := range tests {
+		err := test.call()
+		if sent := requests.take(); err != nil || len(sent) != 1 || sent[0] != test.request {
+			t.Errorf("sent %q, error %v; want %q", sent, err, test.request)
+		}
+	}
+	if a, err := lc.Account(); err != nil || a.Id != "stupid-horse" || a.Title != "BOT" {
+		t.Errorf("Account() = %v, %v", a, err)
+	}
+}
+
+func TestLichessClientErrors(t *testing.T) {
+	statuses := []int{}
+	lc, requests := recordingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[0]
+		statuses = statuses[1:]
+		w.WriteHeader(status)
+	})
+	tests := []struct {
+		statuses []int
+		attempts int
+		status   int // of the error, 0 for success
+	}{
+		{[]int{200}, 1, 0},
+		{[]int{503, 502, 200}, 3, 0},
+		{[]int{500, 500, 500}, 3, 500},
+		{[]int{429, 200}, 2, 0},
+		{[]int{400}, 1, 400},
+		{[]int{404}, 1, 404},
+	}
+	for _, test := range tests {
+		statuses = test.statuses
+		err := lc.Move("g1", "e2e4")
+		attempts := len(requests.take())
+		var apiErr *APIError
+		status := 0
+		if errors.As(err, &apiErr) {
+			status = apiErr.StatusCode
+		}
+		if attempts != test.attempts || status != test.status || (status == 0) != (err == nil) {
+			t.Errorf("responses %v: %d attempts, error %v; want %d attempts, status %d", test.statuses, attempts, err, test.attempts, test.status)
+		}
+	}
+
+	statuses = []int{401}
+	if _, err := lc.StreamEvents(); !errors.As(err, new(permanentError)) {
+		t.Errorf("StreamEvents() when unauthorized = %v; want a permanent error", err)
+	}
+}
+
+func TestLichessClientRateLimit(t *testing.T) {
+	limited := true
+	lc, _ := recordingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if limited {
+			limited = false
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	})
+	lc.maxAttempts = 1
+	if err := lc.Move("g1", "e2e4"); err == nil {
+		t.Fatal("Move() should fail when rate limited")
+	}
+	// the next request waits out the limit
+	start := time.Now()
+	if err := lc.Move("g1", "e2e4"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < lc.rateLimitWait/2 {
+		t.Errorf("request after a rate limit was sent after %v; want a wait of %v", elapsed, lc.rateLimitWait)
+	}
+}
+
+func TestLichessClientTimeout(t *testing.T) {
+	lc, requests := recordingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	})
+	lc.client.Timeout = 20 * time.Millisecond
+	lc.maxAttempts = 2
+	err := lc.Resign("g1")
+	time.Sleep(10 * time.Millisecond) // for the second request to arrive
+	if attempts := len(requests.take()); err == nil || attempts != 2 {
+		t.Errorf("Resign() = %v after %d attempts; want a timeout after 2", err, attempts)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	lc := NewLichessClient("https://lichess.org", "")
+	tests := []struct {
+		status     int
+		retryAfter string
+		temporary  bool
+		wait       time.Duration
+	}{
+		{http.StatusBadRequest, "", false, 0},
+		{http.StatusNotFound, "", false, 0},
+		{http.StatusTooManyRequests, "", true, time.Minute},
+		{http.StatusTooManyRequests, "5", true, 5 * time.Second},
+		{http.StatusBadGateway, "", true, 0},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		if test.retryAfter != "" {
+			w.Header().Set("Retry-After", test.retryAfter)
+		}
+		w.WriteHeader(test.status)
+		e := lc.newAPIError(w.Result(), "POST", "/api/bot/game/x/move/e2e4")
+		if e.Temporary() != test.temporary || e.RetryAfter != test.wait {
+			t.Errorf("status %d: Temporary() = %v, RetryAfter = %v; want %v, %v", test.status, e.Temporary(), e.RetryAfter, test.temporary, test.wait)
+		}
+	}
+}
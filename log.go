@@ -0,0 +1,45 @@
+package main
+
+import "fmt"
+
+// LogLevel is how much the bot prints about what it does
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota // every line of the streams
+	LogInfo                  // games, challenges and moves
+	LogWarn                  // trouble the bot works around
+	LogError                 // failures
+)
+
+var logLevelNames = map[string]LogLevel{
+	"debug": LogDebug,
+	"info":  LogInfo,
+	"warn":  LogWarn,
+	"error": LogError,
+}
+
+// the least important messages printed, set once at startup
+var logLevel = LogInfo
+
+func logf(level LogLevel, format string, a ...interface{}) {
+	if level >= logLevel {
+		fmt.Printf(format+"\n", a...)
+	}
+}
+
+func debugf(format string, a ...interface{}) {
+	logf(LogDebug, format, a...)
+}
+
+func infof(format string, a ...interface{}) {
+	logf(LogInfo, format, a...)
+}
+
+func warnf(format string, a ...interface{}) {
+	logf(LogWarn, format, a...)
+}
+
+func errorf(format string, a ...interface{}) {
+	logf(LogError, format, a...)
+}
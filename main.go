@@ -1,5 +1,46 @@
 package main
 
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
 func main() {
-	StartBot()
+	// without a command, or with only flags, the bot plays on Lichess as it always has
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") && !isHelp(os.Args[1]) {
+		StartBot(os.Args[1:])
+		return
+	}
+	args := os.Args[2:]
+	switch os.Args[1] {
+	case "lichess":
+		StartBot(args)
+	case "uci":
+		StartUCI(args)
+	case "perft":
+		StartPerft(args)
+	case "analyse", "analyze":
+		StartAnalyse(args)
+	case "bench":
+		StartBench(args)
+	case "epd":
+		StartEPD(args)
+	case "match":
+		StartMatch(args)
+	case "selfplay":
+		StartSelfPlay(args)
+	case "play":
+		StartPlay(args)
+	case "book":
+		StartBook(args)
+	case "config":
+		StartConfig(args)
+	case "-h", "-help", "--help", "help":
+		commandsUsage(os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		commandsUsage(os.Stderr)
+		os.Exit(2)
+	}
 }
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MatchEngine is one side of a match: the engine in this binary or an external UCI engine
+type MatchEngine interface {
+	Name() string
+	NewGame(chess960 bool) error
+	// picks a move in the game's current position, with the score in centipawns from
+	// white's point of view when the engine reports one
+	Go(g *Game, remaining map[PieceColour]time.Duration, increment time.Duration, chess960 bool) (m Move, score int, hasScore bool, err error)
+	Close() error
+}
+
+// internalEngine searches with this binary's engine
+type internalEngine struct {
+	name   string
+	depth  int
+	tables *SearchTables // of the current game
+}
+
+func (e *internalEngine) Name() string {
+	return e.name
+}
+
+func (e *internalEngine) NewGame(chess960 bool) error {
+	e.tables = NewSearchTables(TTMaxSize)
+	return nil
+}
+
+func (e *internalEngine) Go(g *Game, remaining map[PieceColour]time.Duration, increment time.Duration, chess960 bool) (Move, int, bool, error) {
+	tree := &MoveTree{position: g.moveTree.position}
+	if e.tables == nil {
+		e.tables = NewSearchTables(TTMaxSize)
+	}
+	e.tables.Advance(g.Ply())
+	limits := Limits{depth: e.depth, tables: e.tables}
+	if t, ok := remaining[tree.position.turn]; ok {
+		limits.deadline = time.Now().Add(AllocateTime(t, increment))
+	}
+	eval := ThinkUntil(tree, limits, nil)
+	if tree.follow == nil {
+		return NoMove, 0, false, errors.New("no legal moves")
+	}
+	// as an external engine would score it, without the pawn term's bias towards white
+	return tree.follow.move, evalToCentipawns(unbiased(eval, tree.position)), true, nil
+}
+
+func (e *internalEngine) Close() error {
+	return nil
+}
+
+// starts an engine from a specification such as "internal depth=4" or
+// "./old-build uci option.Depth=5": "internal" selects this binary's engine, anything else
+// is the command line of a UCI engine, and option.<name>=<value> words set UCI options
+func StartMatchEngine(spec string) (MatchEngine, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, errors.New("empty engine specification")
+	}
+	if fields[0] == "internal" {
+		e := &internalEngine{name: "stupid-horse", depth: MaxPly}
+		for _, field := range fields[1:] {
+			value := strings.TrimPrefix(field, "depth=")
+			depth, err := strconv.Atoi(value)
+			if value == field || err != nil || depth < 1 || depth > MaxPly {
+				return nil, fmt.Errorf("invalid internal engine option %q", field)
+			}
+			e.depth = depth
+			e.name = fmt.Sprintf("stupid-horse depth %d", depth)
+		}
+		return e, nil
+	}
+	var command []string
+	options := map[string]string{}
+	for _, field := range fields {
+		if strings.HasPrefix(field, "option.") && strings.Contains(field, "=") {
+			kv := strings.SplitN(strings.TrimPrefix(field, "option."), "=", 2)
+			options[kv[0]] = kv[1]
+			continue
+		}
+		command = append(command, field)
+	}
+	return StartUCIEngine(command, options)
+}
+
+// TimeControl gives each side base time and adds increment after every move
+type TimeControl struct {
+	base, increment time.Duration
+}
+
+// parses "seconds+increment", e.g. "10+0.1" or "60"
+func ParseTimeControl(s string) (TimeControl, error) {
+	var tc TimeControl
+	parts := strings.SplitN(s, "+", 2)
+	for i, part := range parts {
+		seconds, err := strconv.ParseFloat(part, 64)
+		if err != nil || seconds < 0 {
+			return tc, fmt.Errorf("invalid time control %q", s)
+		}
+		d := time.Duration(seconds * float64(time.Second))
+		if i == 0 {
+			tc.base = d
+		} else {
+			tc.increment = d
+		}
+	}
+	if tc.base == 0 {
+		return tc, fmt.Errorf("invalid time control %q", s)
+	}
+	return tc, nil
+}
+
+func (tc TimeControl) String() string {
+	return strconv.FormatFloat(tc.base.Seconds(), 'f', -1, 64) + "+" +
+		strconv.FormatFloat(tc.increment.Seconds(), 'f', -1, 64)
+}
+
+// Adjudication ends games early once both engines agree on the outcome
+type Adjudication struct {
+	resignScore int // centipawns; a mate score always counts
+	resignMoves int // consecutive moves of each side beyond resignScore, 0 to disable
+	drawScore   int // centipawns
+	drawMoves   int // consecutive moves of each side within drawScore, 0 to disable
+	drawAfter   int // full moves before draw adjudication starts
+}
+
+func DefaultAdjudication() Adjudication {
+	return Adjudication{
+		resignScore: 1000,
+		resignMoves: 3,
+		drawScore:   10,
+		drawMoves:   8,
+		drawAfter:   40,
+	}
+}
+
+// Opening is a start position, optionally followed by moves in coordinate notation
+type Opening struct {
+	fen   string
+	moves string
+}
+
+// reads openings from a PGN file (the main line of each game) or an EPD/FEN file
+func ReadOpenings(filename string) ([]Opening, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var openings []Opening
+	if strings.EqualFold(filepath.Ext(filename), ".pgn") {
+		games, err := ReadPGN(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range games {
+			openings = append(openings, Opening{fen: g.initialFen, moves: g.moves})
+		}
+	} else {
+		epds, err := ReadEPDs(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range epds {
+			openings = append(openings, Opening{fen: e.fen})
+		}
+	}
+	if len(openings) == 0 {
+		return nil, fmt.Errorf("no openings in %s", filename)
+	}
+	return openings, nil
+}
+
+// Match plays two engines against each other, each opening once with either colour
+type Match struct {
+	engines      [2]MatchEngine
+	openings     []Opening
+	timeControl  TimeControl
+	adjudication Adjudication
+	chess960     bool
+	sprt         SPRT
+}
+
+// plays one game and returns it with its result and termination reason set
+func (m *Match) PlayGame(opening Opening, white, black MatchEngine) (*Game, string, error) {
+	g := NewGame(opening.fen, map[PieceColour]Player{
+		White: {name: white.Name()},
+		Black: {name: black.Name()},
+	}, nil)
+	g.started = time.Now()
+	if m.chess960 {
+		g.variant = "chess960"
+	}
+	g.tags["TimeControl"] = m.timeControl.String()
+	if opening.moves != "" {
+		g.AddMoves(opening.moves)
+	}
+	engines := map[PieceColour]MatchEngine{White: white, Black: black}
+	for _, e := range engines {
+		if err := e.NewGame(m.chess960); err != nil {
+			return nil, "", err
+		}
+	}
+	remaining := map[PieceColour]time.Duration{White: m.timeControl.base, Black: m.timeControl.base}
+	// consecutive plies that were beyond the resign or within the draw score
+	winning := map[PieceColour]int{}
+	drawn := 0
+
+	end := func(winner PieceColour, draw bool, reason string) (*Game, string, error) {
+		switch {
+		case draw:
+			g.result = "1/2-1/2"
+		case winner == White:
+			g.result = "1-0"
+		default:
+			g.result = "0-1"
+		}
+		g.tags["Termination"] = reason
+		return g, reason, nil
+	}
+	for {
+		switch g.moveTree.state {
+		case WhiteWon:
+			return end(White, false, "checkmate")
+		case BlackWon:
+			return end(Black, false, "checkmate")
+		case Stalemate:
+			return end(White, true, "stalemate")
+		}
+		if reason := g.DrawReason(); reason != "" {
+			return end(White, true, reason)
+		}
+		pos := g.moveTree.position
+		turn := pos.turn
+		start := time.Now()
+		move, score, hasScore, err := engines[turn].Go(g, remaining, m.timeControl.increment, m.chess960)
+		remaining[turn] -= time.Since(start)
+		if remaining[turn] < 0 {
+			return end(turn.Flip(), false, "time forfeit")
+		}
+		if err != nil {
+			// the engine crashed or could not be talked to, which says nothing of its play
+			return nil, "", err
+		}
+		if !pos.IsLegal(move) {
+			return end(turn.Flip(), false, "illegal move")
+		}
+		remaining[turn] += m.timeControl.increment
+		a := g.Annotation(g.Ply())
+		a.clock = remaining[turn]
+		if hasScore {
+			a.eval, a.hasEval = centipawnsToEval(score), true
+		}
+		g.AddMoves(move.String())
+
+		// adjudicate once both sides have agreed for long enough
+		adj := m.adjudication
+		if !hasScore {
+			winning[White], winning[Black], drawn = 0, 0, 0
+			continue
+		}
+		for _, c := range []PieceColour{White, Black} {
+			if score*colourMultiplier[c] >= adj.resignScore || score*colourMultiplier[c] >= mateCentipawns/2 {
+				winning[c]++
+			} else {
+				winning[c] = 0
+			}
+			if adj.resignMoves > 0 && winning[c] >= adj.resignMoves*2 {
+				return end(c, false, "adjudication")
+			}
+		}
+		if score >= -adj.drawScore && score <= adj.drawScore && (g.Ply()+1)/2 >= adj.drawAfter {
+			drawn++
+		} else {
+			drawn = 0
+		}
+		if adj.drawMoves > 0 && drawn >= adj.drawMoves*2 {
+			return end(White, true, "adjudication")
+		}
+	}
+}
+
+// MatchScore counts results from the first engine's point of view
+type MatchScore struct {
+	wins, draws, losses int
+}
+
+func (s MatchScore) games() int {
+	return s.wins + s.draws + s.losses
+}
+
+// fraction of the points scored, and the variance of a single game's score
+func (s MatchScore) mean() (mean, variance float64) {
+	n := float64(s.games())
+	if n == 0 {
+		return 0.5, 0
+	}
+	w, d, l := float64(s.wins)/n, float64(s.draws)/n, float64(s.losses)/n
+	mean = w + d/2
+	variance = w*math.Pow(1-mean, 2) + d*math.Pow(0.5-mean, 2) + l*math.Pow(mean, 2)
+	return mean, variance
+}
+
+// converts an expected score to a logistic Elo difference
+func scoreToElo(score float64) float64 {
+	return 400 * math.Log10(score/(1-score))
+}
+
+func eloToScore(elo float64) float64 {
+	return 1 / (1 + math.Pow(10, -elo/400))
+}
+
+// Elo difference of the first engine with its 95% confidence margin
+func (s MatchScore) Elo() (elo, margin float64) {
+	mean, variance := s.mean()
+	if s.games() == 0 {
+		return 0, math.Inf(1)
+	}
+	if s.wins == 0 && s.draws == 0 {
+		return math.Inf(-1), math.Inf(1)
+	}
+	if s.losses == 0 && s.draws == 0 {
+		return math.Inf(1), math.Inf(1)
+	}
+	deviation := 1.959964 * math.Sqrt(variance/float64(s.games()))
+	low, high := mean-deviation, mean+deviation
+	margin = math.Inf(1)
+	if low > 0 && high < 1 {
+		margin = (scoreToElo(high) - scoreToElo(low)) / 2
+	}
+	return scoreToElo(mean), margin
+}
+
+// SPRT is a sequential probability ratio test of H0 (elo = elo0) against H1 (elo = elo1)
+type SPRT struct {
+	elo0, elo1  float64
+	alpha, beta float64 // false positive and false negative rates
+}
+
+// log-likelihood ratio of the results under a normal approximation of the score
+func (t SPRT) LLR(s MatchScore) float64 {
+	mean, variance := s.mean()
+	if variance == 0 {
+		return 0
+	}
+	s0, s1 := eloToScore(t.elo0), eloToScore(t.elo1)
+	return float64(s.games()) * (s1 - s0) * (2*mean - s0 - s1) / (2 * variance)
+}
+
+// the LLR below which H0 is accepted and above which H1 is accepted
+func (t SPRT) Bounds() (lower, upper float64) {
+	return math.Log(t.beta / (1 - t.alpha)), math.Log((1 - t.beta) / t.alpha)
+}
+
+// "H1 accepted", "H0 accepted" or "" while the test is undecided
+func (t SPRT) Verdict(s MatchScore) string {
+	llr := t.LLR(s)
+	lower, upper := t.Bounds()
+	switch {
+	case llr >= upper:
+		return "H1 accepted"
+	case llr <= lower:
+		return "H0 accepted"
+	}
+	return ""
+}
+
+// plays up to the given number of games, stopping early once the SPRT is decided, and
+// reports after every game; games are appended to pgnOut when it is not empty
+func (m *Match) Run(games int, pgnOut string, report func(s MatchScore, g *Game, reason string)) (MatchScore, error) {
+	var s MatchScore
+	for i := 0; i < games; i++ {
+		opening := m.openings[(i/2)%len(m.openings)]
+		first, second := m.engines[0], m.engines[1]
+		firstColour := White
+		if i%2 == 1 {
+			first, second = second, first
+			firstColour = Black
+		}
+		g, reason, err := m.PlayGame(opening, first, second)
+		if err != nil {
+			return s, err
+		}
+		g.tags["Round"] = strconv.Itoa(i + 1)
+		g.tags["Event"] = fmt.Sprintf("%s vs %s", m.engines[0].Name(), m.engines[1].Name())
+		switch {
+		case g.result == "1/2-1/2":
+			s.draws++
+		case (g.result == "1-0") == (firstColour == White):
+			s.wins++
+		default:
+			s.losses++
+		}
+		if pgnOut != "" {
+			if err := appendPGN(pgnOut, g); err != nil {
+				return s, err
+			}
+		}
+		if report != nil {
+			report(s, g, reason)
+		}
+		if m.sprt.Verdict(s) != "" {
+			break
+		}
+	}
+	return s, nil
+}
+
+func appendPGN(filename string, g *Game) error {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := g.WritePGN(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runs a match between two engine configurations, e.g. to test a change with an SPRT
+func StartMatch(args []string) {
+	fs := commandFlags("match")
+	engine1 := fs.String("engine1", "internal", `first engine: "internal [depth=n]" or a UCI command line with option.<name>=<value> words`)
+	engine2 := fs.String("engine2", "internal", "second engine, as for -engine1")
+	openingsFile := fs.String("openings", "", "EPD or PGN file of openings, each played with both colours (default: the start position)")
+	chess960 := fs.Bool("chess960", false, "openings are Chess960 positions")
+	games := fs.Int("games", 100, "maximum number of games")
+	tcString := fs.String("tc", "10+0.1", "time control as seconds+increment")
+	adj := DefaultAdjudication()
+	resignScore := fs.Int("resign-score", adj.resignScore, "centipawns at which a side is adjudicated lost")
+	resignMoves := fs.Int("resign-moves", adj.resignMoves, "moves both engines must agree on the resign score (0 to disable)")
+	drawScore := fs.Int("draw-score", adj.drawScore, "centipawns within which a game is adjudicated drawn")
+	drawMoves := fs.Int("draw-moves", adj.drawMoves, "moves both engines must agree on the draw score (0 to disable)")
+	drawAfter := fs.Int("draw-after", adj.drawAfter, "move number from which draws are adjudicated")
+	elo0 := fs.Float64("elo0", 0, "Elo difference of the SPRT null hypothesis")
+	elo1 := fs.Float64("elo1", 5, "Elo difference of the SPRT alternative hypothesis")
+	alpha := fs.Float64("alpha", 0.05, "SPRT false positive rate")
+	beta := fs.Float64("beta", 0.05, "SPRT false negative rate")
+	pgnOut := fs.String("pgnout", "", "append finished games to this PGN file")
+	fs.Parse(args)
+
+	tc, err := ParseTimeControl(*tcString)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	m := &Match{
+		openings:    []Opening{{fen: StartFEN}},
+		timeControl: tc,
+		adjudication: Adjudication{
+			resignScore: *resignScore,
+			resignMoves: *resignMoves,
+			drawScore:   *drawScore,
+			drawMoves:   *drawMoves,
+			drawAfter:   *drawAfter,
+		},
+		chess960: *chess960,
+		sprt:     SPRT{elo0: *elo0, elo1: *elo1, alpha: *alpha, beta: *beta},
+	}
+	if *openingsFile != "" {
+		m.openings, err = ReadOpenings(*openingsFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	for i, spec := range []string{*engine1, *engine2} {
+		m.engines[i], err = StartMatchEngine(spec)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer m.engines[i].Close()
+	}
+
+	lower, upper := m.sprt.Bounds()
+	s, err := m.Run(*games, *pgnOut, func(s MatchScore, g *Game, reason string) {
+		elo, margin := s.Elo()
+		fmt.Printf("game %d: %s - %s %s (%s)  score %d-%d-%d  elo %.1f +/- %.1f  LLR %.2f (%.2f, %.2f)\n",
+			s.games(), g.players[White].name, g.players[Black].name, g.result, reason,
+			s.wins, s.losses, s.draws, elo, margin, m.sprt.LLR(s), lower, upper)
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	elo, margin := s.Elo()
+	fmt.Printf("%s vs %s: %d wins, %d losses, %d draws\n", m.engines[0].Name(), m.engines[1].Name(), s.wins, s.losses, s.draws)
+	fmt.Printf("elo difference %.1f +/- %.1f\n", elo, margin)
+	verdict := m.sprt.Verdict(s)
+	if verdict == "" {
+		verdict = "inconclusive"
+	}
+	fmt.Printf("SPRT [%g, %g]: %s\n", m.sprt.elo0, m.sprt.elo1, verdict)
+}
+
+// plays the engine against itself, printing every game as PGN
+func StartSelfPlay(args []string) {
+	fs := commandFlags("selfplay")
+	depth := fs.Int("depth", MaxPly, "maximum search depth per move")
+	tcString := fs.String("tc", "10+0.1", "time control as seconds+increment")
+	games := fs.Int("games", 1, "number of games")
+	fen := fs.String("fen", StartFEN, "start position")
+	openingsFile := fs.String("openings", "", "EPD or PGN file of openings played in turn instead of -fen")
+	chess960 := fs.Bool("chess960", false, "the start positions are Chess960 positions")
+	pgnOut := fs.String("pgnout", "", "append the games to this PGN file instead of printing them")
+	fs.Parse(args)
+	if fs.NArg() > 0 || *depth < 1 || *depth > MaxPly {
+		usageError(fs)
+	}
+	tc, err := ParseTimeControl(*tcString)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	if err := CheckFEN(*fen); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	m := &Match{openings: []Opening{{fen: *fen}}, timeControl: tc, chess960: *chess960}
+	if *openingsFile != "" {
+		m.openings, err = ReadOpenings(*openingsFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	name := fmt.Sprintf("stupid-horse depth %d", *depth)
+	white := &internalEngine{name: name, depth: *depth}
+	black := &internalEngine{name: name, depth: *depth}
+	for i := 0; i < *games; i++ {
+		g, reason, err := m.PlayGame(m.openings[i%len(m.openings)], white, black)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		g.tags["Event"] = "stupid-horse self-play"
+		g.tags["Round"] = strconv.Itoa(i + 1)
+		if *pgnOut != "" {
+			err = appendPGN(*pgnOut, g)
+			fmt.Printf("game %d: %s (%s) in %d moves\n", i+1, g.result, reason, (g.Ply()+1)/2)
+		} else {
+			err = g.WritePGN(os.Stdout)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+}
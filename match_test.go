@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseTimeControl(t *testing.T) {
+	tests := []struct {
+		s               string
+		base, increment time.Duration
+	}{
+		{"10+0.1", 10 * time.Second, 100 * time.Millisecond},
+		{"60", time.Minute, 0},
+		{"0.5+0", 500 * time.Millisecond, 0},
+	}
+	for _, test := range tests {
+		tc, err := ParseTimeControl(test.s)
+		if err != nil {
+			t.Errorf("ParseTimeControl(%q) error: %v", test.s, err)
+			continue
+		}
+		if tc.base != test.base || tc.increment != test.increment {
+			t.Errorf("ParseTimeControl(%q) = %v+%v; want %v+%v", test.s, tc.base, tc.increment, test.base, test.increment)
+		}
+	}
+	for _, s := range []string{"", "0+1", "ten", "-5+1"} {
+		if _, err := ParseTimeControl(s); err == nil {
+			t.Errorf("ParseTimeControl(%q) should fail", s)
+		}
+	}
+}
+
+func TestMatchScoreElo(t *testing.T) {
+	tests := []struct {
+		score       MatchScore
+		elo, margin float64
+	}{
+		{MatchScore{wins: 10, draws: 0, losses: 10}, 0, 163.3},
+		{MatchScore{wins: 60, draws: 20, losses: 20}, 147.2, 66.0},
+		{MatchScore{wins: 20, draws: 20, losses: 60}, -147.2, 66.0},
+	}
+	for _, test := range tests {
+		elo, margin := test.score.Elo()
+		if math.Abs(elo-test.elo) > 0.1 || math.Abs(margin-test.margin) > 0.1 {
+			t.Errorf("%+v.Elo() = %.1f +/- %.1f; want %.1f +/- %.1f", test.score, elo, margin, test.elo, test.margin)
+		}
+	}
+	if elo, _ := (MatchScore{wins: 3}).Elo(); !math.IsInf(elo, 1) {
+		t.Errorf("Elo of a clean sweep = %v; want +Inf", elo)
+	}
+}
+
+func TestSPRT(t *testing.T) {
+	sprt := SPRT{elo0: 0, elo1: 5, alpha: 0.05, beta: 0.05}
+	lower, upper := sprt.Bounds()
+	if math.Abs(lower+2.944) > 0.001 || math.Abs(upper-2.944) > 0.001 {
+		t.Errorf("Bounds() = %.3f, %.3f; want -2.944, 2.944", lower, upper)
+	}
+	tests := []struct {
+		score   MatchScore
+		verdict string
+	}{
+		{MatchScore{wins: 10, draws: 10, losses: 10}, ""},
+		{MatchScore{wins: 3000, draws: 4000, losses: 2800}, "H1 accepted"},
+		{MatchScore{wins: 2800, draws: 4000, losses: 3000}, "H0 accepted"},
+	}
+	for _, test := range tests {
+		if verdict := sprt.Verdict(test.score); verdict != test.verdict {
+			t.Errorf("Verdict(%+v) = %q (LLR %.2f); want %q", test.score, verdict, sprt.LLR(test.score), test.verdict)
+		}
+	}
+}
+
+func TestMatchRun(t *testing.T) {
+	m := &Match{
+		engines:      [2]MatchEngine{&internalEngine{name: "depth 2", depth: 2}, &internalEngine{name: "depth 1", depth: 1}},
+		openings:     []Opening{{fen: "4k3/pppppppp/8/8/8/8/PPPPPPPP/4K3 w - - 0 1"}},
+		timeControl:  TimeControl{base: 10 * time.Second},
+		adjudication: Adjudication{drawScore: 0, drawMoves: 10, drawAfter: 20},
+		sprt:         SPRT{elo0: 0, elo1: 5, alpha: 0.05, beta: 0.05},
+	}
+	games := 0
+	s, err := m.Run(2, "", func(s MatchScore, g *Game, reason string) {
+		games++
+		if g.result == "*" || g.tags["Termination"] != reason {
+			t.Errorf("game %d ended %s with termination %q and reason %q", games, g.result, g.tags["Termination"], reason)
+		}
+		// the first engine alternates colours
+		if g.players[White].name != m.engines[(games-1)%2].Name() {
+			t.Errorf("game %d: white is %s", games, g.players[White].name)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.games() != 2 || games != 2 {
+		t.Errorf("played %d games, reported %d; want 2", s.games(), games)
+	}
+}
+
+// scriptedEngine answers every search with the same move, or fails
+type scriptedEngine struct {
+	move string
+	err  error
+}
+
+func (e *scriptedEngine) Name() string                { return "scripted" }
+func (e *scriptedEngine) NewGame(chess960 bool) error { return nil }
+func (e *scriptedEngine) Close() error                { return nil }
+
+func (e *scriptedEngine) Go(g *Game, remaining map[PieceColour]time.Duration, increment time.Duration, chess960 bool) (Move, int, bool, error) {
+	if e.err != nil {
+		return NoMove, 0, false, e.err
+	}
+	return g.moveTree.position.StringToMove(e.move), 0, false, nil
+}
+
+func TestMatchPlayGameErrors(t *testing.T) {
+	m := &Match{openings: []Opening{{fen: StartFEN}}, timeControl: TimeControl{base: 10 * time.Second}}
+	opponent := &internalEngine{name: "depth 1", depth: 1}
+
+	// an illegal move loses the game
+	g, reason, err := m.PlayGame(m.openings[0], &scriptedEngine{move: "e2e5"}, opponent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason != "illegal move" || g.result != "0-1" {
+		t.Errorf("PlayGame() with an illegal move = %s, %q; want 0-1, \"illegal move\"", g.result, reason)
+	}
+	// a crashed engine stops the match instead of forfeiting
+	crash := errors.New("scripted exited")
+	if _, _, err := m.PlayGame(m.openings[0], &scriptedEngine{err: crash}, opponent); err != crash {
+		t.Errorf("PlayGame() with a crashed engine error = %v; want %v", err, crash)
+	}
+}
+
+func TestMatchAdjudicationFromStart(t *testing.T) {
+	m := &Match{
+		openings:     []Opening{{fen: StartFEN}},
+		timeControl:  TimeControl{base: 10 * time.Second},
+		adjudication: DefaultAdjudication(),
+	}
+	for _, depth := range []int{1, 2} {
+		white, black := &internalEngine{name: "white", depth: depth}, &internalEngine{name: "black", depth: depth}
+		g, reason, err := m.PlayGame(m.openings[0], white, black)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// neither side is lost or drawn out of the opening, whichever colour it plays
+		if reason == "adjudication" && g.Ply() < 30 {
+			t.Errorf("depth %d: game adjudicated %s after %d half-moves", depth, g.result, g.Ply())
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+const MaxPly = 128
+
+type pickStage int
+
+const (
+	hashStage pickStage = iota
+	generateCapturesStage
+	winningCapturesStage
+	killersStage
+	generateQuietsStage
+	quietsStage
+	losingCapturesStage
+	doneStage
+)
+
+// History remembers which quiet moves caused beta cutoffs, to order quiet moves in later nodes
+type History struct {
+	killers [MaxPly][2]Move
+	scores  [32][64]int // indexed by moving piece and destination square
+}
+
+func (h *History) Killers(ply int) [2]Move {
+	if h == nil || ply >= MaxPly {
+		return [2]Move{}
+	}
+	return h.killers[ply]
+}
+
+func (h *History) Score(p Position, m Move) int {
+	if h == nil {
+		return 0
+	}
+	return h.scores[p.board[m.from]][m.to]
+}
+
+// records a quiet move that caused a beta cutoff
+func (h *History) Update(p Position, m Move, depth, ply int) {
+	if h == nil || m.capture {
+		return
+	}
+	h.scores[p.board[m.from]][m.to] += depth * depth
+	if ply < MaxPly && h.killers[ply][0] != m {
+		h.killers[ply][1] = h.killers[ply][0]
+		h.killers[ply][0] = m
+	}
+}
+
+// carries the history over to a search the given number of plies further into the game:
+// scores fade, and killers move to the plies they now belong to
+func (h *History) Age(plies int) {
+	if h == nil {
+		return
+	}
+	for piece := range h.scores {
+		for to := range h.scores[piece] {
+			h.scores[piece][to] /= 2
+		}
+	}
+	for ply := range h.killers {
+		if plies < 0 || ply+plies >= MaxPly {
+			h.killers[ply] = [2]Move{}
+		} else {
+			h.killers[ply] = h.killers[ply+plies]
+		}
+	}
+}
+
+// MovePicker hands out the pseudo-legal moves of a position in stages, only generating
+// a stage once the previous ones failed to produce a cutoff:
+// hash move, winning captures, killers, quiet moves by history, losing captures
+type MovePicker struct {
+	position       Position
+	stage          pickStage
+	hashMove       Move
+	killers        [2]Move
+	history        *History
+	moves          []Move
+	scores         []int
+	index          int
+	losingCaptures []Move
+}
+
+func NewMovePicker(p Position, hashMove Move, ply int, h *History) *MovePicker {
+	return &MovePicker{
+		position: p,
+		stage:    hashStage,
+		hashMove: hashMove,
+		killers:  h.Killers(ply),
+		history:  h,
+	}
+}
+
+// returns the next move to try, and false once every move was handed out
+func (mp *MovePicker) Next() (Move, bool) {
+	for {
+		switch mp.stage {
+		case hashStage:
+			mp.stage++
+			if mp.position.IsPseudoLegal(mp.hashMove) {
+				return mp.hashMove, true
+			}
+		case generateCapturesStage:
+			mp.moves = mp.position.GenerateMoves(make([]Move, 0, 16), Captures)
+			mp.scores = mp.scores[:0]
+			for _, move := range mp.moves {
+				mp.scores = append(mp.scores, mp.captureScore(move))
+			}
+			mp.index = 0
+			mp.stage++
+		case winningCapturesStage:
+			move, ok := mp.pickBest()
+			if !ok {
+				mp.index = 0
+				mp.stage++
+				continue
+			}
+			if move == mp.hashMove {
+				continue
+			}
+			if !mp.goodCapture(move) {
+				mp.losingCaptures = append(mp.losingCaptures, move)
+				continue
+			}
+			return move, true
+		case killersStage:
+			for mp.index < len(mp.killers) {
+				killer := mp.killers[mp.index]
+				mp.index++
+				if killer != mp.hashMove && !killer.capture && mp.position.IsPseudoLegal(killer) {
+					return killer, true
+				}
+			}
+			mp.stage++
+		case generateQuietsStage:
+			mp.moves = mp.position.GenerateMoves(make([]Move, 0, 40), Quiets)
+			mp.scores = mp.scores[:0]
+			for _, move := range mp.moves {
+				mp.scores = append(mp.scores, mp.quietScore(move))
+			}
+			mp.index = 0
+			mp.stage++
+		case quietsStage:
+			move, ok := mp.pickBest()
+			if !ok {
+				mp.index = 0
+				mp.stage++
+				continue
+			}
+			if move == mp.hashMove || move == mp.killers[0] || move == mp.killers[1] {
+				continue
+			}
+			return move, true
+		case losingCapturesStage:
+			if mp.index < len(mp.losingCaptures) {
+				mp.index++
+				return mp.losingCaptures[mp.index-1], true
+			}
+			mp.stage++
+		case doneStage:
+			return NoMove, false
+		}
+	}
+}
+
+// selects the highest scoring move not yet handed out in the current stage
+func (mp *MovePicker) pickBest() (Move, bool) {
+	if mp.index >= len(mp.moves) {
+		return NoMove, false
+	}
+	best := mp.index
+	for i := mp.index + 1; i < len(mp.moves); i++ {
+		if mp.scores[i] > mp.scores[best] {
+			best = i
+		}
+	}
+	mp.moves[mp.index], mp.moves[best] = mp.moves[best], mp.moves[mp.index]
+	mp.scores[mp.index], mp.scores[best] = mp.scores[best], mp.scores[mp.index]
+	mp.index++
+	return mp.moves[mp.index-1], true
+}
+
+// most valuable victim, least valuable attacker
+func (mp *MovePicker) captureScore(m Move) int {
+	victim := mp.position.board[m.to]
+	if victim == NoPiece {
+		// en passant
+		victim = CreatePiece(mp.position.turn.Flip(), Pawn)
+	}
+	score := victim.Value()*100 - mp.position.board[m.from].Value()
+	if m.promote != NoPieceType {
+		score += CreatePiece(mp.position.turn, m.promote).Value() * 100
+	}
+	return score
+}
+
+func (mp *MovePicker) quietScore(m Move) int {
+	if m.promote == Queen {
+		return checkmateValue
+	}
+	return mp.history.Score(mp.position, m)
+}
+
+// a capture is considered winning if it does not lose material right away
+func (mp *MovePicker) goodCapture(m Move) bool {
+	victim := mp.position.board[m.to]
+	if victim == NoPiece || m.promote != NoPieceType {
+		return true
+	}
+	if victim.Value() >= mp.position.board[m.from].Value() {
+		return true
+	}
+	return !mp.position.Attacked(m.to, mp.position.turn.Flip())
+}
@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestMovePicker(t *testing.T) {
+	fens := []string{
+		"startpos",
+		"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+		"rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8",
+	}
+	for _, fen := range fens {
+		pos := LoadInitialPosition(fen)
+		all := pos.GenerateMoves(nil, AllMoves)
+		quiets := pos.GenerateMoves(nil, Quiets)
+		h := new(History)
+		h.Update(pos, quiets[len(quiets)-1], 3, 0)
+		hashMove := all[len(all)/2]
+
+		seen := map[Move]int{}
+		mp := NewMovePicker(pos, hashMove, 0, h)
+		first := true
+		for move, ok := mp.Next(); ok; move, ok = mp.Next() {
+			if first && move != hashMove {
+				t.Errorf("%s: first move %v, want hash move %v", fen, move, hashMove)
+			}
+			first = false
+			seen[move]++
+		}
+		if len(seen) != len(all) {
+			t.Errorf("%s: picked %d distinct moves, want %d", fen, len(seen), len(all))
+		}
+		for _, move := range all {
+			if seen[move] != 1 {
+				t.Errorf("%s: picked %v %d times, want once", fen, move, seen[move])
+			}
+		}
+	}
+}
+
+func TestIsPseudoLegal(t *testing.T) {
+	pos := LoadInitialPosition("startpos")
+	for _, move := range pos.GenerateMoves(nil, AllMoves) {
+		if !pos.IsPseudoLegal(move) {
+			t.Errorf("IsPseudoLegal(%v) = false, want true", move)
+		}
+	}
+	for _, moveString := range []string{"e2e5", "e7e5", "a1a3", "g1e2"} {
+		move := pos.StringToMove(moveString)
+		if pos.IsPseudoLegal(move) {
+			t.Errorf("IsPseudoLegal(%v) = true, want false", move)
+		}
+	}
+	if pos.IsPseudoLegal(NoMove) {
+		t.Errorf("IsPseudoLegal(NoMove) = true, want false")
+	}
+}
+
+func TestHistoryAge(t *testing.T) {
+	pos := LoadInitialPosition(StartFEN)
+	m := pos.StringToMove("g1f3")
+	h := new(History)
+	h.Update(pos, m, 4, 3)
+	h.Age(2)
+	if score := h.Score(pos, m); score != 8 {
+		t.Errorf("Score() after aging = %d; want 8", score)
+	}
+	if h.Killers(1)[0] != m || h.Killers(3)[0] != NoMove {
+		t.Error("killers did not move two plies closer to the root")
+	}
+	h.Age(-1)
+	if h.Killers(1)[0] != NoMove {
+		t.Error("killers survived a takeback")
+	}
+}
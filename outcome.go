@@ -0,0 +1,107 @@
+package main
+
+// OutcomePolicy decides when the bot gives up, agrees to a draw or takes back moves
+type OutcomePolicy struct {
+	acceptDrawScore int  // centipawns from the bot's point of view; worse than this, draw offers are accepted
+	offerDrawScore  int  // centipawns either way within which an endgame counts as dead equal
+	offerDrawMoves  int  // consecutive dead-equal moves of the bot before it offers a draw, 0 to never offer
+	offerDrawAfter  int  // full moves before the bot offers draws
+	offerDrawPieces int  // most pieces, kings and pawns included, on the board of an endgame
+	resignScore     int  // centipawns; a mate score always counts
+	resignMoves     int  // consecutive moves of the bot beyond resignScore before it resigns, 0 to never resign
+	takebacks       bool // whether to grant the opponent's takeback requests
+}
+
+func DefaultOutcomePolicy() OutcomePolicy {
+	return OutcomePolicy{
+		acceptDrawScore: -100,
+		offerDrawScore:  20,
+		offerDrawMoves:  5,
+		offerDrawAfter:  40,
+		offerDrawPieces: 10,
+		resignScore:     1000,
+		resignMoves:     5,
+	}
+}
+
+// the search scores of the last n half-moves the colour played or is about to play, newest
+// first, in centipawns from its point of view without the pawn term's bias towards white; ok
+// is false unless all of them were searched
+func recentScores(game *Game, c PieceColour, n int) (scores []int, ok bool) {
+	positions := game.Positions()
+	ply := game.Ply()
+	if ply >= len(game.annotations) {
+		ply = len(game.annotations) - 1
+	}
+	// the colour to move at the current ply plays the even plies back from it
+	if ((game.Ply()-ply)%2 == 0) != (game.moveTree.position.turn == c) {
+		ply--
+	}
+	for ; ply >= 0 && len(scores) < n; ply -= 2 {
+		a := game.annotations[ply]
+		if !a.hasEval {
+			return scores, false
+		}
+		scores = append(scores, evalToCentipawns(unbiased(a.eval, positions[ply]))*colourMultiplier[c])
+	}
+	return scores, len(scores) == n
+}
+
+// whether the game has gone on long enough, with few enough pieces left, to offer a draw
+func (op OutcomePolicy) longEndgame(game *Game) bool {
+	pieces := 0
+	for _, square := range Squares {
+		if game.moveTree.position.board[square] != NoPiece {
+			pieces++
+		}
+	}
+	return game.Ply()/2 >= op.offerDrawAfter && pieces <= op.offerDrawPieces
+}
+
+func (op OutcomePolicy) deadEqual(score int) bool {
+	return score >= -op.offerDrawScore && score <= op.offerDrawScore
+}
+
+// whether the colour should resign, having been lost for its last few moves
+func (op OutcomePolicy) Resign(game *Game, c PieceColour) bool {
+	if op.resignMoves <= 0 {
+		return false
+	}
+	scores, ok := recentScores(game, c, op.resignMoves)
+	if !ok {
+		return false
+	}
+	for _, score := range scores {
+		if score > -op.resignScore && score > -mateCentipawns/2 {
+			return false
+		}
+	}
+	return true
+}
+
+// whether the colour should offer a draw after its move, once every offerDrawMoves moves
+// that a long endgame stays dead equal
+func (op OutcomePolicy) OfferDraw(game *Game, c PieceColour) bool {
+	if op.offerDrawMoves <= 0 || !op.longEndgame(game) {
+		return false
+	}
+	scores, _ := recentScores(game, c, MaxPly)
+	equal := 0
+	for equal < len(scores) && op.deadEqual(scores[equal]) {
+		equal++
+	}
+	return equal > 0 && equal%op.offerDrawMoves == 0
+}
+
+// whether the colour should accept its opponent's draw offer: when the game could be claimed
+// drawn, the colour's last search found it worse off, or a long endgame is dead equal
+func (op OutcomePolicy) AcceptDraw(game *Game, c PieceColour) bool {
+	if game.DrawReason() != "" {
+		return true
+	}
+	scores, ok := recentScores(game, c, 1)
+	if !ok {
+		return false
+	}
+	return scores[0] < op.acceptDrawScore || op.longEndgame(game) && op.deadEqual(scores[0])
+}
@@ -0,0 +1,128 @@
+package main
+
+import "testing"
+
+// a game with search evaluations, from white's point of view and without the pawn term's
+// bias, of some of its half-moves
+func annotatedGame(fen, moves string, evals map[int]int) *Game {
+	g := NewGame(fen, nil, nil)
+	g.AddMoves(moves)
+	positions := g.Positions()
+	for ply, eval := range evals {
+		if _, mate := MateIn(eval); !mate {
+			eval += pawnBias(positions[ply])
+		}
+		a := g.Annotation(ply)
+		a.eval, a.hasEval = eval, true
+	}
+	return g
+}
+
+const (
+	italian      = "e2e4 e7e5 g1f3 b8c6 f1c4"
+	rookEndgame  = "4k3/r7/8/8/8/8/R7/4K3 w - - 0 1"
+	rookShuffles = "a2b2 a7b7 b2c2 b7c7"
+)
+
+func TestResign(t *testing.T) {
+	op := DefaultOutcomePolicy()
+	op.resignMoves = 3
+	tests := []struct {
+		evals  map[int]int
+		colour PieceColour
+		resign bool
+	}{
+		{map[int]int{1: 150, 3: 150, 5: 150}, Black, true},
+		{map[int]int{1: 150, 3: 150, 5: 150}, White, false},
+		// a book move breaks the run
+		{map[int]int{3: 150, 5: 150}, Black, false},
+		{map[int]int{1: 150, 3: 50, 5: 150}, Black, false},
+		{map[int]int{1: 150, 3: 150, 5: checkmateValue}, Black, true},
+	}
+	for _, test := range tests {
+		g := annotatedGame(StartFEN, italian, test.evals)
+		if resign := op.Resign(g, test.colour); resign != test.resign {
+			t.Errorf("Resign() for %v with evals %v = %t; want %t", test.colour, test.evals, resign, test.resign)
+		}
+	}
+	op.resignMoves = 0
+	if op.Resign(annotatedGame(StartFEN, italian, tests[0].evals), Black) {
+		t.Error("Resign() = true with resignation disabled")
+	}
+}
+
+func TestOfferDraw(t *testing.T) {
+	op := DefaultOutcomePolicy()
+	op.offerDrawMoves = 2
+	op.offerDrawAfter = 0
+	tests := []struct {
+		fen, moves string
+		evals      map[int]int
+		offer      bool
+	}{
+		{rookEndgame, rookShuffles, map[int]int{2: 1, 4: 0}, true},
+		// offered one move ago
+		{rookEndgame, rookShuffles, map[int]int{0: 0, 2: 1, 4: 0}, false},
+		{rookEndgame, rookShuffles, map[int]int{0: 0, 2: 10, 4: 0}, false},
+		{rookEndgame, rookShuffles, map[int]int{2: 0, 4: -5}, false},
+		// not an endgame
+		{StartFEN, italian + " g8f6", map[int]int{2: 0, 4: 0}, false},
+	}
+	for _, test := range tests {
+		g := annotatedGame(test.fen, test.moves, test.evals)
+		if offer := op.OfferDraw(g, White); offer != test.offer {
+			t.Errorf("OfferDraw() after %q with evals %v = %t; want %t", test.moves, test.evals, offer, test.offer)
+		}
+	}
+	op.offerDrawAfter = 40
+	if op.OfferDraw(annotatedGame(rookEndgame, rookShuffles, tests[0].evals), White) {
+		t.Error("OfferDraw() = true before the game was long enough")
+	}
+}
+
+func TestAcceptDraw(t *testing.T) {
+	op := DefaultOutcomePolicy()
+	op.offerDrawAfter = 0
+	tests := []struct {
+		fen, moves string
+		evals      map[int]int
+		colour     PieceColour
+		accept     bool
+	}{
+		{StartFEN, italian, map[int]int{5: 20}, Black, true},
+		{StartFEN, italian, map[int]int{5: 20}, White, false},
+		{StartFEN, italian, map[int]int{5: 0}, Black, false},
+		{rookEndgame, rookShuffles, map[int]int{4: 0}, White, true},
+		{rookEndgame, rookShuffles, map[int]int{4: 30}, White, false},
+		{"4k3/8/8/8/8/8/8/4K3 w - - 0 1", "", nil, White, true},
+	}
+	for _, test := range tests {
+		g := annotatedGame(test.fen, test.moves, test.evals)
+		if accept := op.AcceptDraw(g, test.colour); accept != test.accept {
+			t.Errorf("AcceptDraw() for %v after %q with evals %v = %t; want %t", test.colour, test.moves, test.evals, accept, test.accept)
+		}
+	}
+}
+
+func TestOutcomeColourNeutral(t *testing.T) {
+	// every position scored as Eval scores it, with white ahead by its pawn term alone
+	g := NewGame(StartFEN, nil, nil)
+	g.AddMoves(italian)
+	for ply, pos := range g.Positions() {
+		a := g.Annotation(ply)
+		a.eval, a.hasEval = Eval(pos), true
+	}
+	op := DefaultOutcomePolicy()
+	op.resignMoves = 3
+	for _, c := range []PieceColour{White, Black} {
+		if op.Resign(g, c) {
+			t.Errorf("Resign() for %v = true in an equal opening", c)
+		}
+		if op.AcceptDraw(g, c) {
+			t.Errorf("AcceptDraw() for %v = true in an equal opening", c)
+		}
+	}
+	if Eval(LoadInitialPosition(StartFEN)) == 0 {
+		t.Error("Eval() of the start position = 0; the test needs the pawn term's bias")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// perft counts the leaf nodes of the legal move tree, to check the move generator
+// against reference engines
+func StartPerft(args []string) {
+	fs := commandFlags("perft")
+	fen := fs.String("fen", "startpos", "position to count from")
+	depth := fs.Int("depth", 4, "depth to count to (maximum depth in suite mode)")
+	suite := fs.String("suite", "", "EPD file of positions with ;D<depth> <nodes> counts")
+	chess960 := fs.Bool("chess960", false, "write castling as king takes rook, as Chess960 engines do")
+	fs.Parse(args)
+
+	if *suite != "" {
+		if !PerftSuite(*suite, *depth) {
+			os.Exit(1)
+		}
+		return
+	}
+	Perft(LoadInitialPosition(*fen), *depth, *chess960)
+}
+
+// prints the divide counts of every root move in UCI notation, to compare with other
+// engines' line by line, then the totals
+func Perft(pos Position, depth int, chess960 bool) int {
+	start := time.Now()
+	tree := MoveTree{position: pos}
+	divide := tree.Divide(depth)
+	elapsed := time.Since(start)
+
+	moves := make([]Move, 0, len(divide))
+	for move := range divide {
+		moves = append(moves, move)
+	}
+	sort.Slice(moves, func(i, j int) bool {
+		return moves[i].UCI(chess960) < moves[j].UCI(chess960)
+	})
+	nodes := 0
+	for _, move := range moves {
+		fmt.Printf("%s: %d\n", move.UCI(chess960), divide[move])
+		nodes += divide[move]
+	}
+	fmt.Println()
+	fmt.Println("Moves:", len(moves))
+	fmt.Println("Nodes:", nodes)
+	fmt.Println("Time:", elapsed)
+	fmt.Println("NPS:", nps(nodes, elapsed))
+	return nodes
+}
+
+// checks the counts of every position in an EPD perft suite up to maxDepth,
+// returning whether they all matched
+func PerftSuite(filename string, maxDepth int) bool {
+	f, err := os.Open(filename)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	defer f.Close()
+	epds, err := ReadEPDs(f)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	start := time.Now()
+	nodes, checked, mismatches := 0, 0, 0
+	for i, e := range epds {
+		for depth := 1; depth <= maxDepth; depth++ {
+			expected, ok := e.operations["D"+strconv.Itoa(depth)]
+			if !ok {
+				continue
+			}
+			want, err := strconv.Atoi(strings.TrimSpace(expected))
+			if err != nil {
+				fmt.Printf("#%d: bad count %q for depth %d\n", i+1, expected, depth)
+				mismatches++
+				continue
+			}
+			tree := MoveTree{position: LoadInitialPosition(e.fen)}
+			got := tree.FindAllMoves(depth)
+			nodes += got
+			checked++
+			if got != want {
+				mismatches++
+				fmt.Printf("#%d: MISMATCH %s depth %d: got %d, want %d\n", i+1, e.fen, depth, got, want)
+			}
+		}
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("%d positions, %d counts checked, %d mismatches\n", len(epds), checked, mismatches)
+	fmt.Println("Nodes:", nodes)
+	fmt.Println("Time:", elapsed)
+	fmt.Println("NPS:", nps(nodes, elapsed))
+	return mismatches == 0
+}
+
+func nps(nodes int, elapsed time.Duration) int {
+	if elapsed <= 0 {
+		return 0
+	}
+	return int(float64(nodes) / elapsed.Seconds())
+}
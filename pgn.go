@@ -0,0 +1,529 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const pgnLineLength = 79
+
+var variantNames = map[string]string{
+	"standard":     "Standard",
+	"chess960":     "Chess960",
+	"fromPosition": "From Position",
+}
+
+var suffixNags = map[string]int{"!": 1, "?": 2, "!!": 3, "??": 4, "!?": 5, "?!": 6}
+
+// writes the game with the Seven Tag Roster, the Lichess tags we know about, and SAN
+// moves with their comments, evaluations, clock times and variations
+func (g *Game) WritePGN(w io.Writer) error {
+	var b strings.Builder
+	for _, tag := range g.pgnTags() {
+		value := strings.ReplaceAll(tag[1], `\`, `\\`)
+		value = strings.ReplaceAll(value, `"`, `\"`)
+		fmt.Fprintf(&b, "[%s \"%s\"]\n", tag[0], value)
+	}
+	b.WriteString("\n")
+
+	var tokens []string
+	if g.comment != "" {
+		tokens = append(tokens, pgnComment(g.comment))
+	}
+	fields := strings.Fields(g.initialFen)
+	moveNumber := 1
+	if len(fields) > 5 {
+		if n, err := strconv.Atoi(fields[5]); err == nil && n > 0 {
+			moveNumber = n
+		}
+	}
+	tokens = appendPGNLine(tokens, LoadInitialPosition(g.initialFen), g.mainLine(), moveNumber)
+	tokens = append(tokens, g.result)
+
+	line := ""
+	for _, token := range strings.Fields(strings.Join(tokens, " ")) {
+		if line != "" && len(line)+1+len(token) > pgnLineLength {
+			b.WriteString(line + "\n")
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += token
+	}
+	b.WriteString(line + "\n\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (g *Game) pgnTags() [][2]string {
+	tags := g.tags
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	lichess := g.id != ""
+	// known tags first, unknown ones keep whatever the game was loaded with
+	value := func(name, computed, fallback string) string {
+		if v, ok := tags[name]; ok {
+			return v
+		}
+		if computed != "" {
+			return computed
+		}
+		return fallback
+	}
+	event, site, round, date := "", "", "", ""
+	if lichess {
+		rated := "Casual"
+		if g.rated {
+			rated = "Rated"
+		}
+		event = strings.TrimSpace(fmt.Sprintf("%s %s game", rated, strings.Title(g.speed)))
+		site = "https://lichess.org/" + g.id
+		round = "-"
+	}
+	if !g.started.IsZero() {
+		date = g.started.Format("2006.01.02")
+	}
+	result := []([2]string){
+		{"Event", value("Event", event, "?")},
+		{"Site", value("Site", site, "?")},
+		{"Date", value("Date", date, "????.??.??")},
+		{"Round", value("Round", round, "?")},
+		{"White", value("White", g.players[White].name, "?")},
+		{"Black", value("Black", g.players[Black].name, "?")},
+		{"Result", g.result},
+	}
+	known := map[string]bool{}
+	for _, tag := range result {
+		known[tag[0]] = true
+	}
+	add := func(name, v string) {
+		known[name] = true
+		if v != "" {
+			result = append(result, [2]string{name, v})
+		}
+	}
+	for _, c := range []PieceColour{White, Black} {
+		prefix := strings.Title(c.String())
+		if rating := g.players[c].rating; rating > 0 {
+			add(prefix+"Elo", strconv.Itoa(rating))
+		}
+		add(prefix+"Title", g.players[c].title)
+	}
+	variant := variantNames[g.variant]
+	if variant == "" {
+		variant = strings.Title(g.variant)
+	}
+	add("Variant", variant)
+	if g.clock != nil {
+		add("TimeControl", fmt.Sprintf("%d+%d", g.clock.Initial/1000, g.clock.Increment/1000))
+	} else if lichess {
+		add("TimeControl", "-")
+	}
+	if g.initialFen != "startpos" && g.initialFen != StartFEN {
+		add("SetUp", "1")
+		add("FEN", g.initialFen)
+	}
+	var rest []string
+	for name := range tags {
+		if !known[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	for _, name := range rest {
+		result = append(result, [2]string{name, tags[name]})
+	}
+	return result
+}
+
+// the played moves together with their annotations
+func (g *Game) mainLine() Variation {
+	pos := LoadInitialPosition(g.initialFen)
+	var line Variation
+	for i, moveString := range strings.Fields(g.moves) {
+		m := AnnotatedMove{move: pos.StringToMove(moveString)}
+		if i < len(g.annotations) {
+			m.Annotation = g.annotations[i]
+		}
+		line = append(line, m)
+		pos = pos.ProcessMove(m.move)
+	}
+	return line
+}
+
+func appendPGNLine(tokens []string, pos Position, line Variation, moveNumber int) []string {
+	needNumber := true
+	for _, m := range line {
+		if pos.turn == White {
+			tokens = append(tokens, fmt.Sprintf("%d.", moveNumber))
+		} else if needNumber {
+			tokens = append(tokens, fmt.Sprintf("%d...", moveNumber))
+		}
+		needNumber = false
+		tokens = append(tokens, pos.MoveToSAN(m.move))
+		for _, nag := range m.nags {
+			tokens = append(tokens, fmt.Sprintf("$%d", nag))
+		}
+		if comment := m.commentText(); comment != "" {
+			tokens = append(tokens, pgnComment(comment))
+			needNumber = true
+		}
+		for _, variation := range m.variations {
+			tokens = append(tokens, "(")
+			tokens = appendPGNLine(tokens, pos, variation, moveNumber)
+			tokens = append(tokens, ")")
+			needNumber = true
+		}
+		pos = pos.ProcessMove(m.move)
+		if pos.turn == White {
+			moveNumber++
+		}
+	}
+	return tokens
+}
+
+// comment text including the Lichess style [%eval] and [%clk] commands
+func (a Annotation) commentText() string {
+	var parts []string
+	if a.hasEval {
+		parts = append(parts, "[%eval "+FormatEval(a.eval)+"]")
+	}
+	if a.clock > 0 {
+		seconds := int(a.clock.Round(time.Second).Seconds())
+		parts = append(parts, fmt.Sprintf("[%%clk %d:%02d:%02d]", seconds/3600, seconds/60%60, seconds%60))
+	}
+	if a.comment != "" {
+		parts = append(parts, a.comment)
+	}
+	return strings.Join(parts, " ")
+}
+
+func pgnComment(comment string) string {
+	comment = strings.ReplaceAll(comment, "}", "")
+	return "{ " + strings.Join(strings.Fields(comment), " ") + " }"
+}
+
+type pgnTokenKind int
+
+const (
+	tagToken pgnTokenKind = iota
+	symbolToken
+	commentToken
+	nagToken
+	openToken
+	closeToken
+	resultToken
+)
+
+type pgnToken struct {
+	kind  pgnTokenKind
+	name  string // tag name
+	value string
+}
+
+var pgnResults = map[string]bool{"1-0": true, "0-1": true, "1/2-1/2": true, "*": true}
+
+func tokenizePGN(src string) ([]pgnToken, error) {
+	var tokens []pgnToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '%' && (i == 0 || src[i-1] == '\n'):
+			// escaped line
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '[':
+			end := i + 1
+			inString := false
+			for ; end < len(src) && (inString || src[end] != ']'); end++ {
+				if src[end] == '\\' && inString {
+					end++
+				} else if src[end] == '"' {
+					inString = !inString
+				}
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("unterminated tag at offset %d", i)
+			}
+			fields := strings.SplitN(strings.TrimSpace(src[i+1:end]), " ", 2)
+			value := ""
+			if len(fields) == 2 {
+				value = strings.TrimSpace(fields[1])
+				value = strings.TrimSuffix(strings.TrimPrefix(value, `"`), `"`)
+				value = strings.ReplaceAll(value, `\"`, `"`)
+				value = strings.ReplaceAll(value, `\\`, `\`)
+			}
+			tokens = append(tokens, pgnToken{kind: tagToken, name: fields[0], value: value})
+			i = end + 1
+		case c == '{':
+			end := strings.IndexByte(src[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated comment at offset %d", i)
+			}
+			tokens = append(tokens, pgnToken{kind: commentToken, value: src[i+1 : i+end]})
+			i += end + 1
+		case c == ';':
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				end = len(src) - i
+			}
+			tokens = append(tokens, pgnToken{kind: commentToken, value: src[i+1 : i+end]})
+			i += end
+		case c == '(':
+			tokens = append(tokens, pgnToken{kind: openToken})
+			i++
+		case c == ')':
+			tokens = append(tokens, pgnToken{kind: closeToken})
+			i++
+		case c == '$':
+			end := i + 1
+			for end < len(src) && src[end] >= '0' && src[end] <= '9' {
+				end++
+			}
+			tokens = append(tokens, pgnToken{kind: nagToken, value: src[i+1 : end]})
+			i = end
+		default:
+			end := i
+			for end < len(src) && !strings.ContainsRune(" \t\r\n{}()[];$", rune(src[end])) {
+				end++
+			}
+			symbol := src[i:end]
+			i = end
+			if pgnResults[symbol] {
+				tokens = append(tokens, pgnToken{kind: resultToken, value: symbol})
+				continue
+			}
+			// move numbers, possibly stuck to the move as in "12.e4", but not castling written
+			// with zeros
+			if symbol[0] >= '0' && symbol[0] <= '9' && !strings.HasPrefix(symbol, "0-0") {
+				symbol = strings.TrimLeft(symbol, "0123456789")
+				symbol = strings.TrimLeft(symbol, ".")
+				if symbol == "" {
+					continue
+				}
+			}
+			// suffix annotations become NAGs
+			move := strings.TrimRight(symbol, "!?")
+			tokens = append(tokens, pgnToken{kind: symbolToken, value: move})
+			if nag, ok := suffixNags[symbol[len(move):]]; ok {
+				tokens = append(tokens, pgnToken{kind: nagToken, value: strconv.Itoa(nag)})
+			}
+		}
+	}
+	return tokens, nil
+}
+
+type pgnParser struct {
+	tokens []pgnToken
+	i      int
+}
+
+// reads every game of a PGN file, including comments, NAGs and variations
+func ReadPGN(r io.Reader) ([]*Game, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := tokenizePGN(string(src))
+	if err != nil {
+		return nil, err
+	}
+	p := &pgnParser{tokens: tokens}
+	var games []*Game
+	for p.i < len(p.tokens) {
+		g, err := p.parseGame()
+		if err != nil {
+			return games, fmt.Errorf("game %d: %w", len(games)+1, err)
+		}
+		games = append(games, g)
+	}
+	return games, nil
+}
+
+func (p *pgnParser) parseGame() (*Game, error) {
+	tags := map[string]string{}
+	for p.i < len(p.tokens) && p.tokens[p.i].kind == tagToken {
+		tags[p.tokens[p.i].name] = p.tokens[p.i].value
+		p.i++
+	}
+	g := gameFromTags(tags)
+	line, comment, err := p.parseLine(LoadInitialPosition(g.initialFen))
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(p.tokens) {
+		switch p.tokens[p.i].kind {
+		case resultToken:
+			g.result = p.tokens[p.i].value
+			p.i++
+		case closeToken:
+			return nil, fmt.Errorf("unexpected )")
+		}
+	}
+	g.comment = comment
+	var moves []string
+	for _, m := range line {
+		moves = append(moves, m.move.String())
+		g.annotations = append(g.annotations, m.Annotation)
+	}
+	g.AddMoves(strings.Join(moves, " "))
+	return g, nil
+}
+
+// parses moves until the end of a variation, game or file, also returning any comment
+// found before the first move
+func (p *pgnParser) parseLine(pos Position) (Variation, string, error) {
+	var line Variation
+	var before Position
+	comment := ""
+	for p.i < len(p.tokens) {
+		token := p.tokens[p.i]
+		switch token.kind {
+		case tagToken, resultToken, closeToken:
+			return line, comment, nil
+		case symbolToken:
+			move, err := pos.ParseSAN(token.value)
+			if err != nil {
+				return nil, "", err
+			}
+			line = append(line, AnnotatedMove{move: move})
+			before = pos
+			pos = pos.ProcessMove(move)
+		case nagToken:
+			nag, err := strconv.Atoi(token.value)
+			if err != nil || len(line) == 0 {
+				return nil, "", fmt.Errorf("unexpected NAG $%s", token.value)
+			}
+			line[len(line)-1].nags = append(line[len(line)-1].nags, nag)
+		case commentToken:
+			if len(line) == 0 {
+				comment = strings.TrimSpace(comment + " " + token.value)
+			} else {
+				line[len(line)-1].addComment(token.value)
+			}
+		case openToken:
+			if len(line) == 0 {
+				return nil, "", fmt.Errorf("variation before any move")
+			}
+			p.i++
+			variation, variationComment, err := p.parseLine(before)
+			if err != nil {
+				return nil, "", err
+			}
+			if p.i >= len(p.tokens) || p.tokens[p.i].kind != closeToken {
+				return nil, "", fmt.Errorf("unterminated variation")
+			}
+			if variationComment != "" && len(variation) > 0 {
+				variation[0].comment = strings.TrimSpace(variationComment + " " + variation[0].comment)
+			}
+			line[len(line)-1].variations = append(line[len(line)-1].variations, variation)
+		}
+		p.i++
+	}
+	return line, comment, nil
+}
+
+var (
+	evalCommand  = regexp.MustCompile(`\[%eval\s+([^\]\s,]+)[^\]]*\]`)
+	clockCommand = regexp.MustCompile(`\[%clk\s+(\d+):(\d+):(\d+(?:\.\d+)?)\]`)
+)
+
+// reads the [%eval] and [%clk] commands out of a comment, keeping the rest as text
+func (a *Annotation) addComment(comment string) {
+	if match := evalCommand.FindStringSubmatch(comment); match != nil {
+		if eval, err := ParseEval(match[1]); err == nil {
+			a.eval = eval
+			a.hasEval = true
+		}
+		comment = strings.Replace(comment, match[0], "", 1)
+	}
+	if match := clockCommand.FindStringSubmatch(comment); match != nil {
+		hours, _ := strconv.Atoi(match[1])
+		minutes, _ := strconv.Atoi(match[2])
+		seconds, _ := strconv.ParseFloat(match[3], 64)
+		a.clock = time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute +
+			time.Duration(seconds*float64(time.Second))
+		comment = strings.Replace(comment, match[0], "", 1)
+	}
+	comment = strings.Join(strings.Fields(comment), " ")
+	if comment != "" {
+		a.comment = strings.TrimSpace(a.comment + " " + comment)
+	}
+}
+
+// the inverse of FormatEval
+func ParseEval(s string) (int, error) {
+	if strings.HasPrefix(s, "#") {
+		moves, err := strconv.Atoi(s[1:])
+		if err != nil || moves == 0 {
+			return 0, fmt.Errorf("invalid mate score %q", s)
+		}
+		sign := 1
+		if moves < 0 {
+			sign, moves = -1, -moves
+		}
+		eval := checkmateValue
+		for plies := 0; plies < 2*moves-1; plies++ {
+			eval = eval * 99 / 100
+		}
+		return sign * eval, nil
+	}
+	pawns, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int(math.Round(pawns * 10)), nil
+}
+
+func gameFromTags(tags map[string]string) *Game {
+	fen := "startpos"
+	if tags["FEN"] != "" {
+		fen = tags["FEN"]
+	}
+	players := map[PieceColour]Player{}
+	for _, c := range []PieceColour{White, Black} {
+		prefix := strings.Title(c.String())
+		rating, _ := strconv.Atoi(tags[prefix+"Elo"])
+		players[c] = Player{
+			id:     strings.ToLower(tags[prefix]),
+			name:   tags[prefix],
+			rating: rating,
+			title:  tags[prefix+"Title"],
+		}
+		delete(tags, prefix)
+		delete(tags, prefix+"Elo")
+		delete(tags, prefix+"Title")
+	}
+	g := NewGame(fen, players, map[PieceColour]uint{})
+	for key, name := range variantNames {
+		if strings.EqualFold(tags["Variant"], name) {
+			g.variant = key
+		}
+	}
+	var initial, increment uint
+	if _, err := fmt.Sscanf(tags["TimeControl"], "%d+%d", &initial, &increment); err == nil {
+		g.clock = &LichessClock{Initial: initial * 1000, Increment: increment * 1000}
+	}
+	if started, err := time.Parse("2006.01.02", tags["Date"]); err == nil {
+		g.started = started
+	}
+	if tags["Result"] != "" {
+		g.result = tags["Result"]
+	}
+	for _, name := range []string{"Variant", "TimeControl", "Date", "Result", "FEN", "SetUp"} {
+		delete(tags, name)
+	}
+	g.tags = tags
+	return g
+}
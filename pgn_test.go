@@ -0,0 +1,149 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePGN(t *testing.T) {
+	g := NewGame("startpos", map[PieceColour]Player{
+		White: {id: "stupid-horse", name: "stupid-horse", rating: 1500, title: "BOT", me: true},
+		Black: {id: "someone", name: "Someone", rating: 1620},
+	}, map[PieceColour]uint{})
+	g.id = "7uwbkBlX"
+	g.rated = true
+	g.speed = "blitz"
+	g.clock = &LichessClock{Initial: 180000, Increment: 2000}
+	g.started = time.Date(2021, 3, 4, 12, 0, 0, 0, time.UTC)
+	g.AddMoves("e2e4 e7e5 g1f3 b8c6 f1b5")
+	g.Annotation(0).eval, g.Annotation(0).hasEval = 3, true
+	g.Annotation(0).clock = 3 * time.Minute
+	g.Annotation(2).nags = []int{1}
+	g.Annotation(3).comment = "the main line"
+	g.Finish("resign", "white")
+
+	var b strings.Builder
+	if err := g.WritePGN(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `[Event "Rated Blitz game"]
+[Site "https://lichess.org/7uwbkBlX"]
+[Date "2021.03.04"]
+[Round "-"]
+[White "stupid-horse"]
+[Black "Someone"]
+[Result "1-0"]
+[WhiteElo "1500"]
+[WhiteTitle "BOT"]
+[BlackElo "1620"]
+[Variant "Standard"]
+[TimeControl "180+2"]
+
+1. e4 { [%eval 0.30] [%clk 0:03:00] } 1... e5 2. Nf3 $1 Nc6 { the main line }
+3. Bb5 1-0
+
+`
+	if b.String() != want {
+		t.Errorf("WritePGN() =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestReadPGN(t *testing.T) {
+	pgn := `[Event "Casual game"]
+[Site "?"]
+[White "A"]
+[Black "B"]
+[Result "0-1"]
+[Variant "Chess960"]
+[SetUp "1"]
+[FEN "bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9"]
+
+{ a test game } 9. Nc3 { [%eval -0.4] [%clk 0:01:02] developing } 9... Nd5!?
+(9... Ng4 10. e4 (10. Nb5 $2) 10... a5 ; side line
+) 10. Nxd5 exd5 0-1
+
+1. f3 e5 2. g4?? Qh4# 0-1
+`
+	games, err := ReadPGN(strings.NewReader(pgn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(games) != 2 {
+		t.Fatalf("ReadPGN() returned %d games; want 2", len(games))
+	}
+	g := games[0]
+	if g.variant != "chess960" || g.result != "0-1" || g.players[White].name != "A" || g.comment != "a test game" {
+		t.Errorf("ReadPGN() game details = %v %v %v %q", g.variant, g.result, g.players[White].name, g.comment)
+	}
+	if g.moves != "a2c3 f6d5 c3d5 e6d5" {
+		t.Errorf("ReadPGN() moves = %q", g.moves)
+	}
+	a := g.annotations[0]
+	if !a.hasEval || a.eval != -4 || a.clock != 62*time.Second || a.comment != "developing" {
+		t.Errorf("ReadPGN() first annotation = %+v", a)
+	}
+	if len(g.annotations[1].nags) != 1 || g.annotations[1].nags[0] != 5 {
+		t.Errorf("ReadPGN() second move NAGs = %v; want [5]", g.annotations[1].nags)
+	}
+	variations := g.annotations[1].variations
+	if len(variations) != 1 || len(variations[0]) != 3 || variations[0][0].move.from != StringToSquare("f6") {
+		t.Fatalf("ReadPGN() variations = %+v", variations)
+	}
+	if len(variations[0][1].variations) != 1 || variations[0][2].comment != "side line" {
+		t.Errorf("ReadPGN() nested variation = %+v", variations[0])
+	}
+	if games[1].moveTree.state != BlackWon || games[1].annotations[2].nags[0] != 4 {
+		t.Errorf("ReadPGN() second game state = %v", games[1].moveTree.state)
+	}
+
+	// writing and reading back gives the same PGN
+	var first, second strings.Builder
+	for _, g := range games {
+		g.WritePGN(&first)
+	}
+	again, err := ReadPGN(strings.NewReader(first.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range again {
+		g.WritePGN(&second)
+	}
+	if first.String() != second.String() {
+		t.Errorf("PGN round trip changed\n%s\ninto\n%s", first.String(), second.String())
+	}
+}
+
+func TestReadPGNCastling(t *testing.T) {
+	tests := []struct {
+		pgn, moves string
+	}{
+		{"1. e4 e5 2. Nf3 Nc6 3. Bc4 Bc5 4. 0-0 Nf6 *", "e2e4 e7e5 g1f3 b8c6 f1c4 f8c5 e1h1 g8f6"},
+		{"1. e4 e5 2. Nf3 Nc6 3. Bc4 Bc5 4.0-0 Nf6 *", "e2e4 e7e5 g1f3 b8c6 f1c4 f8c5 e1h1 g8f6"},
+		{"1. d4 d5 2. Nc3 Nc6 3. Bf4 Bf5 4. Qd2 Qd7 5. 0-0-0 0-0-0 *", "d2d4 d7d5 b1c3 b8c6 c1f4 c8f5 d1d2 d8d7 e1a1 e8a8"},
+		{"1. d4 d5 2. Nc3 Nc6 3. Bf4 Bf5 4. Qd2 Qd7 5. O-O-O O-O-O!? *", "d2d4 d7d5 b1c3 b8c6 c1f4 c8f5 d1d2 d8d7 e1a1 e8a8"},
+	}
+	for _, test := range tests {
+		games, err := ReadPGN(strings.NewReader(test.pgn))
+		if err != nil {
+			t.Errorf("ReadPGN(%q) error: %v", test.pgn, err)
+			continue
+		}
+		if len(games) != 1 || games[0].moves != test.moves {
+			t.Errorf("ReadPGN(%q) moves = %q; want %q", test.pgn, games[0].moves, test.moves)
+		}
+	}
+}
+
+func TestParseEval(t *testing.T) {
+	for _, s := range []string{"0.00", "1.30", "-2.50", "#1", "#-1", "#4", "#-7"} {
+		eval, err := ParseEval(s)
+		if err != nil {
+			t.Errorf("ParseEval(%q) error: %v", s, err)
+			continue
+		}
+		if got := FormatEval(eval); got != s {
+			t.Errorf("FormatEval(ParseEval(%q)) = %q", s, got)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Play is a game between a human at the terminal and the engine
+type Play struct {
+	game     *Game
+	human    PieceColour
+	flipped  bool          // black at the bottom of the board
+	depth    int           // of the engine's searches, at most
+	moveTime time.Duration // of the engine's searches, 0 to search to depth
+	colours  bool          // highlight with ANSI escape codes instead of brackets
+	tables   *SearchTables
+	out      io.Writer
+}
+
+const playHelp = `Enter moves as e2e4, e7e8q, Nf3 or O-O. Commands:
+  undo           take back your last move
+  hint           suggest a move
+  flip           turn the board around
+  level depth n  search n plies deep
+  level time 2s  search for a time instead
+  save file.pgn  write the game as PGN
+  help           show this
+  quit           leave`
+
+// a game from the FEN with the human playing the colour, shown from their side
+func NewPlay(fen string, human PieceColour, out io.Writer) *Play {
+	players := map[PieceColour]Player{human: {name: "Human"}, human.Flip(): {name: "stupid-horse", me: true}}
+	g := NewGame(fen, players, nil)
+	g.started = time.Now()
+	g.tags["Event"] = "stupid-horse play"
+	return &Play{
+		game:    g,
+		human:   human,
+		flipped: human == Black,
+		depth:   6,
+		tables:  NewSearchTables(TTMaxSize),
+		out:     out,
+	}
+}
+
+// plays until the human quits or the input ends
+func (p *Play) Run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	p.printBoard()
+	fmt.Fprintln(p.out, `Type "help" for the commands.`)
+	for {
+		if _, over := p.Over(); !over && p.game.moveTree.position.turn != p.human {
+			p.engineMove()
+			p.printBoard()
+			continue
+		}
+		fmt.Fprintf(p.out, "%v> ", p.game.moveTree.position.turn)
+		if !scanner.Scan() {
+			fmt.Fprintln(p.out)
+			return
+		}
+		if !p.Handle(strings.TrimSpace(scanner.Text())) {
+			return
+		}
+	}
+}
+
+// handles a line of input, reporting whether to go on
+func (p *Play) Handle(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return true
+	}
+	switch strings.ToLower(fields[0]) {
+	case "quit", "exit":
+		return false
+	case "help":
+		fmt.Fprintln(p.out, playHelp)
+	case "undo", "takeback":
+		p.undo()
+	case "hint":
+		p.hint()
+	case "flip":
+		p.flipped = !p.flipped
+		p.printBoard()
+	case "level":
+		p.level(fields[1:])
+	case "save":
+		filename := "game.pgn"
+		if len(fields) > 1 {
+			filename = fields[1]
+		}
+		if err := p.save(filename); err != nil {
+			fmt.Fprintln(p.out, err)
+		} else {
+			fmt.Fprintln(p.out, "saved the game to", filename)
+		}
+	default:
+		if len(fields) > 1 {
+			fmt.Fprintf(p.out, "unknown command %q\n", line)
+			return true
+		}
+		if reason, over := p.Over(); over {
+			fmt.Fprintf(p.out, "the game is over (%s): undo, save or quit\n", reason)
+			return true
+		}
+		m, err := ParseMove(p.game.moveTree.position, fields[0])
+		if err != nil {
+			fmt.Fprintln(p.out, err)
+			return true
+		}
+		p.play(m)
+		p.printBoard()
+	}
+	return true
+}
+
+// parses a legal move in coordinate notation, such as "e2e4" or "e7e8q", or in SAN
+func ParseMove(pos Position, s string) (Move, error) {
+	if isCoordinateMove(s) {
+		m := pos.StringToMove(strings.ToLower(s))
+		if pos.IsLegal(m) {
+			return m, nil
+		}
+		return m, fmt.Errorf("%s is not a legal move", s)
+	}
+	m, err := pos.ParseSAN(s)
+	if err != nil {
+		return m, fmt.Errorf("%s is not a legal move or a command", s)
+	}
+	return m, nil
+}
+
+func isCoordinateMove(s string) bool {
+	s = strings.ToLower(s)
+	if len(s) != 4 && !(len(s) == 5 && strings.ContainsRune("qrbn", rune(s[4]))) {
+		return false
+	}
+	for i := 0; i < 4; i += 2 {
+		if s[i] < 'a' || s[i] > 'h' || s[i+1] < '1' || s[i+1] > '8' {
+			return false
+		}
+	}
+	return true
+}
+
+// makes the move, announcing the result if it ends the game
+func (p *Play) play(m Move) {
+	san := p.game.moveTree.position.MoveToSAN(m)
+	p.game.AddMoves(m.String())
+	if p.game.moveTree.position.turn == p.human {
+		fmt.Fprintf(p.out, "stupid-horse plays %s\n", san)
+	}
+	if reason, over := p.Over(); over {
+		fmt.Fprintf(p.out, "%s %s\n", p.game.result, reason)
+	}
+}
+
+// reports how the game ended, if it has, and records its result
+func (p *Play) Over() (string, bool) {
+	g := p.game
+	switch g.moveTree.state {
+	case WhiteWon:
+		g.result = "1-0"
+		return "checkmate", true
+	case BlackWon:
+		g.result = "0-1"
+		return "checkmate", true
+	case Stalemate:
+		g.result = "1/2-1/2"
+		return "stalemate", true
+	}
+	if reason := g.DrawReason(); reason != "" {
+		g.result = "1/2-1/2"
+		return reason, true
+	}
+	g.result = "*"
+	return "", false
+}
+
+// the limits of the engine's searches at the current level
+func (p *Play) limits() Limits {
+	limits := Limits{depth: p.depth, halfmoves: p.game.HalfmoveClock(), tables: p.tables}
+	if p.moveTime > 0 {
+		limits.depth = MaxPly
+		limits.deadline = time.Now().Add(p.moveTime)
+	}
+	return limits
+}
+
+// searches the position and plays the engine's move
+func (p *Play) engineMove() {
+	p.tables.Advance(p.game.Ply())
+	depth := 0
+	eval := ThinkUntil(p.game.moveTree, p.limits(), func(d, _ int) {
+		depth = d
+	})
+	if p.game.moveTree.follow == nil {
+		return
+	}
+	a := p.game.Annotation(p.game.Ply())
+	a.eval, a.hasEval = eval, true
+	a.comment = fmt.Sprintf("depth %d", depth)
+	p.play(p.game.moveTree.follow.move)
+}
+
+// suggests the move the engine would play for the human
+func (p *Play) hint() {
+	if _, over := p.Over(); over {
+		fmt.Fprintln(p.out, "the game is over")
+		return
+	}
+	p.tables.Advance(p.game.Ply())
+	tree := &MoveTree{position: p.game.moveTree.position}
+	eval := ThinkUntil(tree, p.limits(), nil)
+	fmt.Fprintf(p.out, "hint: %s (%s)\n", tree.position.MoveToSAN(tree.follow.move), FormatEval(eval))
+}
+
+// takes back the human's last move and the engine's reply to it
+func (p *Play) undo() {
+	g := p.game
+	plies := 2
+	if g.moveTree.position.turn != p.human {
+		// the engine has not replied, as the game is over
+		plies = 1
+	}
+	if g.Ply() < plies {
+		fmt.Fprintln(p.out, "there is no move of yours to take back")
+		return
+	}
+	g.TakeBack(plies)
+	p.Over()
+	p.printBoard()
+}
+
+// sets the engine's level from "depth n" or "time duration"
+func (p *Play) level(args []string) {
+	if len(args) != 2 {
+		fmt.Fprintln(p.out, "usage: level depth n, or level time 2s")
+		return
+	}
+	switch args[0] {
+	case "depth":
+		depth, err := strconv.Atoi(args[1])
+		if err != nil || depth < 1 || depth > MaxPly {
+			fmt.Fprintf(p.out, "the depth must be between 1 and %d\n", MaxPly)
+			return
+		}
+		p.depth, p.moveTime = depth, 0
+		fmt.Fprintf(p.out, "searching %d plies deep\n", depth)
+	case "time":
+		d, err := parseDuration(args[1])
+		if err != nil || d <= 0 {
+			fmt.Fprintln(p.out, "the time must be a duration such as 2s or 500ms")
+			return
+		}
+		p.moveTime = d
+		fmt.Fprintf(p.out, "searching for %v a move\n", d)
+	default:
+		fmt.Fprintln(p.out, "usage: level depth n, or level time 2s")
+	}
+}
+
+func (p *Play) save(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := p.game.WritePGN(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// prints the board with rank and file labels from the side it is viewed, with the last
+// move's squares highlighted
+func (p *Play) printBoard() {
+	pos := p.game.moveTree.position
+	var from, to Square = NoSquare, NoSquare
+	if p.game.Ply() > 0 {
+		from, to = p.game.moveTree.move.from, p.game.moveTree.move.to
+	}
+	var sb strings.Builder
+	files := "   a  b  c  d  e  f  g  h\n"
+	if p.flipped {
+		files = "   h  g  f  e  d  c  b  a\n"
+	}
+	sb.WriteString(files)
+	for row := 0; row < 8; row++ {
+		rank := Rank(7 - row)
+		if p.flipped {
+			rank = Rank(row)
+		}
+		fmt.Fprintf(&sb, "%v ", rank)
+		for col := 0; col < 8; col++ {
+			file := File(col)
+			if p.flipped {
+				file = File(7 - col)
+			}
+			square := ToSquare(file, rank)
+			symbol := "·"
+			if piece := pos.board[square]; piece != NoPiece {
+				symbol = piece.String()
+			}
+			switch {
+			case square != from && square != to:
+				sb.WriteString(" " + symbol + " ")
+			case p.colours:
+				sb.WriteString("\x1b[7m " + symbol + " \x1b[0m")
+			default:
+				sb.WriteString("[" + symbol + "]")
+			}
+		}
+		fmt.Fprintf(&sb, " %v\n", rank)
+	}
+	sb.WriteString(files)
+	fmt.Fprint(p.out, sb.String())
+}
+
+// whether the file is a terminal rather than a pipe or a file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// plays a game against the engine in the terminal
+func StartPlay(args []string) {
+	fs := commandFlags("play")
+	colour := fs.String("colour", "white", "your colour: white, black or random")
+	depth := fs.Int("depth", 6, "search depth of the engine's moves")
+	moveTime := fs.Duration("time", 0, "search time of the engine's moves instead of a depth")
+	fen := fs.String("fen", StartFEN, "start position")
+	plain := fs.Bool("plain", false, "mark the last move with brackets instead of colours")
+	fs.Parse(args)
+	if fs.NArg() > 0 || *depth < 1 || *depth > MaxPly || *moveTime < 0 {
+		usageError(fs)
+	}
+	if err := CheckFEN(*fen); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	human := White
+	switch strings.ToLower(*colour) {
+	case "white":
+	case "black":
+		human = Black
+	case "random":
+		human = PieceColour(time.Now().UnixNano()%2 == 1)
+	default:
+		usageError(fs)
+	}
+	p := NewPlay(*fen, human, os.Stdout)
+	p.depth, p.moveTime = *depth, *moveTime
+	p.colours = !*plain && isTerminal(os.Stdout)
+	p.Run(os.Stdin)
+}
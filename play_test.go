@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseMove(t *testing.T) {
+	pos := LoadInitialPosition("r3k3/1P6/8/8/8/8/8/4K2R w K - 0 1")
+	tests := []struct {
+		input, want string
+	}{
+		{"e1g1", "O-O"},
+		{"O-O", "O-O"},
+		{"b7a8q", "bxa8=Q+"},
+		{"B7A8N", "bxa8=N"},
+		{"bxa8=R+", "bxa8=R+"},
+		{"Kd2", "Kd2"},
+		{"e1e3", ""},
+		{"Ke3", ""},
+		{"castle", ""},
+	}
+	for _, test := range tests {
+		m, err := ParseMove(pos, test.input)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("ParseMove(%q) = %v; want an error", test.input, m)
+			}
+			continue
+		}
+		if err != nil || pos.MoveToSAN(m) != test.want {
+			t.Errorf("ParseMove(%q) = %v, %v; want %s", test.input, m, err, test.want)
+		}
+	}
+}
+
+func TestPlay(t *testing.T) {
+	var out strings.Builder
+	p := NewPlay(StartFEN, White, &out)
+	p.depth = 2
+	handle := func(line string) string {
+		out.Reset()
+		if !p.Handle(line) {
+			t.Fatalf("Handle(%q) quit", line)
+		}
+		return out.String()
+	}
+
+	if got := handle("e4"); !strings.Contains(got, "4  ·  ·  ·  · [♙] ·  ·  ·  4\n") || !strings.HasPrefix(got, "   a  b  c  d  e  f  g  h\n8 ") {
+		t.Errorf("board after e4:\n%s", got)
+	}
+	p.engineMove()
+	if p.game.Ply() != 2 || !p.game.annotations[1].hasEval {
+		t.Fatalf("engine did not reply to e4: %q", p.game.moves)
+	}
+	if got := handle("flip"); !strings.HasPrefix(got, "   h  g  f  e  d  c  b  a\n1 ") {
+		t.Errorf("flipped board:\n%s", got)
+	}
+	if got := handle("hint"); !strings.HasPrefix(got, "hint: ") || p.game.Ply() != 2 {
+		t.Errorf("hint = %q", got)
+	}
+	if got := handle("e5e6"); !strings.Contains(got, "not a legal move") {
+		t.Errorf("illegal move = %q", got)
+	}
+	if got := handle("level time soon"); !strings.Contains(got, "duration") {
+		t.Errorf("bad level = %q", got)
+	}
+	handle("level time 50ms")
+	handle("level depth 3")
+	if p.depth != 3 || p.moveTime != 0 {
+		t.Errorf("level = depth %d, time %v; want depth 3", p.depth, p.moveTime)
+	}
+	handle("undo")
+	if p.game.Ply() != 0 {
+		t.Errorf("undo left %q", p.game.moves)
+	}
+	if got := handle("undo"); !strings.Contains(got, "no move of yours") {
+		t.Errorf("undo at the start = %q", got)
+	}
+
+	filename := filepath.Join(t.TempDir(), "game.pgn")
+	handle("f3")
+	p.game.AddMoves("e7e5")
+	handle("g4")
+	p.game.AddMoves("d8h4")
+	if got := handle("a3"); !strings.Contains(got, "the game is over (checkmate)") {
+		t.Errorf("move after mate = %q", got)
+	}
+	handle("save " + filename)
+	pgn, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(pgn), "2. g4 Qh4# 0-1") || !strings.Contains(string(pgn), `[White "Human"]`) {
+		t.Errorf("saved PGN:\n%s", pgn)
+	}
+	// the mate and the move that allowed it
+	handle("undo")
+	if p.game.Ply() != 2 || p.game.result != "*" {
+		t.Errorf("undo after mate left %q, %s", p.game.moves, p.game.result)
+	}
+	if p.Handle("quit") {
+		t.Error("Handle(quit) went on")
+	}
+}
+
+func TestPlayRun(t *testing.T) {
+	var out strings.Builder
+	p := NewPlay(StartFEN, Black, &out)
+	p.depth = 1
+	p.Run(strings.NewReader("e5\nquit\n"))
+	if p.game.Ply() != 3 || strings.Count(out.String(), "stupid-horse plays") != 2 {
+		t.Errorf("Run() played %q, wrote:\n%s", p.game.moves, out.String())
+	}
+}
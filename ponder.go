@@ -0,0 +1,83 @@
+package main
+
+import "time"
+
+// Ponder searches the position expected after the opponent's reply on the opponent's time
+type Ponder struct {
+	tree  *MoveTree // the position after the expected reply
+	eval  int
+	depth int // the deepest completed
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// starts searching the line the tree's search expects after its move, in the background and
+// through run, which may refuse to search by returning false. The search warms the limits'
+// tables, if any, for the search of the next move either way. Returns nil if the search
+// expects no reply
+func StartPonder(mt *MoveTree, limits Limits, run func(search func()) bool) *Ponder {
+	if mt.follow == nil || mt.follow.follow == nil {
+		return nil
+	}
+	move, reply := mt.follow, mt.follow.follow
+	limits.halfmoves += 2
+	if resetsHalfmoves(mt.position, move.move) {
+		limits.halfmoves = 1
+	}
+	if resetsHalfmoves(move.position, reply.move) {
+		limits.halfmoves = 0
+	}
+	if limits.tables != nil {
+		limits.tables.Advance(limits.tables.ply + 2)
+	}
+	p := &Ponder{
+		tree: &MoveTree{position: reply.position},
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	limits.stop = p.stop
+	go func() {
+		defer close(p.done)
+		run(func() {
+			p.eval = ThinkUntil(p.tree, limits, func(depth, _ int) {
+				p.depth = depth
+			})
+		})
+	}()
+	return p
+}
+
+// stops pondering and waits for the search to end
+func (p *Ponder) Stop() {
+	close(p.stop)
+	<-p.done
+}
+
+// reports whether the opponent's reply led to the expected position (a ponder hit), letting
+// the search run on to its limits or until the deadline, unless it is zero, if so, and
+// stopping it otherwise
+func (p *Ponder) Finish(pos Position, deadline time.Time) bool {
+	if pos != p.tree.position {
+		p.Stop()
+		return false
+	}
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		select {
+		case <-p.done:
+		case <-timer.C:
+			p.Stop()
+		}
+	}
+	<-p.done
+	return p.tree.follow != nil
+}
+
+// stops the bot's pondering in the game, if it is pondering
+func (g *Game) StopPondering() {
+	if g.ponder != nil {
+		g.ponder.Stop()
+		g.ponder = nil
+	}
+}
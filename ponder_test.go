@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func runNow(search func()) bool {
+	search()
+	return true
+}
+
+func TestPonder(t *testing.T) {
+	fen := "r1bqkbnr/pppp1ppp/2n5/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R w KQkq - 2 3"
+	mt := &MoveTree{position: LoadInitialPosition(fen)}
+	limits := Limits{depth: 3}
+	ThinkUntil(mt, limits, nil)
+	expected := mt.follow.follow.position
+
+	p := StartPonder(mt, limits, runNow)
+	if !p.Finish(expected, time.Time{}) {
+		t.Fatal("Finish() of the expected position was not a ponder hit")
+	}
+	direct := &MoveTree{position: expected}
+	if eval := ThinkUntil(direct, limits, nil); p.eval != eval || p.depth != limits.depth {
+		t.Errorf("ponder hit found %d at depth %d; want %d at depth %d", p.eval, p.depth, eval, limits.depth)
+	}
+
+	tables := NewSearchTables(TTMaxSize)
+	p = StartPonder(mt, Limits{depth: 3, tables: tables}, runNow)
+	if p.Finish(mt.follow.position, time.Time{}) {
+		t.Error("Finish() of an unexpected position was a ponder hit")
+	}
+	if tables.tt.Len() == 0 || tables.ply != 2 {
+		t.Errorf("a stopped ponder search left %d table entries at ply %d; want some at ply 2", tables.tt.Len(), tables.ply)
+	}
+
+	// on a hit, a search without limits stops at the deadline of the move
+	p = StartPonder(mt, Limits{depth: MaxPly}, runNow)
+	start := time.Now()
+	if !p.Finish(expected, start.Add(50*time.Millisecond)) {
+		t.Error("Finish() with a deadline was not a ponder hit")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second || p.depth >= MaxPly {
+		t.Errorf("Finish() with a 50ms deadline returned after %v at depth %d", elapsed, p.depth)
+	}
+
+	p = StartPonder(mt, limits, func(func()) bool { return false })
+	if p.Finish(expected, time.Time{}) {
+		t.Error("Finish() was a ponder hit without a search")
+	}
+	if StartPonder(&MoveTree{position: expected}, limits, runNow) != nil {
+		t.Error("StartPonder() without a search started pondering")
+	}
+}
@@ -3,12 +3,14 @@ package main
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"unicode"
 )
 
 type Position struct {
 	board           Board
 	turn            PieceColour
-	rookSquares     [4]Square // for castling
+	rookSquares     [4]Square // unmoved rooks for castling: black a-side, black h-side, white a-side, white h-side
 	whiteKingMoved  bool
 	blackKingMoved  bool
 	enPassantSquare Square
@@ -21,6 +23,9 @@ type Move struct {
 	castle  CastleDirection
 }
 
+// the zero Move never occurs in play, since its from and to squares are equal
+var NoMove = Move{}
+
 type CastleDirection int
 
 const (
@@ -112,15 +117,16 @@ var pawnInfo = map[PieceColour]PawnInfo{
 	Black: PawnInfo{Rank(6), -1, Rank(0)},
 }
 
+const StartFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
 func LoadInitialPosition(fen string) Position {
 	if fen == "startpos" {
-		fen = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+		fen = StartFEN
 	}
+	fields := strings.Fields(fen)
 	var b Board
-	var rs [4]Square
-	nr := 0
 	s := 0
-	for _, char := range fen {
+	for _, char := range fields[0] {
 		if char-'1' >= 0 && '8'-char >= 0 {
 			s += int(char-'1') + 1
 			continue
@@ -128,30 +134,136 @@ func LoadInitialPosition(fen string) Position {
 		if char == '/' {
 			continue
 		}
-		if char == ' ' {
-			break
-		}
 		b[s] = fenPieces[char]
-		if b[s].Type() == Rook {
-			rs[nr] = Square(s)
-			nr++
-		}
 		s++
 	}
-	return Position{
+	p := Position{
 		board:           b,
 		turn:            White,
-		rookSquares:     rs,
-		whiteKingMoved:  false,
-		blackKingMoved:  false,
+		rookSquares:     [4]Square{NoSquare, NoSquare, NoSquare, NoSquare},
+		whiteKingMoved:  true,
+		blackKingMoved:  true,
 		enPassantSquare: NoSquare,
 	}
+	if len(fields) > 1 && fields[1] == "b" {
+		p.turn = Black
+	}
+	if len(fields) > 2 {
+		for _, char := range fields[2] {
+			p.addCastlingRight(char)
+		}
+	}
+	if len(fields) > 3 {
+		p.enPassantSquare = StringToSquare(fields[3])
+	}
+	return p
+}
+
+// checks that the FEN has a board of eight ranks of eight squares with one king of each
+// colour and no pawns on the first or eighth rank, and a side to move, before it is loaded
+func CheckFEN(fen string) error {
+	if fen == "startpos" {
+		return nil
+	}
+	fields := strings.Fields(fen)
+	if len(fields) < 2 || fields[1] != "w" && fields[1] != "b" {
+		return fmt.Errorf("FEN %q needs a board and a side to move", fen)
+	}
+	ranks := strings.Split(fields[0], "/")
+	if len(ranks) != 8 {
+		return fmt.Errorf("FEN %q does not have 8 ranks", fen)
+	}
+	kings := map[rune]int{}
+	for i, rank := range ranks {
+		squares := 0
+		for _, char := range rank {
+			switch {
+			case char >= '1' && char <= '8':
+				squares += int(char - '0')
+			case (char == 'P' || char == 'p') && (i == 0 || i == 7):
+				return fmt.Errorf("FEN %q has a pawn on the first or eighth rank", fen)
+			case fenPieces[char] != NoPiece:
+				squares++
+				kings[char]++
+			default:
+				return fmt.Errorf("FEN %q has an unknown piece %q", fen, char)
+			}
+		}
+		if squares != 8 {
+			return fmt.Errorf("FEN %q has a rank of %d squares", fen, squares)
+		}
+	}
+	if kings['K'] != 1 || kings['k'] != 1 {
+		return fmt.Errorf("FEN %q needs one king of each colour", fen)
+	}
+	return nil
+}
+
+// reads one character of the castling field, accepting standard (KQkq) as well as
+// Shredder-FEN and X-FEN (file letters) notation for Chess960
+func (p *Position) addCastlingRight(char rune) {
+	c := White
+	if unicode.IsLower(char) {
+		c = Black
+	}
+	rank := pawnInfo[c.Flip()].promotionRank
+	kingSquare := p.KingSquare(c)
+	if kingSquare == NoSquare || kingSquare.Rank() != rank {
+		return
+	}
+	rook := CreatePiece(c, Rook)
+	rookSquare := NoSquare
+	switch unicode.ToUpper(char) {
+	case 'K':
+		// outermost rook on the h-side
+		for f := File(7); f > kingSquare.File(); f-- {
+			if p.board[ToSquare(f, rank)] == rook {
+				rookSquare = ToSquare(f, rank)
+				break
+			}
+		}
+	case 'Q':
+		// outermost rook on the a-side
+		for f := File(0); f < kingSquare.File(); f++ {
+			if p.board[ToSquare(f, rank)] == rook {
+				rookSquare = ToSquare(f, rank)
+				break
+			}
+		}
+	case 'A', 'B', 'C', 'D', 'E', 'F', 'G', 'H':
+		square := ToSquare(File(unicode.ToUpper(char)-'A'), rank)
+		if p.board[square] == rook {
+			rookSquare = square
+		}
+	}
+	if rookSquare == NoSquare {
+		return
+	}
+	i := 2
+	if c == Black {
+		i = 0
+	}
+	if rookSquare.File() > kingSquare.File() {
+		i++
+	}
+	p.rookSquares[i] = rookSquare
+	p.setKingMoved(c, false)
 }
 
 func (m Move) String() string {
 	return fmt.Sprintf("%v%v%v", m.from, m.to, m.promote)
 }
 
+// coordinate notation for UCI, where castling is written as the king's two-square step
+// unless chess960 is set
+func (m Move) UCI(chess960 bool) string {
+	if m.castle == NoCastle || chess960 {
+		return m.String()
+	}
+	kingSquare, _ := GetCastleSquares(m.from, m.to)
+	return m.from.String() + kingSquare.String()
+}
+
 // converts algebraic notation to move object
 func (p Position) StringToMove(moveString string) Move {
 	m := Move{
@@ -171,8 +283,12 @@ func (p Position) StringToMove(moveString string) Move {
 			}
 		}
 	} else {
-		// check for standard variant notation of castling
 		fromPiece := p.board[m.from]
+		if fromPiece.Type() == Pawn && m.from.File() != m.to.File() {
+			// en passant
+			m.capture = true
+		}
+		// check for standard variant notation of castling
 		if fromPiece.Type() == King {
 			switch moveString {
 			case "e8c8":
@@ -313,250 +429,359 @@ func (p Position) ProcessMove(m Move) Position {
 	return p
 }
 
-func (mt *MoveTree) FindMoves(depth int, tt *TranspositionTable, f func(*MoveTree, int, *TranspositionTable) int) int {
-	// if searched before
-	cachedMoveTree := tt.Get(mt.position)
-	if cachedMoveTree != nil {
-		mt.legal = true
-		if mt.parent != nil {
-			mt.parent.legalMoves = append(mt.parent.legalMoves, mt.move)
-		}
-		mt.candidateMoves = cachedMoveTree.candidateMoves
-	} else if mt.legal {
-		mt.legalMoves = nil
-		mt.follow = nil
-		mt.eval = 0
-	} else {
-		p := mt.position
-		moves := make([]Move, 0, 40)
-		for _, square := range Squares {
-			piece := p.board[square]
-			if piece == NoPiece || piece.Colour() != p.turn {
-				continue
-			}
-			pieceType := piece.Type()
-			switch pieceType {
-			case Bishop, Rook, Queen, Knight, King: // regular movements
-				for _, m := range pieceMovements[pieceType] {
-					curSquare := square
-					for ok := true; ok; ok = (pieceType != Knight && pieceType != King) {
-						newSquare, err := curSquare.Move(m)
-						if err != nil {
-							break
-						}
-						// check if parent castling is valid
-						increment := 0
-						if mt.move.castle != NoCastle {
-							rank := mt.move.from.Rank()
-							if newSquare.Rank() == rank {
-								// TODO: optimize checking squares by avoiding loop
-								if mt.move.castle == ASide {
-									increment = -1
-								} else {
-									increment = 1
-								}
-								for f := mt.move.from.File(); f >= 0 && f < 8; f = File(int(f) + increment) {
-									passSquare := ToSquare(f, rank)
-									if passSquare == newSquare {
-										mt.legal = false
-										return 0
-									}
-									passPiece := p.board[passSquare]
-									if passPiece != NoPiece && passPiece.Type() == King {
-										break
-									}
-								}
-							}
-						}
-
-						curSquare = newSquare
-						pieceAt := p.board[curSquare]
-						if pieceAt == NoPiece {
-							moves = append(moves, Move{
-								from: square, to: curSquare,
-							})
-							continue
-						} else if pieceAt.Colour() != p.turn {
-							if pieceAt.Type() == King {
-								mt.legal = false
-								return 0
-							}
-							moves = append(moves, Move{
-								from: square, to: curSquare, capture: true,
-							})
-						}
-						break
-					}
-				}
-			case Pawn:
-				pi := pawnInfo[p.turn]
-				// one square forward
-				oneSquare, err := square.Move(Movement{0, pi.forward})
+// MoveKind selects which pseudo-legal moves are generated
+type MoveKind int
+
+const (
+	Captures MoveKind = 1 << iota // captures, including en passant and capturing promotions
+	Quiets                        // everything else, including castling and quiet promotions
+	AllMoves = Captures | Quiets
+)
+
+var promotionPieceTypes = []PieceType{Queen, Rook, Bishop, Knight}
+
+// appends the pseudo-legal moves of the given kind for every piece of the side to move
+func (p Position) GenerateMoves(moves []Move, kind MoveKind) []Move {
+	for _, square := range Squares {
+		moves = p.appendPieceMoves(moves, square, kind)
+	}
+	return moves
+}
+
+// appends the pseudo-legal moves of the given kind for the piece on square
+func (p Position) appendPieceMoves(moves []Move, square Square, kind MoveKind) []Move {
+	piece := p.board[square]
+	if piece == NoPiece || piece.Colour() != p.turn {
+		return moves
+	}
+	pieceType := piece.Type()
+	switch pieceType {
+	case Bishop, Rook, Queen, Knight, King: // regular movements
+		for _, m := range pieceMovements[pieceType] {
+			curSquare := square
+			for ok := true; ok; ok = (pieceType != Knight && pieceType != King) {
+				newSquare, err := curSquare.Move(m)
 				if err != nil {
-					panic("Pawn can somehow step forward off the board")
+					break
 				}
-				if p.board[oneSquare] == NoPiece {
-					// promotion
-					if oneSquare.Rank() == pi.promotionRank {
-						for _, promotionPieceType := range []PieceType{Queen, Rook, Bishop, Knight} {
-							moves = append(moves, Move{
-								from: square, to: oneSquare, promote: promotionPieceType,
-							})
-						}
-					} else {
+				curSquare = newSquare
+				pieceAt := p.board[curSquare]
+				if pieceAt == NoPiece {
+					if kind&Quiets != 0 {
 						moves = append(moves, Move{
-							from: square, to: oneSquare,
+							from: square, to: curSquare,
 						})
-						// two squares forward
-						if square.Rank() == pi.homeRank {
-							twoSquare, err := square.Move(Movement{0, pi.forward * 2})
-							if err != nil {
-								panic("Pawn can somehow step forward off the board")
-							}
-							if p.board[twoSquare] == NoPiece {
-								moves = append(moves, Move{
-									from: square, to: twoSquare,
-								})
-							}
-						}
 					}
+					continue
+				} else if pieceAt.Colour() != p.turn && kind&Captures != 0 {
+					moves = append(moves, Move{
+						from: square, to: curSquare, capture: true,
+					})
+				}
+				break
+			}
+		}
+		if pieceType == King && kind&Quiets != 0 {
+			moves = p.appendCastles(moves, square)
+		}
+	case Pawn:
+		pi := pawnInfo[p.turn]
+		// one square forward
+		oneSquare, err := square.Move(Movement{0, pi.forward})
+		if err != nil {
+			panic("Pawn can somehow step forward off the board")
+		}
+		if kind&Quiets != 0 && p.board[oneSquare] == NoPiece {
+			// promotion
+			if oneSquare.Rank() == pi.promotionRank {
+				for _, promotionPieceType := range promotionPieceTypes {
+					moves = append(moves, Move{
+						from: square, to: oneSquare, promote: promotionPieceType,
+					})
 				}
-				// capture
-				for _, m := range []Movement{Movement{-1, pi.forward}, Movement{1, pi.forward}} {
-					captureSquare, err := square.Move(m)
+			} else {
+				moves = append(moves, Move{
+					from: square, to: oneSquare,
+				})
+				// two squares forward
+				if square.Rank() == pi.homeRank {
+					twoSquare, err := square.Move(Movement{0, pi.forward * 2})
 					if err != nil {
-						continue
-					}
-					// check if parent castling is valid
-					increment := 0
-					if mt.move.castle != NoCastle {
-						rank := mt.move.from.Rank()
-						if captureSquare.Rank() == rank {
-							// TODO: optimize checking squares by avoiding loop
-							if mt.move.castle == ASide {
-								increment = -1
-							} else {
-								increment = 1
-							}
-							for f := mt.move.from.File(); f >= 0 && f < 8; f = File(int(f) + increment) {
-								passSquare := ToSquare(f, rank)
-								if passSquare == captureSquare {
-									mt.legal = false
-									return 0
-								}
-								passPiece := p.board[passSquare]
-								if passPiece != NoPiece && passPiece.Type() == King {
-									break
-								}
-							}
-						}
-					}
-					pieceAt := p.board[captureSquare]
-					if pieceAt == NoPiece {
-						// en passant
-						if captureSquare == p.enPassantSquare {
-							moves = append(moves, Move{
-								from: square, to: captureSquare, capture: true,
-							})
-						}
-						continue
+						panic("Pawn can somehow step forward off the board")
 					}
-					if pieceAt.Colour() != p.turn {
-						if pieceAt.Type() == King {
-							mt.legal = false
-							return 0
-						}
-						// promotion
-						if captureSquare.Rank() == pi.promotionRank {
-							for _, promotionPieceType := range []PieceType{Queen, Rook, Bishop, Knight} {
-								moves = append(moves, Move{
-									from: square, to: captureSquare, capture: true, promote: promotionPieceType,
-								})
-							}
-						} else {
-							moves = append(moves, Move{
-								from: square, to: captureSquare, capture: true,
-							})
-						}
+					if p.board[twoSquare] == NoPiece {
+						moves = append(moves, Move{
+							from: square, to: twoSquare,
+						})
 					}
 				}
 			}
-			if pieceType == King {
-				if !p.kingMoved(p.turn) {
-					for _, rookSquare := range p.rookSquares {
-						if rookSquare == NoSquare || p.board[rookSquare].Colour() != p.turn {
-							continue
-						}
-						blocked := false
-						for _, betweenSquare := range BetweenSquares(square, rookSquare) {
-							if p.board[betweenSquare] != NoPiece {
-								blocked = true
-								break
-							}
-						}
-						if blocked {
-							continue
-						}
-						kingToSquare, rookToSquare := GetCastleSquares(square, rookSquare)
-						if p.board[kingToSquare] != NoPiece && kingToSquare != square && kingToSquare != rookSquare {
-							continue
-						}
-						if p.board[rookToSquare] != NoPiece && rookToSquare != square && rookToSquare != rookSquare {
-							continue
-						}
-						kingBetweenSquares := BetweenSquares(square, kingToSquare)
-						for _, betweenSquare := range kingBetweenSquares {
-							if p.board[betweenSquare] != NoPiece && betweenSquare != rookSquare {
-								blocked = true
-								break
-							}
-						}
-						if blocked {
-							continue
-						}
-
-						castleDirection := ASide
-						if rookSquare.File() > square.File() {
-							castleDirection = HSide
-						}
-
+		}
+		if kind&Captures == 0 {
+			break
+		}
+		// capture
+		for _, m := range []Movement{Movement{-1, pi.forward}, Movement{1, pi.forward}} {
+			captureSquare, err := square.Move(m)
+			if err != nil {
+				continue
+			}
+			pieceAt := p.board[captureSquare]
+			if pieceAt == NoPiece {
+				// en passant
+				if captureSquare == p.enPassantSquare {
+					moves = append(moves, Move{
+						from: square, to: captureSquare, capture: true,
+					})
+				}
+				continue
+			}
+			if pieceAt.Colour() != p.turn {
+				// promotion
+				if captureSquare.Rank() == pi.promotionRank {
+					for _, promotionPieceType := range promotionPieceTypes {
 						moves = append(moves, Move{
-							from: square, to: rookSquare, castle: castleDirection,
+							from: square, to: captureSquare, capture: true, promote: promotionPieceType,
 						})
 					}
+				} else {
+					moves = append(moves, Move{
+						from: square, to: captureSquare, capture: true,
+					})
 				}
 			}
 		}
+	}
+	return moves
+}
+
+// appends castling moves for the king on square (legality through check is verified by LegalAfter)
+func (p Position) appendCastles(moves []Move, square Square) []Move {
+	if p.kingMoved(p.turn) {
+		return moves
+	}
+	for _, rookSquare := range p.rookSquares {
+		if rookSquare == NoSquare || p.board[rookSquare] != CreatePiece(p.turn, Rook) {
+			continue
+		}
+		if rookSquare.Rank() != square.Rank() {
+			continue
+		}
+		blocked := false
+		for _, betweenSquare := range BetweenSquares(square, rookSquare) {
+			if p.board[betweenSquare] != NoPiece {
+				blocked = true
+				break
+			}
+		}
+		if blocked {
+			continue
+		}
+		kingToSquare, rookToSquare := GetCastleSquares(square, rookSquare)
+		if p.board[kingToSquare] != NoPiece && kingToSquare != square && kingToSquare != rookSquare {
+			continue
+		}
+		if p.board[rookToSquare] != NoPiece && rookToSquare != square && rookToSquare != rookSquare {
+			continue
+		}
+		kingBetweenSquares := BetweenSquares(square, kingToSquare)
+		for _, betweenSquare := range kingBetweenSquares {
+			if p.board[betweenSquare] != NoPiece && betweenSquare != rookSquare {
+				blocked = true
+				break
+			}
+		}
+		if blocked {
+			continue
+		}
+
+		castleDirection := ASide
+		if rookSquare.File() > square.File() {
+			castleDirection = HSide
+		}
+
+		moves = append(moves, Move{
+			from: square, to: rookSquare, castle: castleDirection,
+		})
+	}
+	return moves
+}
+
+// checks whether a pseudo-legal move m exists in this position, without generating every move
+func (p Position) IsPseudoLegal(m Move) bool {
+	if m == NoMove {
+		return false
+	}
+	kind := Quiets
+	if m.capture {
+		kind = Captures
+	}
+	for _, move := range p.appendPieceMoves(make([]Move, 0, 28), m.from, kind) {
+		if move == m {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Position) KingSquare(c PieceColour) Square {
+	king := CreatePiece(c, King)
+	for _, square := range Squares {
+		if p.board[square] == king {
+			return square
+		}
+	}
+	return NoSquare
+}
+
+// checks whether any piece of colour by attacks square s
+func (p Position) Attacked(s Square, by PieceColour) bool {
+	pi := pawnInfo[by]
+	for _, m := range []Movement{Movement{-1, -pi.forward}, Movement{1, -pi.forward}} {
+		square, err := s.Move(m)
+		if err == nil && p.board[square] == CreatePiece(by, Pawn) {
+			return true
+		}
+	}
+	for _, pieceType := range []PieceType{Knight, King} {
+		for _, m := range pieceMovements[pieceType] {
+			square, err := s.Move(m)
+			if err == nil && p.board[square] == CreatePiece(by, pieceType) {
+				return true
+			}
+		}
+	}
+	for _, m := range pieceMovements[Queen] {
+		diagonal := m[0] != 0 && m[1] != 0
+		curSquare := s
+		for {
+			newSquare, err := curSquare.Move(m)
+			if err != nil {
+				break
+			}
+			curSquare = newSquare
+			pieceAt := p.board[curSquare]
+			if pieceAt == NoPiece {
+				continue
+			}
+			if pieceAt.Colour() == by {
+				switch pieceAt.Type() {
+				case Queen:
+					return true
+				case Bishop:
+					if diagonal {
+						return true
+					}
+				case Rook:
+					if !diagonal {
+						return true
+					}
+				}
+			}
+			break
+		}
+	}
+	return false
+}
+
+func (p Position) InCheck() bool {
+	return p.Attacked(p.KingSquare(p.turn), p.turn.Flip())
+}
+
+// neither side can possibly checkmate: bare kings, a single minor piece, or only
+// bishops that all stand on squares of one colour
+func (p Position) InsufficientMaterial() bool {
+	knights, lightBishops, darkBishops := 0, 0, 0
+	for _, square := range Squares {
+		switch p.board[square].Type() {
+		case NoPieceType, King:
+		case Knight:
+			knights++
+		case Bishop:
+			if (int(square.File())+int(square.Rank()))%2 == 0 {
+				darkBishops++
+			} else {
+				lightBishops++
+			}
+		default:
+			return false
+		}
+	}
+	if knights+lightBishops+darkBishops <= 1 {
+		return true
+	}
+	return knights == 0 && (lightBishops == 0 || darkBishops == 0)
+}
+
+// checks that p, reached by playing m, did not leave the moving side's king capturable
+func (p Position) LegalAfter(m Move) bool {
+	mover := p.turn.Flip()
+	if m.castle != NoCastle {
+		// the king may not castle out of, through or into check. The squares it crosses are
+		// checked with the pieces where they stood before the move, as in chess960 the rook's
+		// new square can block an attack on them
+		kingSquare, rookSquare := GetCastleSquares(m.from, m.to)
+		before := p
+		before.board[kingSquare] = NoPiece
+		before.board[rookSquare] = NoPiece
+		before.board[m.from] = CreatePiece(mover, King)
+		before.board[m.to] = CreatePiece(mover, Rook)
+		rank := m.from.Rank()
+		first, last := m.from.File(), kingSquare.File()
+		if first > last {
+			first, last = last, first
+		}
+		for f := first; f <= last; f++ {
+			if before.Attacked(ToSquare(f, rank), p.turn) {
+				return false
+			}
+		}
+		// and the rook may no longer shield its destination
+		return !p.Attacked(kingSquare, p.turn)
+	}
+	kingSquare := p.KingSquare(mover)
+	return kingSquare == NoSquare || !p.Attacked(kingSquare, p.turn)
+}
+
+func (mt *MoveTree) FindMoves(depth int, tt *TranspositionTable, f func(*MoveTree, int, *TranspositionTable) int) int {
+	if !mt.legal && !mt.position.LegalAfter(mt.move) {
+		return 0
+	}
+	// if searched before
+	cachedMoveTree := tt.Get(mt.position)
+	if cachedMoveTree != nil {
+		mt.legal = true
+		if mt.parent != nil {
+			mt.parent.legalMoves = append(mt.parent.legalMoves, mt.move)
+		}
+		mt.candidateMoves = cachedMoveTree.candidateMoves
+	} else if mt.legal {
+		mt.legalMoves = nil
+		mt.follow = nil
+		mt.eval = 0
+	} else {
 		mt.legal = true
 		if mt.parent != nil {
 			mt.parent.legalMoves = append(mt.parent.legalMoves, mt.move)
 		}
-		mt.candidateMoves = moves
+		mt.candidateMoves = mt.position.GenerateMoves(make([]Move, 0, 40), AllMoves)
 		tt.Add(mt)
 	}
 	rv := f(mt, depth, tt)
 	if len(mt.legalMoves) == 0 && depth > 0 {
 		// check if stalemate or checkmate
-		lastMove := mt.position.turn.Flip()
-		testMt := new(MoveTree)
-		testMt.position = mt.position
-		// act if we skipped a turn--can opponent take the king?
-		testMt.position.turn = lastMove
-		testMt.FindMoves(0, tt,
-			func(_ *MoveTree, _ int, _ *TranspositionTable) int {
-				return 0
-			})
-		if testMt.legal {
-			mt.state = Stalemate
+		if mt.position.InCheck() {
+			mt.state = WinFor(mt.position.turn.Flip())
 		} else {
-			mt.state = WinFor(lastMove)
+			mt.state = Stalemate
 		}
 	}
 
 	return rv
 }
 
-func (root *MoveTree) FindAllMoves(startDepth int) int {
+// counts the leaf nodes of the legal move tree, one level at a time
+func perftCounter() func(*MoveTree, int, *TranspositionTable) int {
 	var c func(*MoveTree, int, *TranspositionTable) int
 	c = func(mt *MoveTree, depth int, tt *TranspositionTable) int {
 		if depth == 0 {
@@ -568,6 +793,13 @@ func (root *MoveTree) FindAllMoves(startDepth int) int {
 			child.parent = mt
 			child.move = move
 			child.position = mt.position.ProcessMove(move)
+			if depth == 1 {
+				// leaves only need to be legal, not expanded
+				if child.position.LegalAfter(move) {
+					nodes++
+				}
+				continue
+			}
 			childNodes := child.FindMoves(depth-1, tt, c)
 			if child.legal {
 				nodes += childNodes
@@ -575,8 +807,31 @@ func (root *MoveTree) FindAllMoves(startDepth int) int {
 		}
 		return nodes
 	}
-	tt := NewTranspositionTable(TTMaxSize)
-	return root.FindMoves(startDepth, tt, c)
+	return c
+}
+
+func (root *MoveTree) FindAllMoves(startDepth int) int {
+	return root.FindMoves(startDepth, nil, perftCounter())
+}
+
+// counts the leaf nodes below each legal root move
+func (root *MoveTree) Divide(depth int) map[Move]int {
+	c := perftCounter()
+	divide := map[Move]int{}
+	root.FindMoves(depth, nil, func(mt *MoveTree, depth int, tt *TranspositionTable) int {
+		for _, move := range mt.candidateMoves {
+			child := new(MoveTree)
+			child.parent = mt
+			child.move = move
+			child.position = mt.position.ProcessMove(move)
+			nodes := child.FindMoves(depth-1, tt, c)
+			if child.legal {
+				divide[move] = nodes
+			}
+		}
+		return 0
+	})
+	return divide
 }
 
 func (root *MoveTree) Peek() {
@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -74,6 +77,82 @@ func TestFindAllMoves(t *testing.T) {
 	})
 }
 
+func TestPerftSuites(t *testing.T) {
+	// counts above the budget are only checked by the perft command
+	maxNodes := 2000000
+	if testing.Short() {
+		maxNodes = 50000
+	}
+	suites := []struct {
+		filename string
+		stride   int // check every stride-th position
+		short    int // stride in short mode
+	}{
+		{"testdata/perft.epd", 1, 1},
+		{"testdata/chess960.epd", 1, 12},
+	}
+	for _, suite := range suites {
+		f, err := os.Open(suite.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		epds, err := ReadEPDs(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stride := suite.stride
+		if testing.Short() {
+			stride = suite.short
+		}
+		for i := 0; i < len(epds); i += stride {
+			e := epds[i]
+			for _, opcode := range e.opcodes {
+				depth, err := strconv.Atoi(strings.TrimPrefix(opcode, "D"))
+				if !strings.HasPrefix(opcode, "D") || err != nil {
+					continue
+				}
+				want, err := strconv.Atoi(e.operations[opcode])
+				if err != nil {
+					t.Fatalf("%s #%d: bad count %q", suite.filename, i+1, e.operations[opcode])
+				}
+				if want > maxNodes {
+					break
+				}
+				tree := MoveTree{position: LoadInitialPosition(e.fen)}
+				if nodes := tree.FindAllMoves(depth); nodes != want {
+					t.Errorf("%s #%d %s: FindAllMoves(%d) = %d; want %d", suite.filename, i+1, e.fen, depth, nodes, want)
+				}
+			}
+		}
+	}
+}
+
+func TestDivideCastling(t *testing.T) {
+	tests := []struct {
+		fen    string
+		castle bool
+	}{
+		// in check from e1, which the rook would block from d1
+		{"4k3/8/8/8/8/8/8/RK2r3 w A - 0 1", false},
+		{"4k3/8/8/8/8/8/7r/RK6 w A - 0 1", true},
+		// the rook on b1 shields c1 only before the move
+		{"4k3/8/8/8/8/8/8/rR2K3 w B - 0 1", false},
+	}
+	for _, test := range tests {
+		tree := MoveTree{position: LoadInitialPosition(test.fen)}
+		castle := false
+		for move := range tree.Divide(1) {
+			if move.castle != NoCastle {
+				castle = true
+			}
+		}
+		if castle != test.castle {
+			t.Errorf("Divide(1) of %s lists a castle: %t; want %t", test.fen, castle, test.castle)
+		}
+	}
+}
+
 func TestMoveTreeState(t *testing.T) {
 	t.Run("Fastest checkmate", func(t *testing.T) {
 		g := NewGame("startpos", nil, nil)
@@ -99,6 +178,35 @@ func TestMoveTreeState(t *testing.T) {
 	})
 }
 
+func TestCheckFEN(t *testing.T) {
+	tests := []struct {
+		fen string
+		ok  bool
+	}{
+		{StartFEN, true},
+		{"startpos", true},
+		{"4k3/8/8/8/8/8/4P3/4K3 b - -", true},
+		{"4k3/8/8/8/8/8/4P3/4K3", false},
+		{"4k3/8/8/8/8/8/4P3/4K3 x - -", false},
+		{"4k3/8/8/8/8/8/4K3 w - -", false},
+		{"4k3/8/8/8/8/8/4P4/4K3 w - -", false},
+		{"4k3/8/8/8/8/8/4X3/4K3 w - -", false},
+		{"4k3/8/8/8/8/8/4K3/4K3 w - -", false},
+		{"8/8/8/8/8/8/8/4K3 w - -", false},
+		{"P3k3/8/8/8/8/8/8/4K3 w - - 0 1", false},
+		{"4k3/8/8/8/8/8/8/p3K3 b - - 0 1", false},
+		{"4k3/8/8/8/8/8/8/4K2P w - -", false},
+		{"", false},
+		{" w - - 0 1", false},
+		{"8/8/8/8/8/8/8/8 w - -", false},
+	}
+	for _, test := range tests {
+		if err := CheckFEN(test.fen); (err == nil) != test.ok {
+			t.Errorf("CheckFEN(%q) = %v; want ok %t", test.fen, err, test.ok)
+		}
+	}
+}
+
 func BenchmarkFindAllMoves(b *testing.B) {
 	pos := LoadInitialPosition("nbqrknbr/pppppppp/8/8/8/8/PPPPPPPP/NBQRKNBR w KQkq - 0 1")
 	tree := MoveTree{
@@ -126,7 +234,7 @@ func BenchmarkThink(b *testing.B) {
 	b.Run("l=4", func(b *testing.B) {
 		beginning := &tree
 		for i := 0; i < 4; i++ {
-			Think(beginning)
+			Think(beginning, 4)
 			cur := beginning
 			for cur.follow != nil {
 				fmt.Printf("%v ", cur.move)
@@ -145,7 +253,7 @@ func BenchmarkThink(b *testing.B) {
 			b.Skip("skipping l=10 in short mode.")
 		}
 		for i := 0; i < 10; i++ {
-			Think(beginning)
+			Think(beginning, 4)
 			cur := beginning
 			for cur.follow != nil {
 				fmt.Printf("%v ", cur.move)
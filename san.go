@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// every move that does not leave the mover's king capturable
+func (p Position) LegalMoves() []Move {
+	moves := p.GenerateMoves(make([]Move, 0, 40), AllMoves)
+	legal := moves[:0]
+	for _, move := range moves {
+		if p.ProcessMove(move).LegalAfter(move) {
+			legal = append(legal, move)
+		}
+	}
+	return legal
+}
+
+func (p Position) IsLegal(m Move) bool {
+	for _, move := range p.LegalMoves() {
+		if move == m {
+			return true
+		}
+	}
+	return false
+}
+
+// converts a legal move to Standard Algebraic Notation, e.g. "Nbd7", "exd8=Q+" or "O-O-O#"
+func (p Position) MoveToSAN(m Move) string {
+	var san string
+	piece := p.board[m.from]
+	switch {
+	case m.castle == HSide:
+		san = "O-O"
+	case m.castle == ASide:
+		san = "O-O-O"
+	case piece.Type() == Pawn:
+		if m.capture {
+			san = m.from.File().String() + "x"
+		}
+		san += m.to.String()
+		if m.promote != NoPieceType {
+			san += "=" + strings.ToUpper(m.promote.String())
+		}
+	default:
+		san = strings.ToUpper(piece.Type().String())
+		// disambiguate between pieces of the same type that can reach the same square
+		ambiguous, sameFile, sameRank := false, false, false
+		for _, move := range p.LegalMoves() {
+			if move.to != m.to || move.from == m.from || move.castle != NoCastle || p.board[move.from] != piece {
+				continue
+			}
+			ambiguous = true
+			if move.from.File() == m.from.File() {
+				sameFile = true
+			}
+			if move.from.Rank() == m.from.Rank() {
+				sameRank = true
+			}
+		}
+		if ambiguous {
+			if !sameFile {
+				san += m.from.File().String()
+			} else if !sameRank {
+				san += m.from.Rank().String()
+			} else {
+				san += m.from.String()
+			}
+		}
+		if m.capture {
+			san += "x"
+		}
+		san += m.to.String()
+	}
+	after := p.ProcessMove(m)
+	if after.InCheck() {
+		if len(after.LegalMoves()) == 0 {
+			san += "#"
+		} else {
+			san += "+"
+		}
+	}
+	return san
+}
+
+// converts Standard Algebraic Notation to a legal move, also accepting common sloppy
+// variants: missing or extra capture marks and check suffixes, "0-0", "e8Q", lowercase
+// piece letters, over-specified origins ("Ng1f3", "e2-e4") and coordinate notation ("e2e4")
+func (p Position) ParseSAN(san string) (Move, error) {
+	s := strings.TrimSuffix(strings.TrimSpace(san), "e.p.")
+	s = strings.TrimRight(strings.TrimSpace(s), "+#!?")
+	s = strings.ReplaceAll(s, "-", "")
+	s = strings.ReplaceAll(s, "x", "")
+	s = strings.ReplaceAll(s, ":", "")
+	s = strings.ReplaceAll(s, "=", "")
+	if s == "" {
+		return NoMove, errors.New("empty move")
+	}
+	legalMoves := p.LegalMoves()
+
+	switch strings.ToUpper(s) {
+	case "OO", "00":
+		return findCastle(legalMoves, HSide, san)
+	case "OOO", "000":
+		return findCastle(legalMoves, ASide, san)
+	}
+
+	// promotion piece at the end
+	promote := NoPieceType
+	if len(s) > 2 {
+		last := strings.ToLower(s[len(s)-1:])
+		if pt := StringToPieceType(last); pt != NoPieceType && pt != Pawn && pt != King && isRank(s[len(s)-2]) {
+			promote = pt
+			s = s[:len(s)-1]
+		}
+	}
+	if len(s) < 2 || !isFile(s[len(s)-2]) || !isRank(s[len(s)-1]) {
+		return NoMove, fmt.Errorf("invalid move %q", san)
+	}
+	to := StringToSquare(s[len(s)-2:])
+	s = s[:len(s)-2]
+
+	// moving piece, then whatever is left of the origin square
+	type reading struct {
+		pieceType PieceType // NoPieceType for any piece
+		origin    string
+	}
+	readings := []reading{{Pawn, s}}
+	if len(s) == 2 && isFile(s[0]) && isRank(s[1]) {
+		// coordinate notation names the origin square instead of the piece
+		readings[0].pieceType = NoPieceType
+	} else if s != "" {
+		switch pt := StringToPieceType(strings.ToLower(s[:1])); {
+		case s[0] == 'b':
+			// "b" is the b-file unless no pawn move matches
+			readings = append(readings, reading{Bishop, s[1:]})
+		case pt != NoPieceType && pt != Pawn:
+			readings = []reading{{pt, s[1:]}}
+		}
+	}
+
+	for _, r := range readings {
+		fromFile, fromRank := -1, -1
+		for _, c := range []byte(r.origin) {
+			switch {
+			case isFile(c):
+				fromFile = int(c - 'a')
+			case isRank(c):
+				fromRank = int(c - '1')
+			default:
+				return NoMove, fmt.Errorf("invalid move %q", san)
+			}
+		}
+		var matches []Move
+		for _, move := range legalMoves {
+			if r.pieceType != NoPieceType && p.board[move.from].Type() != r.pieceType {
+				continue
+			}
+			if move.promote != promote {
+				continue
+			}
+			if fromFile >= 0 && int(move.from.File()) != fromFile {
+				continue
+			}
+			if fromRank >= 0 && int(move.from.Rank()) != fromRank {
+				continue
+			}
+			if move.castle == NoCastle {
+				if move.to == to {
+					matches = append(matches, move)
+				}
+				continue
+			}
+			// king moved onto its own rook, or to its castling square
+			if r.pieceType == Pawn {
+				continue
+			}
+			kingSquare, _ := GetCastleSquares(move.from, move.to)
+			if move.to == to || (kingSquare == to && !isKingStep(move.from, to)) {
+				matches = append(matches, move)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			continue
+		case 1:
+			return matches[0], nil
+		default:
+			return NoMove, fmt.Errorf("ambiguous move %q", san)
+		}
+	}
+	return NoMove, fmt.Errorf("illegal move %q", san)
+}
+
+func findCastle(legalMoves []Move, direction CastleDirection, san string) (Move, error) {
+	for _, move := range legalMoves {
+		if move.castle == direction {
+			return move, nil
+		}
+	}
+	return NoMove, fmt.Errorf("illegal move %q", san)
+}
+
+func isKingStep(from, to Square) bool {
+	files, ranks := Diff(from, to)
+	return files <= 1 && ranks <= 1
+}
+
+func isFile(c byte) bool {
+	return c >= 'a' && c <= 'h'
+}
+
+func isRank(c byte) bool {
+	return c >= '1' && c <= '8'
+}
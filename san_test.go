@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMoveToSAN(t *testing.T) {
+	tests := []struct {
+		fen  string
+		move string
+		san  string
+	}{
+		{"startpos", "e2e4", "e4"},
+		{"startpos", "g1f3", "Nf3"},
+		{"4k3/8/8/8/8/8/4K3/R6R w - - 0 1", "a1d1", "Rad1"},
+		{"4k3/8/8/8/8/8/4K3/R6R w - - 0 1", "h1d1", "Rhd1"},
+		{"4k3/R7/8/8/8/8/4K3/R7 w - - 0 1", "a1a4", "R1a4"},
+		{"4k3/R7/8/8/8/8/4K3/R7 w - - 0 1", "a7a4", "R7a4"},
+		{"7k/8/8/8/Q1Q5/8/Q7/7K w - - 0 1", "a4c2", "Qa4c2"},
+		{"7k/8/8/8/Q1Q5/8/Q7/7K w - - 0 1", "a4b5", "Qab5"},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1g1", "O-O"},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1c1", "O-O-O"},
+		{"r3k2r/8/8/8/8/8/8/R3K2R b KQkq - 0 1", "e8g8", "O-O"},
+		{"r3k2r/8/8/8/8/8/8/1R2K1R1 w GBkq - 0 1", "e1g1", "O-O"},
+		{"r3k2r/8/8/8/8/8/8/1R2K1R1 w GBkq - 0 1", "e1b1", "O-O-O"},
+		{"3r4/4P3/8/8/8/8/k7/4K3 w - - 0 1", "e7d8q", "exd8=Q"},
+		{"3r4/4P3/8/8/8/8/k7/4K3 w - - 0 1", "e7e8n", "e8=N"},
+		{"rnbqkbnr/pppp1ppp/8/4p3/5PP1/8/PPPPP2P/RNBQKBNR b KQkq - 0 2", "d8h4", "Qh4#"},
+		{"rnbqkbnr/ppp1pppp/8/3p4/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2", "f1b5", "Bb5+"},
+		{"rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPP1PPP/RNBQKBNR w KQkq f6 0 3", "e5f6", "exf6"},
+	}
+	for _, test := range tests {
+		pos := LoadInitialPosition(test.fen)
+		move := pos.StringToMove(test.move)
+		if san := pos.MoveToSAN(move); san != test.san {
+			t.Errorf("%s: MoveToSAN(%s) = %q; want %q", test.fen, test.move, san, test.san)
+		}
+	}
+}
+
+func TestParseSAN(t *testing.T) {
+	tests := []struct {
+		fen  string
+		san  string
+		move string // empty if the move should be rejected
+	}{
+		{"startpos", "e4", "e2e4"},
+		{"startpos", "Nf3", "g1f3"},
+		{"startpos", "nf3", "g1f3"},
+		{"startpos", "Ng1f3", "g1f3"},
+		{"startpos", "Ng1-f3", "g1f3"},
+		{"startpos", "e2-e4", "e2e4"},
+		{"startpos", "e2e4", "e2e4"},
+		{"startpos", "Nf3!?", "g1f3"},
+		{"startpos", "e5", ""},
+		{"startpos", "Nd2", ""},
+		{"startpos", "Qh5", ""},
+		{"startpos", "O-O", ""},
+		{"4k3/8/8/8/8/8/4K3/R6R w - - 0 1", "Rd1", ""},
+		{"4k3/8/8/8/8/8/4K3/R6R w - - 0 1", "Rhd1", "h1d1"},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "0-0", "e1h1"},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "O-O-O+", "e1a1"},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "Kg1", "e1h1"},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1g1", "e1h1"},
+		{"r3k2r/8/8/8/8/8/8/1R2K1R1 w GBkq - 0 1", "O-O", "e1g1"},
+		{"r3k2r/8/8/8/8/8/8/1R2K1R1 w GBkq - 0 1", "Kb1", "e1b1"},
+		{"bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9", "O-O", ""},
+		{"3r4/4P3/8/8/8/8/k7/4K3 w - - 0 1", "exd8=Q", "e7d8q"},
+		{"3r4/4P3/8/8/8/8/k7/4K3 w - - 0 1", "ed8Q", "e7d8q"},
+		{"3r4/4P3/8/8/8/8/k7/4K3 w - - 0 1", "e7e8n", "e7e8n"},
+		{"3r4/4P3/8/8/8/8/k7/4K3 w - - 0 1", "e8", ""},
+		{"rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPP1PPP/RNBQKBNR w KQkq f6 0 3", "exf6 e.p.", "e5f6"},
+		{"4k3/8/8/8/8/2n5/1P6/4KB2 w - - 0 1", "bxc3", "b2c3"},
+		{"4k3/8/8/8/8/2n5/8/B3K3 w - - 0 1", "bxc3", "a1c3"},
+	}
+	for _, test := range tests {
+		pos := LoadInitialPosition(test.fen)
+		move, err := pos.ParseSAN(test.san)
+		if test.move == "" {
+			if err == nil {
+				t.Errorf("%s: ParseSAN(%q) = %v; want error", test.fen, test.san, move)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: ParseSAN(%q) error: %v", test.fen, test.san, err)
+			continue
+		}
+		if want := pos.StringToMove(test.move); move != want {
+			t.Errorf("%s: ParseSAN(%q) = %v; want %v", test.fen, test.san, move, want)
+		}
+	}
+}
+
+func TestSANRoundTrip(t *testing.T) {
+	f, err := os.Open("testdata/perft.epd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	epds, err := ReadEPDs(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range epds {
+		pos := LoadInitialPosition(e.fen)
+		for _, move := range pos.LegalMoves() {
+			san := pos.MoveToSAN(move)
+			parsed, err := pos.ParseSAN(san)
+			if err != nil || parsed != move {
+				t.Errorf("%s: ParseSAN(MoveToSAN(%v) = %q) = %v, %v", e.fen, move, san, parsed, err)
+			}
+		}
+	}
+}
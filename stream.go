@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Lichess sends an empty line every few seconds to keep streams alive
+const streamStallTimeout = 30 * time.Second
+
+var errStreamStalled = errors.New("stream stalled")
+
+// an error after which following a stream is pointless, e.g. the game does not exist
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Backoff is the wait before reconnecting, doubling after every failed attempt in a row
+type Backoff struct {
+	Min, Max time.Duration
+	next     time.Duration
+}
+
+var defaultBackoff = Backoff{Min: time.Second, Max: time.Minute}
+
+func (b *Backoff) Next() time.Duration {
+	if b.next < b.Min {
+		b.next = b.Min
+	}
+	d := b.next
+	if b.next *= 2; b.next > b.Max {
+		b.next = b.Max
+	}
+	return d
+}
+
+func (b *Backoff) Reset() {
+	b.next = b.Min
+}
+
+// passes each ndjson line of r to handle until it reports that it is done or the stream ends,
+// skipping keep-alive lines. Lines split across reads are joined, a line the stream ends in
+// the middle of is dropped, and a stream that sends nothing for the stall timeout is closed
+// and reported as stalled
+func readStream(r io.ReadCloser, stall time.Duration, handle func(line []byte) bool) (done bool, err error) {
+	var stalled int32
+	timer := time.AfterFunc(stall, func() {
+		atomic.StoreInt32(&stalled, 1)
+		r.Close()
+	})
+	defer timer.Stop()
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		// handling a line, e.g. thinking about a move, does not count as a stall
+		timer.Stop()
+		// a line cut off by a dropped connection is incomplete
+		if line = bytes.TrimSpace(line); err == nil && len(line) > 0 && handle(line) {
+			return true, nil
+		}
+		timer.Reset(stall)
+		if err != nil {
+			if atomic.LoadInt32(&stalled) == 1 {
+				return false, errStreamStalled
+			}
+			return false, err
+		}
+	}
+}
+
+// keeps reading a stream, reconnecting with backoff whenever it fails or ends, until handle
+// reports that it is done or the connection fails permanently
+func followStream(connect func() (io.ReadCloser, error), stall time.Duration, retry Backoff, handle func(line []byte) bool) error {
+	for {
+		body, err := connect()
+		if err == nil {
+			var done bool
+			done, err = readStream(body, stall, func(line []byte) bool {
+				retry.Reset()
+				return handle(line)
+			})
+			body.Close()
+			if done {
+				return nil
+			}
+		}
+		var permanent permanentError
+		if errors.As(err, &permanent) {
+			return err
+		}
+		wait := retry.Next()
+		warnf("Stream interrupted (%v), reconnecting in %v", err, wait)
+		time.Sleep(wait)
+	}
+}
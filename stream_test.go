@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// a server whose handler gets the number of the connection, starting at 1
+func streamServer(t *testing.T, handler func(n int, w http.ResponseWriter, r *http.Request)) *httptest.Server {
+	n := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		handler(n, w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// writes the chunks with a flush after each, so the client reads them separately
+func writeChunks(w http.ResponseWriter, chunks ...string) {
+	for _, chunk := range chunks {
+		io.WriteString(w, chunk)
+		w.(http.Flusher).Flush()
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func connectTo(url string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		resp, err := http.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			return nil, permanentError{errors.New(resp.Status)}
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, errors.New(resp.Status)
+		}
+		return resp.Body, nil
+	}
+}
+
+func TestReadStream(t *testing.T) {
+	s := streamServer(t, func(n int, w http.ResponseWriter, r *http.Request) {
+		writeChunks(w, `{"type":"gam`, `eStart"}`+"\n", "\n", "\n", `{"type":"challenge"}`+"\n{\"type\":", `"gameFinish"}`+"\n\n")
+	})
+	body, err := connectTo(s.URL)()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	var lines []string
+	done, err := readStream(body, time.Second, func(line []byte) bool {
+		lines = append(lines, string(line))
+		return false
+	})
+	want := `[{"type":"gameStart"} {"type":"challenge"} {"type":"gameFinish"}]`
+	if done || err != io.EOF || fmt.Sprint(lines) != want {
+		t.Errorf("readStream() = %v, %v, lines %v; want false, EOF, %v", done, err, lines, want)
+	}
+}
+
+func TestReadStreamStall(t *testing.T) {
+	s := streamServer(t, func(n int, w http.ResponseWriter, r *http.Request) {
+		// keep-alives reset the stall timer
+		writeChunks(w, "{}\n", "\n")
+		time.Sleep(30 * time.Millisecond)
+		writeChunks(w, "\n")
+		time.Sleep(30 * time.Millisecond)
+		writeChunks(w, "{}\n")
+		<-r.Context().Done()
+	})
+	body, err := connectTo(s.URL)()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := 0
+	start := time.Now()
+	done, err := readStream(body, 50*time.Millisecond, func(line []byte) bool {
+		lines++
+		return false
+	})
+	if done || err != errStreamStalled || lines != 2 {
+		t.Errorf("readStream() = %v, %v after %d lines; want false, %v after 2", done, err, lines, errStreamStalled)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("readStream() took %v to notice the stall", elapsed)
+	}
+}
+
+func TestFollowStream(t *testing.T) {
+	s := streamServer(t, func(n int, w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 1:
+			// the connection drops in the middle of a line
+			writeChunks(w, `{"n":1}`+"\n", `{"n":`)
+		case 2:
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+		case 3:
+			writeChunks(w, "\n", `{"n":2}`+"\n")
+			<-r.Context().Done() // stalls
+		default:
+			writeChunks(w, `{"n":3}`+"\n", `{"n":4}`+"\n")
+		}
+	})
+	var lines []string
+	err := followStream(connectTo(s.URL), 50*time.Millisecond, Backoff{Min: time.Millisecond, Max: 5 * time.Millisecond}, func(line []byte) bool {
+		lines = append(lines, string(line))
+		return len(lines) == 3
+	})
+	if want := `[{"n":1} {"n":2} {"n":3}]`; err != nil || fmt.Sprint(lines) != want {
+		t.Errorf("followStream() = %v, lines %v; want nil, %v", err, lines, want)
+	}
+
+	gone := streamServer(t, func(n int, w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	err = followStream(connectTo(gone.URL), time.Second, Backoff{Min: time.Millisecond, Max: time.Millisecond}, func(line []byte) bool {
+		return false
+	})
+	var permanent permanentError
+	if !errors.As(err, &permanent) {
+		t.Errorf("followStream() of a missing stream = %v; want a permanent error", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Min: time.Second, Max: 5 * time.Second}
+	var waits []time.Duration
+	for i := 0; i < 5; i++ {
+		waits = append(waits, b.Next())
+	}
+	b.Reset()
+	waits = append(waits, b.Next())
+	if want := "[1s 2s 4s 5s 5s 1s]"; fmt.Sprint(waits) != want {
+		t.Errorf("Next() = %v; want %v", waits, want)
+	}
+}
@@ -0,0 +1,1052 @@
+package main
+
+// Syzygy tablebase probing, ported from Fathom (https://github.com/jdart1/Fathom), which is
+// MIT licensed: Copyright (c) 2015 basil00, Modifications Copyright (c) 2016-2019 by Jon Dart.
+// Fathom numbers squares from a1 = 0 along ranks, so squares are converted on the way in.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// scores a tablebase win, beyond any material balance but short of a mate score
+const tablebaseWinValue = 20000
+
+const tbMaxPieces = 7
+
+// kinds of table
+const (
+	tbWDL = iota // win/draw/loss, .rtbw
+	tbDTZ        // distance to zeroing the fifty-move counter, .rtbz
+)
+
+var tbSuffix = [2]string{".rtbw", ".rtbz"}
+var tbMagic = [2]uint32{0x5d23e871, 0xa50c66d7}
+
+// outcomes of probing a single table
+type tbStatus int
+
+const (
+	tbFailed    tbStatus = iota
+	tbOK                 // the value is known
+	tbWrongSide          // the DTZ table only holds the other side to move
+	tbCapture            // probeWDL: the best move is a capture or an en passant capture
+)
+
+var tbWdlToDtz = [5]int{-1, -101, 0, 101, 1}
+var tbWdlToMap = [5]int{1, 3, 0, 2, 0}
+var tbPAFlags = [5]uint8{8, 0, 0, 0, 4}
+
+var (
+	tbBinomial       [7][64]uint64 // tbBinomial[k][n] is n choose k
+	tbPawnIdx        [6][24]uint64
+	tbPawnFactorFile [6][4]uint64
+)
+
+func init() {
+	for i := 0; i < 7; i++ {
+		for j := 0; j < 64; j++ {
+			f, l := uint64(1), uint64(1)
+			for k := 0; k < i; k++ {
+				f *= uint64(j - k)
+				l *= uint64(k + 1)
+			}
+			tbBinomial[i][j] = f / l
+		}
+	}
+	for i := 0; i < 6; i++ {
+		var s uint64
+		for j := 0; j < 24; j++ {
+			tbPawnIdx[i][j] = s
+			s += tbBinomial[i][tbPawnTwist[(1+j%6)*8+j/6]]
+			if (j+1)%6 == 0 {
+				tbPawnFactorFile[i][j/6] = s
+				s = 0
+			}
+		}
+	}
+}
+
+// Tablebase probes the Syzygy tables found in one or more directories: WDL tables give the
+// result of a position, DTZ tables the distance to the next capture or pawn move
+type Tablebase struct {
+	dirs      []string
+	maxPieces int
+	mu        sync.Mutex
+	entries   map[string]*tbEntry // by material, e.g. "KRPvKR"
+}
+
+// a material combination with its lazily loaded tables
+type tbEntry struct {
+	name      string
+	num       int // pieces, kings included
+	symmetric bool
+	hasPawns  bool
+	kkEnc     bool   // the kings are the only unique pieces
+	pawns     [2]int // leading colour's pawns first
+	hasDTZ    bool
+	tables    [2]*tbTable
+	failed    [2]bool
+}
+
+type tbTable struct {
+	data     []byte
+	ei       []tbEncInfo // WDL: [leading file + tables*side]; DTZ: [leading file]
+	dtzFlags [4]uint8
+	dtzMap   [4][4]int // offsets of the DTZ value maps
+}
+
+type tbEncInfo struct {
+	precomp *tbPairs
+	factor  [tbMaxPieces]uint64
+	pieces  [tbMaxPieces]uint8 // 1-6 white pawn to king, 9-14 black
+	norm    [tbMaxPieces]uint8
+}
+
+// a block-compressed table of values; offsets are into the table's file
+type tbPairs struct {
+	indexTable, sizeTable, data int
+	offset, symPat              int
+	symLen                      []uint8
+	base                        []uint64
+	blockSize, idxBits, minLen  uint8
+	constValue                  [2]uint8
+}
+
+// opens the tables in path, a list of directories separated as in $PATH
+func OpenTablebase(path string) (*Tablebase, error) {
+	tb := &Tablebase{entries: map[string]*tbEntry{}}
+	for _, dir := range filepath.SplitList(path) {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		tb.dirs = append(tb.dirs, dir)
+		for _, f := range files {
+			name := strings.TrimSuffix(f.Name(), tbSuffix[tbWDL])
+			if name == f.Name() || tb.entries[name] != nil {
+				continue
+			}
+			e, err := newTBEntry(name)
+			if err != nil {
+				continue
+			}
+			info, err := f.Info()
+			if err != nil {
+				return nil, err
+			}
+			// files are padded to 16 bytes past a multiple of 64
+			if info.Size()&63 != 16 {
+				return nil, fmt.Errorf("incomplete tablebase file %s", f.Name())
+			}
+			tb.entries[name] = e
+			if e.num > tb.maxPieces {
+				tb.maxPieces = e.num
+			}
+		}
+	}
+	if len(tb.entries) == 0 {
+		return nil, fmt.Errorf("no Syzygy tables in %s", path)
+	}
+	for name, e := range tb.entries {
+		_, err := tb.find(name + tbSuffix[tbDTZ])
+		e.hasDTZ = err == nil
+	}
+	return tb, nil
+}
+
+// the largest number of pieces, kings included, that the tables cover
+func (tb *Tablebase) MaxPieces() int {
+	return tb.maxPieces
+}
+
+func (tb *Tablebase) find(filename string) (string, error) {
+	for _, dir := range tb.dirs {
+		path := filepath.Join(dir, filename)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found", filename)
+}
+
+func newTBEntry(name string) (*tbEntry, error) {
+	sides := strings.Split(name, "v")
+	if len(sides) != 2 || len(name) > tbMaxPieces+1 {
+		return nil, fmt.Errorf("%s is not a table name", name)
+	}
+	var counts [2][7]int
+	for c, side := range sides {
+		if !strings.HasPrefix(side, "K") || strings.Count(side, "K") != 1 {
+			return nil, fmt.Errorf("%s is not a table name", name)
+		}
+		for _, r := range side {
+			pt := StringToPieceType(strings.ToLower(string(r)))
+			if pt == NoPieceType {
+				return nil, fmt.Errorf("%s is not a table name", name)
+			}
+			counts[c][pt]++
+		}
+	}
+	e := &tbEntry{name: name, num: len(name) - 1, symmetric: sides[0] == sides[1]}
+	e.hasPawns = counts[0][Pawn] > 0 || counts[1][Pawn] > 0
+	if e.hasPawns {
+		e.pawns = [2]int{counts[0][Pawn], counts[1][Pawn]}
+		if e.pawns[1] > 0 && (e.pawns[0] == 0 || e.pawns[0] > e.pawns[1]) {
+			e.pawns[0], e.pawns[1] = e.pawns[1], e.pawns[0]
+		}
+	} else {
+		unique := 0
+		for c := range counts {
+			for _, n := range counts[c] {
+				if n == 1 {
+					unique++
+				}
+			}
+		}
+		e.kkEnc = unique == 2
+	}
+	return e, nil
+}
+
+// the table's pieces of one colour, e.g. "KRP"
+func tbMaterial(p Position, c PieceColour) string {
+	var mc materialCount
+	for _, square := range Squares {
+		if piece := p.board[square]; piece != NoPiece {
+			mc.add(piece)
+		}
+	}
+	return materialSignature(mc.of(c))
+}
+
+// tables cannot hold positions where castling is still possible
+func (p Position) hasCastlingRights() bool {
+	for i, rook := range p.rookSquares {
+		if rook != NoSquare && !p.kingMoved(PieceColour(i < 2)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (tb *Tablebase) covers(p Position) bool {
+	pieces := 0
+	for _, square := range Squares {
+		if p.board[square] != NoPiece {
+			pieces++
+		}
+	}
+	return pieces <= tb.maxPieces && !p.hasCastlingRights()
+}
+
+func (tb *Tablebase) load(e *tbEntry, kind int) *tbTable {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if e.tables[kind] == nil && !e.failed[kind] {
+		t, err := tb.readTable(e, kind)
+		if err != nil {
+			warnf("Could not load tablebase: %v", err)
+			e.failed[kind] = true
+		}
+		e.tables[kind] = t
+	}
+	return e.tables[kind]
+}
+
+func (tb *Tablebase) readTable(e *tbEntry, kind int) (*tbTable, error) {
+	path, err := tb.find(e.name + tbSuffix[kind])
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 5 || binary.LittleEndian.Uint32(data) != tbMagic[kind] {
+		return nil, fmt.Errorf("%s is corrupted", path)
+	}
+	// WDL tables may hold both sides to move
+	split := kind == tbWDL && data[4]&1 != 0
+	num, enc := 1, pieceEncoding
+	if e.hasPawns {
+		num, enc = 4, fileEncoding
+	}
+	t := &tbTable{data: data, ei: make([]tbEncInfo, num)}
+	if kind == tbWDL {
+		t.ei = make([]tbEncInfo, 2*num)
+	}
+	pos := 5
+	var tbSize [4][2]uint64
+	for i := 0; i < num; i++ {
+		tbSize[i][0] = e.initEncInfo(&t.ei[i], data[pos:], 0, i, enc)
+		if split {
+			tbSize[i][1] = e.initEncInfo(&t.ei[num+i], data[pos:], 4, i, enc)
+		}
+		pos += e.num + 1
+		if e.hasPawns && e.pawns[1] > 0 {
+			pos++
+		}
+	}
+	pos += pos & 1
+
+	var sizes [4][2][3]int
+	for i := 0; i < num; i++ {
+		var flags uint8
+		t.ei[i].precomp, pos, flags = setupPairs(data, pos, tbSize[i][0], &sizes[i][0], kind)
+		if kind == tbDTZ {
+			t.dtzFlags[i] = flags
+		}
+		if split {
+			t.ei[num+i].precomp, pos, _ = setupPairs(data, pos, tbSize[i][1], &sizes[i][1], kind)
+		}
+	}
+	if kind == tbDTZ {
+		for i := 0; i < num; i++ {
+			if t.dtzFlags[i]&2 == 0 {
+				continue
+			}
+			// a map for each result, of bytes or of 16-bit values
+			if t.dtzFlags[i]&16 == 0 {
+				for j := 0; j < 4; j++ {
+					t.dtzMap[i][j] = pos + 1
+					pos += 1 + int(data[pos])
+				}
+			} else {
+				pos += pos & 1
+				for j := 0; j < 4; j++ {
+					t.dtzMap[i][j] = pos + 2
+					pos += 2 + 2*int(binary.LittleEndian.Uint16(data[pos:]))
+				}
+			}
+		}
+		pos += pos & 1
+	}
+	// the index tables, then the size tables, then the 64-byte aligned compressed data
+	for part := 0; part < 3; part++ {
+		for i := 0; i < num; i++ {
+			for side := 0; side*num < len(t.ei); side++ {
+				d := t.ei[side*num+i].precomp
+				if d == nil {
+					continue
+				}
+				switch part {
+				case 0:
+					d.indexTable = pos
+				case 1:
+					d.sizeTable = pos
+				case 2:
+					pos = (pos + 0x3f) &^ 0x3f
+					d.data = pos
+				}
+				pos += sizes[i][side][part]
+			}
+		}
+	}
+	if pos > len(data) {
+		return nil, fmt.Errorf("%s is truncated", path)
+	}
+	return t, nil
+}
+
+// reads the piece order of one table from its header and returns the number of positions
+func (e *tbEntry) initEncInfo(ei *tbEncInfo, header []byte, shift uint, file int, enc int) uint64 {
+	morePawns := 0
+	if enc != pieceEncoding && e.pawns[1] > 0 {
+		morePawns = 1
+	}
+	for i := 0; i < e.num; i++ {
+		ei.pieces[i] = header[i+1+morePawns] >> shift & 0x0f
+		ei.norm[i] = 0
+	}
+	order := int(header[0] >> shift & 0x0f)
+	order2 := 0x0f
+	if morePawns == 1 {
+		order2 = int(header[1] >> shift & 0x0f)
+	}
+	k := 3
+	switch {
+	case enc != pieceEncoding:
+		k = e.pawns[0]
+	case e.kkEnc:
+		k = 2
+	}
+	ei.norm[0] = uint8(k)
+	if morePawns == 1 {
+		ei.norm[k] = uint8(e.pawns[1])
+		k += e.pawns[1]
+	}
+	for i := k; i < e.num; i += int(ei.norm[i]) {
+		for j := i; j < e.num && ei.pieces[j] == ei.pieces[i]; j++ {
+			ei.norm[i]++
+		}
+	}
+	n := 64 - k
+	f := uint64(1)
+	for i := 0; k < e.num || i == order || i == order2; i++ {
+		switch i {
+		case order:
+			ei.factor[0] = f
+			switch {
+			case enc == fileEncoding:
+				f *= tbPawnFactorFile[ei.norm[0]-1][file]
+			case e.kkEnc:
+				f *= 462
+			default:
+				f *= 31332
+			}
+		case order2:
+			ei.factor[ei.norm[0]] = f
+			f *= subfactor(int(ei.norm[ei.norm[0]]), 48-int(ei.norm[0]))
+		default:
+			ei.factor[k] = f
+			f *= subfactor(int(ei.norm[k]), n)
+			n -= int(ei.norm[k])
+			k += int(ei.norm[k])
+		}
+	}
+	return f
+}
+
+// the number of placements of k like pieces on n squares
+func subfactor(k, n int) uint64 {
+	f, l := uint64(n), uint64(1)
+	for i := 1; i < k; i++ {
+		f *= uint64(n - i)
+		l *= uint64(i + 1)
+	}
+	return f / l
+}
+
+// reads the compression header at pos, returning the pairs data, the position after the
+// header and its flags; sizes receives the lengths of the index, size and data sections
+func setupPairs(data []byte, pos int, tbSize uint64, sizes *[3]int, kind int) (*tbPairs, int, uint8) {
+	d := data[pos:]
+	flags := d[0]
+	if flags&0x80 != 0 {
+		// every position has the same value
+		p := &tbPairs{}
+		if kind == tbWDL {
+			p.constValue[0] = d[1]
+		}
+		return p, pos + 2, flags
+	}
+	p := &tbPairs{blockSize: d[1], idxBits: d[2], minLen: d[9]}
+	realNumBlocks := int(binary.LittleEndian.Uint32(d[4:]))
+	numBlocks := realNumBlocks + int(d[3])
+	maxLen, minLen := int(d[8]), int(d[9])
+	h := maxLen - minLen + 1
+	numSyms := int(binary.LittleEndian.Uint16(d[10+2*h:]))
+	p.offset = pos + 10
+	p.symPat = pos + 12 + 2*h
+
+	numIndices := (tbSize + 1<<p.idxBits - 1) >> p.idxBits
+	sizes[0] = 6 * int(numIndices)
+	sizes[1] = 2 * numBlocks
+	sizes[2] = realNumBlocks << p.blockSize
+
+	p.symLen = make([]uint8, numSyms)
+	done := make([]bool, numSyms)
+	for s := range p.symLen {
+		if !done[s] {
+			p.calcSymLen(data, s, done)
+		}
+	}
+	p.base = make([]uint64, h)
+	offset := func(i int) uint64 {
+		return uint64(binary.LittleEndian.Uint16(data[p.offset+2*i:]))
+	}
+	for i := h - 2; i >= 0; i-- {
+		p.base[i] = (p.base[i+1] + offset(i) - offset(i+1)) / 2
+	}
+	for i := range p.base {
+		p.base[i] <<= uint(64 - (minLen + i))
+	}
+	return p, pos + 12 + 2*h + 3*numSyms + numSyms&1, flags
+}
+
+// a symbol is either a value or a pair of symbols; symLen is one less than the number of
+// values it expands to
+func (p *tbPairs) calcSymLen(data []byte, s int, done []bool) {
+	w := data[p.symPat+3*s:]
+	s2 := int(w[2])<<4 | int(w[1]>>4)
+	if s2 == 0x0fff {
+		p.symLen[s] = 0
+	} else {
+		s1 := int(w[1]&0xf)<<8 | int(w[0])
+		if !done[s1] {
+			p.calcSymLen(data, s1, done)
+		}
+		if !done[s2] {
+			p.calcSymLen(data, s2, done)
+		}
+		p.symLen[s] = p.symLen[s1] + p.symLen[s2] + 1
+	}
+	done[s] = true
+}
+
+// reads past the end of the file as zeros, as the final block's bit reader may
+func tbBigEndian(data []byte, pos, n int) uint64 {
+	var v uint64
+	for i := 0; i < n; i++ {
+		v <<= 8
+		if pos+i < len(data) {
+			v |= uint64(data[pos+i])
+		}
+	}
+	return v
+}
+
+// the value of position idx: the first byte, or two for DTZ tables
+func (p *tbPairs) decompress(data []byte, idx uint64) []byte {
+	if p.idxBits == 0 {
+		return p.constValue[:]
+	}
+	mainIdx := int(idx >> p.idxBits)
+	litIdx := int(idx&(1<<p.idxBits-1)) - 1<<(p.idxBits-1)
+	entry := p.indexTable + 6*mainIdx
+	block := int(binary.LittleEndian.Uint32(data[entry:]))
+	litIdx += int(binary.LittleEndian.Uint16(data[entry+4:]))
+	blockValues := func(b int) int {
+		return int(binary.LittleEndian.Uint16(data[p.sizeTable+2*b:])) + 1
+	}
+	for litIdx < 0 {
+		block--
+		litIdx += blockValues(block)
+	}
+	for litIdx >= blockValues(block) {
+		litIdx -= blockValues(block)
+		block++
+	}
+
+	// canonical Huffman codes, longest first, read 32 bits at a time
+	ptr := p.data + block<<p.blockSize
+	code := tbBigEndian(data, ptr, 8)
+	ptr += 8
+	bitCnt := 0
+	minLen := int(p.minLen)
+	sym := 0
+	for {
+		l := minLen
+		for l-minLen < len(p.base)-1 && code < p.base[l-minLen] {
+			l++
+		}
+		sym = int(binary.LittleEndian.Uint16(data[p.offset+2*(l-minLen):])) + int((code-p.base[l-minLen])>>uint(64-l))
+		if litIdx < int(p.symLen[sym])+1 {
+			break
+		}
+		litIdx -= int(p.symLen[sym]) + 1
+		code <<= uint(l)
+		bitCnt += l
+		if bitCnt >= 32 {
+			bitCnt -= 32
+			code |= tbBigEndian(data, ptr, 4) << uint(bitCnt)
+			ptr += 4
+		}
+	}
+	// expand pairs until reaching the value
+	for p.symLen[sym] != 0 {
+		w := data[p.symPat+3*sym:]
+		s1 := int(w[1]&0xf)<<8 | int(w[0])
+		if litIdx < int(p.symLen[s1])+1 {
+			sym = s1
+		} else {
+			litIdx -= int(p.symLen[s1]) + 1
+			sym = int(w[2])<<4 | int(w[1]>>4)
+		}
+	}
+	return data[p.symPat+3*sym:]
+}
+
+// square encodings
+const (
+	pieceEncoding = iota
+	fileEncoding  // tables with pawns, split by the leading pawn's file
+)
+
+// appends the squares, numbered from a1 = 0, of the pieces of type pieces[i]
+func fillSquares(p Position, pieces []uint8, flip bool, mirror int, squares []int, i int) int {
+	colour := PieceColour(pieces[i] >= 8)
+	if flip {
+		colour = colour.Flip()
+	}
+	piece := CreatePiece(colour, PieceType(pieces[i]&7))
+	for sq := 0; sq < 64; sq++ {
+		if p.board[ToSquare(File(sq&7), Rank(sq>>3))] == piece {
+			squares[i] = sq ^ mirror
+			i++
+		}
+	}
+	return i
+}
+
+// moves the leading pawn first and returns the table it selects
+func (e *tbEntry) leadingPawn(p []int) int {
+	for i := 1; i < e.pawns[0]; i++ {
+		if tbFlap[p[0]] > tbFlap[p[i]] {
+			p[0], p[i] = p[i], p[0]
+		}
+	}
+	file := p[0] & 7
+	if file > 3 {
+		file = 7 - file
+	}
+	return file
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// the index of the position given by the piece squares p, which are reordered
+func (e *tbEntry) encode(p []int, ei *tbEncInfo, enc int) uint64 {
+	n := e.num
+	if p[0]&0x04 != 0 {
+		for i := 0; i < n; i++ {
+			p[i] ^= 0x07
+		}
+	}
+	var idx uint64
+	k := 0
+	if enc == pieceEncoding {
+		if p[0]&0x20 != 0 {
+			for i := 0; i < n; i++ {
+				p[i] ^= 0x38
+			}
+		}
+		for i := 0; i < n; i++ {
+			if tbOffDiag[p[i]] != 0 {
+				if tbOffDiag[p[i]] > 0 && (i < 2 || (i < 3 && !e.kkEnc)) {
+					for j := 0; j < n; j++ {
+						p[j] = int(tbFlipDiag[p[j]])
+					}
+				}
+				break
+			}
+		}
+		if e.kkEnc {
+			idx = uint64(tbKKIdx[tbTriangle[p[0]]][p[1]])
+			k = 2
+		} else {
+			s1 := boolToInt(p[1] > p[0])
+			s2 := boolToInt(p[2] > p[0]) + boolToInt(p[2] > p[1])
+			switch {
+			case tbOffDiag[p[0]] != 0:
+				idx = uint64(int(tbTriangle[p[0]])*63*62 + (p[1]-s1)*62 + (p[2] - s2))
+			case tbOffDiag[p[1]] != 0:
+				idx = uint64(6*63*62 + int(tbDiag[p[0]])*28*62 + int(tbLower[p[1]])*62 + p[2] - s2)
+			case tbOffDiag[p[2]] != 0:
+				idx = uint64(6*63*62 + 4*28*62 + int(tbDiag[p[0]])*7*28 + (int(tbDiag[p[1]])-s1)*28 + int(tbLower[p[2]]))
+			default:
+				idx = uint64(6*63*62 + 4*28*62 + 4*7*28 + int(tbDiag[p[0]])*7*6 + (int(tbDiag[p[1]])-s1)*6 + (int(tbDiag[p[2]]) - s2))
+			}
+			k = 3
+		}
+		idx *= ei.factor[0]
+	} else {
+		for i := 1; i < e.pawns[0]; i++ {
+			for j := i + 1; j < e.pawns[0]; j++ {
+				if tbPawnTwist[p[i]] < tbPawnTwist[p[j]] {
+					p[i], p[j] = p[j], p[i]
+				}
+			}
+		}
+		k = e.pawns[0]
+		idx = tbPawnIdx[k-1][tbFlap[p[0]]]
+		for i := 1; i < k; i++ {
+			idx += tbBinomial[k-i][tbPawnTwist[p[i]]]
+		}
+		idx *= ei.factor[0]
+		// pawns of the other colour
+		if e.pawns[1] > 0 {
+			t := k + e.pawns[1]
+			idx += encodeGroup(p, k, t, 8) * ei.factor[k]
+			k = t
+		}
+	}
+	for k < n {
+		t := k + int(ei.norm[k])
+		idx += encodeGroup(p, k, t, 0) * ei.factor[k]
+		k = t
+	}
+	return idx
+}
+
+// the index of the like pieces p[k:t] among the squares left by p[:k]
+func encodeGroup(p []int, k, t, skip int) uint64 {
+	sort.Ints(p[k:t])
+	var s uint64
+	for i := k; i < t; i++ {
+		skips := 0
+		for j := 0; j < k; j++ {
+			if p[i] > p[j] {
+				skips++
+			}
+		}
+		s += tbBinomial[i-k+1][p[i]-skips-skip]
+	}
+	return s
+}
+
+// looks up the position in its WDL or DTZ table; DTZ lookups need the WDL value
+func (tb *Tablebase) probeTable(p Position, wdl int, kind int) (int, tbStatus) {
+	white, black := tbMaterial(p, White), tbMaterial(p, Black)
+	if kind == tbWDL && white == "K" && black == "K" {
+		return 0, tbOK
+	}
+	e, flip := tb.entries[white+"v"+black], false
+	if e == nil {
+		e, flip = tb.entries[black+"v"+white], true
+	}
+	if e == nil || (kind == tbDTZ && !e.hasDTZ) {
+		return 0, tbFailed
+	}
+	t := tb.load(e, kind)
+	if t == nil {
+		return 0, tbFailed
+	}
+	// bside selects the table for the side to move, with the stronger side as white
+	var bside bool
+	if !e.symmetric {
+		bside = (p.turn == White) == flip
+	} else {
+		flip = p.turn != White
+	}
+
+	var squares [tbMaxPieces]int
+	var ei *tbEncInfo
+	var flags uint8
+	file := 0
+	var idx uint64
+	if !e.hasPawns {
+		if kind == tbDTZ {
+			flags = t.dtzFlags[0]
+			if (flags&1 != 0) != bside && !e.symmetric {
+				return 0, tbWrongSide
+			}
+			ei = &t.ei[0]
+		} else {
+			ei = &t.ei[boolToInt(bside)]
+		}
+		for i := 0; i < e.num; {
+			i = fillSquares(p, ei.pieces[:], flip, 0, squares[:], i)
+		}
+		idx = e.encode(squares[:e.num], ei, pieceEncoding)
+	} else {
+		mirror := 0
+		if flip {
+			mirror = 0x38
+		}
+		i := fillSquares(p, t.ei[0].pieces[:], flip, mirror, squares[:], 0)
+		file = e.leadingPawn(squares[:])
+		if kind == tbDTZ {
+			flags = t.dtzFlags[file]
+			if (flags&1 != 0) != bside && !e.symmetric {
+				return 0, tbWrongSide
+			}
+			ei = &t.ei[file]
+		} else {
+			ei = &t.ei[file+4*boolToInt(bside)]
+		}
+		for i < e.num {
+			i = fillSquares(p, ei.pieces[:], flip, mirror, squares[:], i)
+		}
+		idx = e.encode(squares[:e.num], ei, fileEncoding)
+	}
+	if ei.precomp == nil {
+		return 0, tbFailed
+	}
+
+	w := ei.precomp.decompress(t.data, idx)
+	if kind == tbWDL {
+		return int(w[0]) - 2, tbOK
+	}
+	v := int(w[0]) + int(w[1]&0x0f)<<8
+	if flags&2 != 0 {
+		offset := t.dtzMap[file][tbWdlToMap[wdl+2]]
+		if flags&16 == 0 {
+			v = int(t.data[offset+v])
+		} else {
+			v = int(binary.LittleEndian.Uint16(t.data[offset+2*v:]))
+		}
+	}
+	if flags&tbPAFlags[wdl+2] == 0 || wdl&1 != 0 {
+		v *= 2
+	}
+	return v, tbOK
+}
+
+func (p Position) isEnPassant(m Move) bool {
+	return m.to == p.enPassantSquare && p.board[m.from].Type() == Pawn && p.board[m.to] == NoPiece
+}
+
+func (p Position) isCheckmate() bool {
+	return p.InCheck() && len(p.LegalMoves()) == 0
+}
+
+// resets the fifty-move counter
+func (p Position) isZeroing(m Move) bool {
+	return m.capture || p.board[m.from].Type() == Pawn
+}
+
+// the WDL value after resolving captures, for positions without en passant captures
+func (tb *Tablebase) probeAB(p Position, alpha, beta int) (int, bool) {
+	for _, m := range p.GenerateMoves(make([]Move, 0, 16), Captures) {
+		child := p.ProcessMove(m)
+		if !child.LegalAfter(m) {
+			continue
+		}
+		v, ok := tb.probeAB(child, -beta, -alpha)
+		if !ok {
+			return 0, false
+		}
+		if -v > alpha {
+			if -v >= beta {
+				return -v, true
+			}
+			alpha = -v
+		}
+	}
+	v, status := tb.probeTable(p, 0, tbWDL)
+	if status != tbOK {
+		return 0, false
+	}
+	if alpha >= v {
+		return alpha, true
+	}
+	return v, true
+}
+
+// the WDL value for the side to move: 2 a win, 1 a win drawn by the fifty-move rule, 0 a
+// draw, -1 a loss drawn by the fifty-move rule and -2 a loss; the status is tbCapture when
+// a capture is the best move
+func (tb *Tablebase) probeWDL(p Position) (int, tbStatus) {
+	// the best capture, and any better en passant capture
+	bestCap, bestEp := -3, -3
+	for _, m := range p.GenerateMoves(make([]Move, 0, 16), Captures) {
+		child := p.ProcessMove(m)
+		if !child.LegalAfter(m) {
+			continue
+		}
+		v, ok := tb.probeAB(child, -2, -bestCap)
+		if !ok {
+			return 0, tbFailed
+		}
+		v = -v
+		if v > bestCap {
+			if v == 2 {
+				return 2, tbCapture
+			}
+			if !p.isEnPassant(m) {
+				bestCap = v
+			} else if v > bestEp {
+				bestEp = v
+			}
+		}
+	}
+	v, status := tb.probeTable(p, 0, tbWDL)
+	if status != tbOK {
+		return 0, tbFailed
+	}
+	if bestEp > bestCap {
+		if bestEp > v {
+			return bestEp, tbCapture
+		}
+		bestCap = bestEp
+	}
+	if bestCap >= v {
+		if bestCap > 0 {
+			return bestCap, tbCapture
+		}
+		return bestCap, tbOK
+	}
+	// the table treats the position as stalemate if only en passant captures are legal
+	if bestEp > -3 && v == 0 && !p.InCheck() {
+		stalemate := true
+		for _, m := range p.LegalMoves() {
+			if !p.isEnPassant(m) {
+				stalemate = false
+				break
+			}
+		}
+		if stalemate {
+			return bestEp, tbCapture
+		}
+	}
+	return v, tbOK
+}
+
+// the distance in half-moves to a capture or pawn move that keeps the result: positive
+// when winning, negative when losing, beyond 100 when the fifty-move rule draws, 0 when
+// drawn and -1 when mated; it can be one more than stated
+func (tb *Tablebase) probeDTZ(p Position) (int, bool) {
+	wdl, status := tb.probeWDL(p)
+	if status == tbFailed {
+		return 0, false
+	}
+	if wdl == 0 {
+		return 0, true
+	}
+	if status == tbCapture {
+		return tbWdlToDtz[wdl+2], true
+	}
+	moves := p.LegalMoves()
+	if wdl > 0 {
+		// a winning pawn move zeroes the counter at once
+		for _, m := range moves {
+			if p.board[m.from].Type() != Pawn || m.capture {
+				continue
+			}
+			v, status := tb.probeWDL(p.ProcessMove(m))
+			if status == tbFailed {
+				return 0, false
+			}
+			if -v == wdl {
+				return tbWdlToDtz[wdl+2], true
+			}
+		}
+	}
+	dtz, status := tb.probeTable(p, wdl, tbDTZ)
+	switch status {
+	case tbOK:
+		if wdl > 0 {
+			return tbWdlToDtz[wdl+2] + dtz, true
+		}
+		return tbWdlToDtz[wdl+2] - dtz, true
+	case tbFailed:
+		return 0, false
+	}
+	// the table holds the other side to move, so search a ply
+	best := tbWdlToDtz[wdl+2]
+	if wdl > 0 {
+		best = math.MaxInt32
+	}
+	for _, m := range moves {
+		if p.isZeroing(m) {
+			continue
+		}
+		child := p.ProcessMove(m)
+		v, ok := tb.probeDTZ(child)
+		if !ok {
+			return 0, false
+		}
+		v = -v
+		switch {
+		case v == 1 && child.isCheckmate():
+			best = 1
+		case wdl > 0:
+			if v > 0 && v+1 < best {
+				best = v + 1
+			}
+		default:
+			if v-1 < best {
+				best = v - 1
+			}
+		}
+	}
+	return best, true
+}
+
+// the WDL value of the position for the side to move, as in probeWDL
+func (tb *Tablebase) ProbeWDL(p Position) (int, bool) {
+	if !tb.covers(p) {
+		return 0, false
+	}
+	wdl, status := tb.probeWDL(p)
+	return wdl, status != tbFailed
+}
+
+// the DTZ value of the position for the side to move, as in probeDTZ
+func (tb *Tablebase) ProbeDTZ(p Position) (int, bool) {
+	if !tb.covers(p) {
+		return 0, false
+	}
+	return tb.probeDTZ(p)
+}
+
+// ranks the legal moves by DTZ and picks the one that wins soonest, or loses latest, given
+// the half-moves played since the last capture or pawn move; wdl is the result it keeps
+func (tb *Tablebase) RootMove(p Position, halfmoves int) (best Move, wdl int, ok bool) {
+	if !tb.covers(p) {
+		return NoMove, 0, false
+	}
+	bestRank, bestDTZ := math.MinInt32, 0
+	for _, m := range p.LegalMoves() {
+		child := p.ProcessMove(m)
+		v := 0
+		if p.isZeroing(m) {
+			w, status := tb.probeWDL(child)
+			if status == tbFailed {
+				return NoMove, 0, false
+			}
+			v = tbWdlToDtz[-w+2]
+		} else {
+			dtz, ok := tb.probeDTZ(child)
+			if !ok {
+				return NoMove, 0, false
+			}
+			v = -dtz
+			if v > 0 {
+				v++
+			} else if v < 0 {
+				v--
+			}
+		}
+		if v == 2 && child.isCheckmate() {
+			v = 1
+		}
+		// wins within the fifty-move rule rank equally, as do losses that cannot reach it
+		rank := 0
+		switch {
+		case v > 0 && v+halfmoves <= 99:
+			rank = 1000
+		case v > 0:
+			rank = 1000 - (v + halfmoves)
+		case v < 0 && -v*2+halfmoves < 100:
+			rank = -1000
+		case v < 0:
+			rank = -1000 + (-v + halfmoves)
+		}
+		// among equal ranks, win sooner or lose later
+		if rank > bestRank || (rank == bestRank && v < bestDTZ) {
+			best, bestRank, bestDTZ = m, rank, v
+		}
+	}
+	if best == NoMove {
+		return NoMove, 0, false
+	}
+	switch {
+	case bestRank >= 900:
+		wdl = 2
+	case bestRank > 0:
+		wdl = 1
+	case bestRank == 0:
+		wdl = 0
+	case bestRank > -900:
+		wdl = -1
+	default:
+		wdl = -2
+	}
+	return best, wdl, true
+}
+
+// the evaluation of a tablebase result for the side to move, found ply half-moves into a
+// search; wins drawn by the fifty-move rule barely count
+func tablebaseScore(wdl, ply int) int {
+	switch wdl {
+	case 2:
+		return tablebaseWinValue - ply
+	case -2:
+		return -tablebaseWinValue + ply
+	}
+	return wdl
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTablebaseDecompress(t *testing.T) {
+	// one block of eight values coded with "1" for the pair (4, 2), "00" for 4 and "01" for 2
+	data := make([]byte, 128)
+	copy(data, []byte{
+		0, 6, 6, 0, 1, 0, 0, 0, // flags, block size, index bits, blocks
+		2, 1, // longest and shortest code
+		2, 0, 0, 0, // first symbol of each code length
+		3, 0, // symbols
+		4, 0xf0, 0xff, 2, 0xf0, 0xff, 0, 0x10, 0,
+	})
+	var sizes [3]int
+	p, pos, _ := setupPairs(data, 0, 8, &sizes, tbWDL)
+	if pos != 26 || sizes != [3]int{6, 2, 64} {
+		t.Fatalf("setupPairs() = %d, %v; want 26, [6 2 64]", pos, sizes)
+	}
+	p.indexTable, p.sizeTable, p.data = 26, 32, 64
+	binary.LittleEndian.PutUint16(data[30:], 32)
+	binary.LittleEndian.PutUint16(data[32:], 7)
+	data[64] = 0xe8 // 1 1 1 01 00
+
+	for idx, want := range []byte{4, 2, 4, 2, 4, 2, 2, 4} {
+		if v := p.decompress(data, uint64(idx))[0]; v != want {
+			t.Errorf("decompress(%d) = %d; want %d", idx, v, want)
+		}
+	}
+}
+
+// writes a piece table whose positions share one value per side to move, white first
+func writeConstantTable(t *testing.T, filename string, kind int, pieces []byte, values ...byte) {
+	data := make([]byte, 80)
+	binary.LittleEndian.PutUint32(data, tbMagic[kind])
+	pos := 5
+	if len(values) == 2 {
+		data[4] = 1
+	}
+	pos++ // both sides' tables store the pieces in this order
+	for _, piece := range pieces {
+		data[pos] = piece | piece<<4
+		pos++
+	}
+	pos += pos & 1
+	for _, v := range values {
+		if kind == tbWDL {
+			data[pos], data[pos+1] = 0x80, v
+		} else {
+			data[pos] = 0x80 | v // which side the table holds
+		}
+		pos += 2
+	}
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// a KQvK tablebase that knows only that the queen wins
+func constantTablebase(t *testing.T) *Tablebase {
+	dir := t.TempDir()
+	kqk := []byte{6, 5, 14}
+	writeConstantTable(t, filepath.Join(dir, "KQvK.rtbw"), tbWDL, kqk, 4, 0)
+	writeConstantTable(t, filepath.Join(dir, "KQvK.rtbz"), tbDTZ, kqk, 0)
+	tb, err := OpenTablebase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tb
+}
+
+func TestTablebaseWDL(t *testing.T) {
+	tb := constantTablebase(t)
+	if tb.MaxPieces() != 3 {
+		t.Errorf("MaxPieces() = %d; want 3", tb.MaxPieces())
+	}
+	tests := []struct {
+		fen string
+		wdl int
+		ok  bool
+	}{
+		{"k7/8/1K6/8/8/8/7Q/8 w - - 0 1", 2, true},
+		{"k7/8/1K6/8/8/8/7Q/8 b - - 0 1", -2, true},
+		// the colours swapped
+		{"K7/8/1k6/8/8/8/7q/8 b - - 0 1", 2, true},
+		{"K7/8/1k6/8/8/8/7q/8 w - - 0 1", -2, true},
+		// taking the queen leaves bare kings
+		{"k7/1Q6/8/8/8/8/8/7K b - - 0 1", 0, true},
+		{"8/8/8/8/8/8/8/k6K w - - 0 1", 0, true},
+		{"k7/8/1K6/8/8/8/8/6RQ w - - 0 1", 0, false},
+		{"k7/8/1K6/8/8/8/8/7R w - - 0 1", 0, false},
+	}
+	for _, test := range tests {
+		wdl, ok := tb.ProbeWDL(LoadInitialPosition(test.fen))
+		if wdl != test.wdl || ok != test.ok {
+			t.Errorf("ProbeWDL(%q) = %d, %v; want %d, %v", test.fen, wdl, ok, test.wdl, test.ok)
+		}
+	}
+}
+
+func TestTablebaseRootMove(t *testing.T) {
+	tb := constantTablebase(t)
+	pos := LoadInitialPosition("k7/8/1K6/8/8/8/7Q/8 w - - 0 1")
+	m, wdl, ok := tb.RootMove(pos, 0)
+	if !ok || m.String() != "h2h8" || wdl != 2 {
+		t.Errorf("RootMove() = %v, %d, %v; want h2h8, 2, true", m, wdl, ok)
+	}
+	if dtz, ok := tb.ProbeDTZ(pos.ProcessMove(m)); !ok || dtz != -1 {
+		t.Errorf("ProbeDTZ() after mate = %d, %v; want -1, true", dtz, ok)
+	}
+	// near the fifty-move limit a win no longer counts, though mate still does
+	if _, wdl, _ := tb.RootMove(pos, 99); wdl != 2 {
+		t.Errorf("RootMove() with mate and 99 half-moves gives wdl %d; want 2", wdl)
+	}
+	if _, wdl, _ := tb.RootMove(LoadInitialPosition("k7/8/8/8/8/8/7Q/7K w - - 99 80"), 99); wdl != 1 {
+		t.Errorf("RootMove() with 99 half-moves gives wdl %d; want 1", wdl)
+	}
+
+	tree := &MoveTree{position: pos}
+	eval := ThinkUntil(tree, Limits{depth: 4, tablebase: tb}, nil)
+	if tree.follow == nil || tree.follow.move != m || eval != tablebaseWinValue {
+		t.Errorf("ThinkUntil() = %d, %v; want %d, h2h8", eval, tree.follow, tablebaseWinValue)
+	}
+}
+
+func TestSyzygyFiles(t *testing.T) {
+	// real tables, e.g. the 3-4-5 piece set, are too large to ship with the repository
+	path := os.Getenv("SYZYGY_PATH")
+	if path == "" {
+		t.Skip("SYZYGY_PATH is not set")
+	}
+	tb, err := OpenTablebase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		fen string
+		wdl int
+	}{
+		{"8/8/8/4k3/8/8/8/3QK3 w - - 0 1", 2},
+		{"8/8/8/4k3/8/8/8/3QK3 b - - 0 1", -2},
+		{"8/8/8/4k3/8/8/8/3RK3 b - - 0 1", -2},
+		{"8/8/8/4k3/8/8/8/2NNK3 w - - 0 1", 0},
+		{"8/8/8/4k3/8/8/8/2BNK3 b - - 0 1", -2},
+		{"8/8/8/4k3/8/8/8/2BBK3 b - - 0 1", -2},
+	}
+	for _, test := range tests {
+		wdl, ok := tb.ProbeWDL(LoadInitialPosition(test.fen))
+		if !ok || wdl != test.wdl {
+			t.Errorf("ProbeWDL(%q) = %d, %v; want %d, true", test.fen, wdl, ok, test.wdl)
+		}
+	}
+	pos := LoadInitialPosition("k7/8/1K6/8/8/8/7Q/8 w - - 0 1")
+	if m, wdl, ok := tb.RootMove(pos, 0); !ok || m.String() != "h2h8" || wdl != 2 {
+		t.Errorf("RootMove() = %v, %d, %v; want h2h8, 2, true", m, wdl, ok)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// tomlValue is a key and value of a TOML file, in the section of the last [section] header
+type tomlValue struct {
+	line         int
+	section, key string
+	value        interface{} // string, int, bool or []interface{}
+}
+
+// parses the subset of TOML config files need: [section] headers, key = value lines and
+// comments, with strings, whole numbers, booleans and arrays on one line as values
+func parseTOML(r io.Reader) ([]tomlValue, error) {
+	var values []tomlValue
+	seen := map[string]bool{}
+	section := ""
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		if text[0] == '[' {
+			end := strings.IndexByte(text, ']')
+			if end < 0 || !isComment(text[end+1:]) || !isBareKey(strings.TrimSpace(text[1:end])) {
+				return nil, fmt.Errorf("line %d: bad section header %s", line, text)
+			}
+			section = strings.TrimSpace(text[1:end])
+			continue
+		}
+		eq := strings.IndexByte(text, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", line)
+		}
+		key := strings.TrimSpace(text[:eq])
+		if !isBareKey(key) {
+			return nil, fmt.Errorf("line %d: bad key %q", line, key)
+		}
+		if seen[section+"."+key] {
+			return nil, fmt.Errorf("line %d: %s is set twice in [%s]", line, key, section)
+		}
+		seen[section+"."+key] = true
+		value, rest, err := parseTOMLValue(strings.TrimSpace(text[eq+1:]))
+		if err == nil && !isComment(rest) {
+			err = fmt.Errorf("unexpected %s after the value", strings.TrimSpace(rest))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		values = append(values, tomlValue{line, section, key, value})
+	}
+	return values, scanner.Err()
+}
+
+func isBareKey(s string) bool {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// whether the rest of a line is blank or a comment
+func isComment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || s[0] == '#'
+}
+
+// parses the value at the start of s, returning what follows it
+func parseTOMLValue(s string) (value interface{}, rest string, err error) {
+	switch {
+	case s == "":
+		return nil, "", fmt.Errorf("missing value")
+	case s[0] == '"':
+		// escapes as in Go, which covers TOML's
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				str, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return nil, "", fmt.Errorf("bad string %s", s[:i+1])
+				}
+				return str, s[i+1:], nil
+			}
+		}
+		return nil, "", fmt.Errorf("unterminated string %s", s)
+	case s[0] == '\'':
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return nil, "", fmt.Errorf("unterminated string %s", s)
+		}
+		return s[1 : end+1], s[end+2:], nil
+	case s[0] == '[':
+		items := []interface{}{}
+		s = strings.TrimSpace(s[1:])
+		for !strings.HasPrefix(s, "]") {
+			item, rest, err := parseTOMLValue(s)
+			if err != nil {
+				return nil, "", err
+			}
+			items = append(items, item)
+			s = strings.TrimSpace(rest)
+			if strings.HasPrefix(s, ",") {
+				s = strings.TrimSpace(s[1:])
+			} else if !strings.HasPrefix(s, "]") {
+				return nil, "", fmt.Errorf("expected , or ] in array")
+			}
+		}
+		return items, s[1:], nil
+	}
+	end := strings.IndexAny(s, " \t,]#")
+	if end < 0 {
+		end = len(s)
+	}
+	word := s[:end]
+	switch word {
+	case "true":
+		return true, s[end:], nil
+	case "false":
+		return false, s[end:], nil
+	}
+	n, err := strconv.Atoi(strings.ReplaceAll(word, "_", ""))
+	if err != nil {
+		return nil, "", fmt.Errorf("bad value %s", word)
+	}
+	return n, s[end:], nil
+}
+
+// a value as written in a TOML file
+func formatTOML(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = formatTOML(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return fmt.Sprint(value)
+}
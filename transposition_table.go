@@ -4,17 +4,26 @@ import "fmt"
 
 const TTMaxSize = 10000000
 
+// searches after which the entries of an earlier search are dropped
+const ttMaxAge = 2
+
 type TranspositionTable struct {
-	lookup   map[Position]*MoveTree
-	stack    []Position
-	counter  int
-	capacity int
+	lookup     map[Position]ttEntry
+	stack      []Position
+	counter    int
+	capacity   int
+	generation int // counts the searches the table has been used for
+}
+
+type ttEntry struct {
+	tree       *MoveTree
+	generation int // of the search that stored the tree
 }
 
 func NewTranspositionTable(capacity int) *TranspositionTable {
 	tt := new(TranspositionTable)
-	tt.lookup = make(map[Position]*MoveTree)
-	tt.stack = make([]Position, 0, capacity)
+	tt.lookup = make(map[Position]ttEntry)
+	tt.stack = nil // grows as entries are added, instead of allocating capacity up front
 	tt.counter = 0
 	tt.capacity = capacity
 	return tt
@@ -24,15 +33,16 @@ func (tt *TranspositionTable) Get(pos Position) *MoveTree {
 	if tt == nil {
 		return nil
 	}
-	return tt.lookup[pos]
+	return tt.lookup[pos].tree
 }
 
 func (tt *TranspositionTable) Add(mt *MoveTree) {
 	if tt == nil {
 		return
 	}
-	if tt.lookup[mt.position] != nil {
-		// already an entry
+	if _, ok := tt.lookup[mt.position]; ok {
+		// already an entry -- keep the most recently searched tree
+		tt.lookup[mt.position] = ttEntry{mt, tt.generation}
 		return
 	}
 	if tt.counter == tt.capacity {
@@ -48,6 +58,34 @@ func (tt *TranspositionTable) Add(mt *MoveTree) {
 	} else {
 		tt.stack = append(tt.stack, mt.position)
 	}
-	tt.lookup[mt.position] = mt
+	tt.lookup[mt.position] = ttEntry{mt, tt.generation}
 	tt.counter++
 }
+
+func (tt *TranspositionTable) Len() int {
+	if tt == nil {
+		return 0
+	}
+	return len(tt.lookup)
+}
+
+// starts the next search's generation, dropping the entries no search of the last ttMaxAge
+// generations has stored or updated
+func (tt *TranspositionTable) Age() {
+	if tt == nil {
+		return
+	}
+	tt.generation++
+	// keep the survivors oldest first, as they would have been replaced
+	var kept []Position
+	for i := range tt.stack {
+		pos := tt.stack[(tt.counter+i)%len(tt.stack)]
+		if tt.generation-tt.lookup[pos].generation > ttMaxAge {
+			delete(tt.lookup, pos)
+			continue
+		}
+		kept = append(kept, pos)
+	}
+	tt.stack = kept
+	tt.counter = len(kept)
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestTranspositionTableAge(t *testing.T) {
+	tt := NewTranspositionTable(3)
+	tree := func(fen string) *MoveTree {
+		return &MoveTree{position: LoadInitialPosition(fen)}
+	}
+	old, kept, recent := tree(StartFEN), tree("4k3/8/8/8/8/8/8/4K3 w - - 0 1"), tree("4k3/8/8/8/8/8/8/4K2R w K - 0 1")
+	tt.Add(old)
+	tt.Add(kept)
+	tt.Age()
+	tt.Add(recent)
+	// searching a position again keeps its entry fresh
+	tt.Add(kept)
+	for i := 0; i < ttMaxAge; i++ {
+		tt.Age()
+	}
+	if tt.Get(old.position) != nil || tt.Get(kept.position) != kept || tt.Get(recent.position) != recent || tt.Len() != 2 {
+		t.Fatalf("after aging, %d entries remain; want the 2 stored in the last %d searches", tt.Len(), ttMaxAge)
+	}
+	// the survivors are replaced first once the table is full
+	fresh := tree("8/4k3/8/8/8/8/8/4K3 w - - 0 1")
+	tt.Add(fresh)
+	tt.Add(tree("8/8/4k3/8/8/8/8/4K3 w - - 0 1")) // full: starts over without adding
+	replacement := tree("8/8/8/4k3/8/8/8/4K3 w - - 0 1")
+	tt.Add(replacement)
+	if tt.Get(kept.position) != nil || tt.Get(recent.position) != recent || tt.Get(fresh.position) != fresh || tt.Get(replacement.position) != replacement {
+		t.Error("a full table did not replace its oldest entry")
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// centipawn score reported for forced mates, less the number of moves to mate
+const mateCentipawns = 100000
+
+// converts an evaluation in engine units to centipawns, keeping its point of view
+func evalToCentipawns(eval int) int {
+	if plies, ok := MateIn(eval); ok {
+		if eval < 0 {
+			return -mateCentipawns + plies
+		}
+		return mateCentipawns - plies
+	}
+	return eval * 10
+}
+
+// converts centipawns back to engine units, the inverse of evalToCentipawns
+func centipawnsToEval(cp int) int {
+	if cp > -mateCentipawns/2 && cp < mateCentipawns/2 {
+		return cp / 10
+	}
+	plies := mateCentipawns - cp
+	if cp < 0 {
+		plies = mateCentipawns + cp
+	}
+	eval := checkmateValue
+	for i := 0; i < plies; i++ {
+		eval = eval * 99 / 100
+	}
+	if cp < 0 {
+		return -eval
+	}
+	return eval
+}
+
+// UCI speaks the Universal Chess Interface, so the engine can be run by GUIs and match runners
+type UCI struct {
+	out       io.Writer
+	outMu     sync.Mutex
+	game      *Game
+	depth     int // used when "go" gives no limits
+	chess960  bool
+	tablebase *Tablebase    // nil unless SyzygyPath is set
+	tables    *SearchTables // kept until "ucinewgame"
+	stop      chan struct{} // closed to stop the running search
+	done      chan struct{} // closed once the running search has printed its best move
+}
+
+func NewUCI(out io.Writer) *UCI {
+	return &UCI{out: out, depth: 6, game: NewGame(StartFEN, nil, nil), tables: NewSearchTables(TTMaxSize)}
+}
+
+func StartUCI(args []string) {
+	fs := commandFlags("uci")
+	fs.Parse(args)
+	NewUCI(os.Stdout).Run(os.Stdin)
+}
+
+// handles commands until "quit", which stops any search, or the end of input, which
+// lets the search finish
+func (u *UCI) Run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "uci":
+			u.printf("id name stupid-horse")
+			u.printf("id author plin0009")
+			u.printf("option name Depth type spin default %d min 1 max %d", u.depth, MaxPly)
+			u.printf("option name UCI_Chess960 type check default false")
+			u.printf("option name SyzygyPath type string default <empty>")
+			u.printf("uciok")
+		case "isready":
+			u.printf("readyok")
+		case "setoption":
+			u.setOption(fields[1:])
+		case "ucinewgame":
+			u.stopSearch()
+			u.game = NewGame(StartFEN, nil, nil)
+			u.tables = NewSearchTables(TTMaxSize)
+		case "position":
+			u.stopSearch()
+			if err := u.position(fields[1:]); err != nil {
+				u.printf("info string %v", err)
+			}
+		case "go":
+			u.stopSearch()
+			u.search(fields[1:])
+		case "stop":
+			u.stopSearch()
+		case "quit":
+			u.stopSearch()
+			return
+		}
+	}
+	if u.done != nil {
+		<-u.done
+	}
+	u.stopSearch()
+}
+
+func (u *UCI) printf(format string, a ...interface{}) {
+	u.outMu.Lock()
+	defer u.outMu.Unlock()
+	fmt.Fprintf(u.out, format+"\n", a...)
+}
+
+// setoption name <name> value <value>
+func (u *UCI) setOption(fields []string) {
+	var name, value []string
+	target := &name
+	for _, field := range fields {
+		switch field {
+		case "name":
+			target = &name
+		case "value":
+			target = &value
+		default:
+			*target = append(*target, field)
+		}
+	}
+	switch strings.ToLower(strings.Join(name, " ")) {
+	case "depth":
+		if depth, err := strconv.Atoi(strings.Join(value, " ")); err == nil && depth >= 1 && depth <= MaxPly {
+			u.depth = depth
+		}
+	case "uci_chess960":
+		u.chess960 = strings.Join(value, " ") == "true"
+	case "syzygypath":
+		u.tablebase = nil
+		if path := strings.Join(value, " "); path != "" && path != "<empty>" {
+			tb, err := OpenTablebase(path)
+			if err != nil {
+				u.printf("info string %v", err)
+				return
+			}
+			u.tablebase = tb
+			u.printf("info string found %d-piece tablebases", tb.MaxPieces())
+		}
+	}
+}
+
+// position [startpos | fen <fen>] [moves <move> ...]
+func (u *UCI) position(fields []string) error {
+	if len(fields) == 0 {
+		return errors.New("position needs startpos or fen")
+	}
+	fen := StartFEN
+	rest := fields[1:]
+	if fields[0] == "fen" {
+		i := 0
+		for i < len(rest) && rest[i] != "moves" {
+			i++
+		}
+		fen = strings.Join(rest[:i], " ")
+		rest = rest[i:]
+	} else if fields[0] != "startpos" {
+		return fmt.Errorf("unknown position %q", fields[0])
+	}
+	g := NewGame(fen, nil, nil)
+	if len(rest) > 0 && rest[0] == "moves" {
+		pos := g.moveTree.position
+		for _, moveString := range rest[1:] {
+			if len(moveString) < 4 || !pos.IsLegal(pos.StringToMove(moveString)) {
+				return fmt.Errorf("illegal move %q", moveString)
+			}
+			pos = pos.ProcessMove(pos.StringToMove(moveString))
+		}
+		g.AddMoves(strings.Join(rest[1:], " "))
+	}
+	u.game = g
+	return nil
+}
+
+// go [depth n] [movetime ms] [wtime ms] [btime ms] [winc ms] [binc ms] [infinite]
+func (u *UCI) search(fields []string) {
+	u.tables.Advance(u.game.Ply())
+	limits := Limits{depth: u.depth, tablebase: u.tablebase, halfmoves: u.game.HalfmoveClock(), tables: u.tables}
+	timed := false
+	remaining := map[PieceColour]time.Duration{}
+	increment := map[PieceColour]time.Duration{}
+	for i := 0; i < len(fields); i++ {
+		value := 0
+		if i+1 < len(fields) {
+			value, _ = strconv.Atoi(fields[i+1])
+		}
+		ms := time.Duration(value) * time.Millisecond
+		switch fields[i] {
+		case "depth":
+			limits.depth = value
+		case "movetime":
+			limits.deadline = time.Now().Add(ms)
+			timed = true
+		case "wtime":
+			remaining[White] = ms
+		case "btime":
+			remaining[Black] = ms
+		case "winc":
+			increment[White] = ms
+		case "binc":
+			increment[Black] = ms
+		case "infinite":
+			timed = true
+			continue
+		default:
+			continue
+		}
+		i++
+	}
+	turn := u.game.moveTree.position.turn
+	if t, ok := remaining[turn]; ok && limits.deadline.IsZero() {
+		limits.deadline = time.Now().Add(AllocateTime(t, increment[turn]))
+		timed = true
+	}
+	if timed && limits.depth == u.depth {
+		limits.depth = MaxPly
+	}
+	if limits.depth < 1 || limits.depth > MaxPly {
+		limits.depth = MaxPly
+	}
+
+	u.stop = make(chan struct{})
+	u.done = make(chan struct{})
+	limits.stop = u.stop
+	tree := &MoveTree{position: u.game.moveTree.position}
+	start := time.Now()
+	go func() {
+		defer close(u.done)
+		ThinkUntil(tree, limits, func(depth, eval int) {
+			var pv []string
+			for _, m := range tree.PrincipalVariation() {
+				pv = append(pv, m.UCI(u.chess960))
+			}
+			u.printf("info depth %d score %s time %d pv %s",
+				depth, uciScore(eval*colourMultiplier[turn]), time.Since(start).Milliseconds(), strings.Join(pv, " "))
+		})
+		if tree.follow == nil {
+			u.printf("bestmove 0000")
+			return
+		}
+		u.printf("bestmove %s", tree.follow.move.UCI(u.chess960))
+	}()
+}
+
+// stops the running search, if any, and waits for it to print its best move
+func (u *UCI) stopSearch() {
+	if u.stop == nil {
+		return
+	}
+	close(u.stop)
+	<-u.done
+	u.stop, u.done = nil, nil
+}
+
+// formats an evaluation from the side to move's point of view as "cp 35" or "mate -2"
+func uciScore(eval int) string {
+	if plies, ok := MateIn(eval); ok {
+		moves := (plies + 1) / 2
+		if eval < 0 {
+			moves = -moves
+		}
+		return fmt.Sprintf("mate %d", moves)
+	}
+	return fmt.Sprintf("cp %d", evalToCentipawns(eval))
+}
+
+// parses the score of an "info" line into centipawns from the side to move's point of view
+func parseUCIScore(fields []string) (score int, ok bool) {
+	for i := 0; i+2 < len(fields); i++ {
+		if fields[i] != "score" {
+			continue
+		}
+		n, err := strconv.Atoi(fields[i+2])
+		if err != nil {
+			return 0, false
+		}
+		switch fields[i+1] {
+		case "cp":
+			return n, true
+		case "mate":
+			if n < 0 {
+				return -mateCentipawns - n*2, true
+			}
+			return mateCentipawns - n*2, true
+		}
+	}
+	return 0, false
+}
+
+// UCIEngine runs an external engine process and talks to it over UCI
+type UCIEngine struct {
+	name  string
+	cmd   *exec.Cmd
+	in    io.WriteCloser
+	lines chan string // the engine's output, closed when it exits
+}
+
+// starts the engine, sets its options and waits until it is ready
+func StartUCIEngine(command []string, options map[string]string) (*UCIEngine, error) {
+	if len(command) == 0 {
+		return nil, errors.New("no engine command")
+	}
+	e := &UCIEngine{name: command[0], cmd: exec.Command(command[0], command[1:]...), lines: make(chan string, 64)}
+	var err error
+	e.in, err = e.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := e.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := e.cmd.Start(); err != nil {
+		return nil, err
+	}
+	go func() {
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			e.lines <- scanner.Text()
+		}
+		close(e.lines)
+	}()
+	if err := e.send("uci"); err != nil {
+		return nil, err
+	}
+	err = e.readUntil("uciok", 10*time.Second, func(fields []string) {
+		if len(fields) > 2 && fields[0] == "id" && fields[1] == "name" {
+			e.name = strings.Join(fields[2:], " ")
+		}
+	})
+	if err != nil {
+		e.Close()
+		return nil, err
+	}
+	for name, value := range options {
+		if err := e.send("setoption name %s value %s", name, value); err != nil {
+			return nil, err
+		}
+	}
+	return e, e.ready()
+}
+
+func (e *UCIEngine) Name() string {
+	return e.name
+}
+
+func (e *UCIEngine) send(format string, a ...interface{}) error {
+	_, err := fmt.Fprintf(e.in, format+"\n", a...)
+	return err
+}
+
+// reads output lines until one starts with the given token, passing every line to f
+func (e *UCIEngine) readUntil(token string, timeout time.Duration, f func(fields []string)) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case line, ok := <-e.lines:
+			if !ok {
+				return fmt.Errorf("%s exited", e.name)
+			}
+			fields := strings.Fields(line)
+			if f != nil {
+				f(fields)
+			}
+			if len(fields) > 0 && fields[0] == token {
+				return nil
+			}
+		case <-timer.C:
+			return fmt.Errorf("%s did not answer %q in %v", e.name, token, timeout)
+		}
+	}
+}
+
+func (e *UCIEngine) ready() error {
+	if err := e.send("isready"); err != nil {
+		return err
+	}
+	return e.readUntil("readyok", 10*time.Second, nil)
+}
+
+func (e *UCIEngine) NewGame(chess960 bool) error {
+	if err := e.send("setoption name UCI_Chess960 value %v", chess960); err != nil {
+		return err
+	}
+	if err := e.send("ucinewgame"); err != nil {
+		return err
+	}
+	return e.ready()
+}
+
+// asks for a move in the game's current position, returning an error if the engine exits,
+// cannot be written to or does not answer within the mover's remaining time plus a small
+// margin (which loses on time); an answer that is not a move is returned as NoMove
+func (e *UCIEngine) Go(g *Game, remaining map[PieceColour]time.Duration, increment time.Duration, chess960 bool) (Move, int, bool, error) {
+	position := "startpos"
+	if g.initialFen != "startpos" && g.initialFen != StartFEN {
+		position = "fen " + g.initialFen
+	}
+	pos := LoadInitialPosition(g.initialFen)
+	var moves []string
+	for _, moveString := range strings.Fields(g.moves) {
+		m := pos.StringToMove(moveString)
+		moves = append(moves, m.UCI(chess960))
+		pos = pos.ProcessMove(m)
+	}
+	if len(moves) > 0 {
+		position += " moves " + strings.Join(moves, " ")
+	}
+	if err := e.send("position %s", position); err != nil {
+		return NoMove, 0, false, err
+	}
+	err := e.send("go wtime %d btime %d winc %d binc %d",
+		remaining[White].Milliseconds(), remaining[Black].Milliseconds(), increment.Milliseconds(), increment.Milliseconds())
+	if err != nil {
+		return NoMove, 0, false, err
+	}
+	score, hasScore := 0, false
+	best := ""
+	err = e.readUntil("bestmove", remaining[pos.turn]+time.Second, func(fields []string) {
+		switch {
+		case len(fields) > 0 && fields[0] == "info":
+			if s, ok := parseUCIScore(fields); ok {
+				score, hasScore = s*colourMultiplier[pos.turn], true
+			}
+		case len(fields) > 1 && fields[0] == "bestmove":
+			best = fields[1]
+		}
+	})
+	if err != nil {
+		return NoMove, 0, false, err
+	}
+	if !isCoordinateMove(best) {
+		return NoMove, score, hasScore, nil
+	}
+	return pos.StringToMove(strings.ToLower(best)), score, hasScore, nil
+}
+
+func (e *UCIEngine) Close() error {
+	e.send("quit")
+	e.in.Close()
+	done := make(chan error, 1)
+	go func() { done <- e.cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(time.Second):
+		e.cmd.Process.Kill()
+		return <-done
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestUCI(t *testing.T) {
+	tests := []struct {
+		commands string
+		want     []string // lines the output must contain, in order
+	}{
+		{"uci\nisready\n", []string{"id name stupid-horse", "uciok", "readyok"}},
+		// mate in one
+		{"position fen 6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1\ngo depth 2\nisready\n", []string{"info depth 1", "bestmove a1a8"}},
+		{"position startpos moves e2e4 e7e5 g1f3 b8c6 f1c4 g8f6\ngo depth 3\nisready\n", []string{"info depth 3", "bestmove"}},
+		// castling as the king's step, or onto the rook in Chess960
+		{"position fen 4k3/8/8/8/8/8/8/4K2R w K - 0 1 moves e1g1\ngo depth 1\nisready\n", []string{"bestmove"}},
+		{"position fen 4k3/8/8/8/8/8/8/4K2R w K - 0 1 moves e1e3\n", []string{`info string illegal move "e1e3"`}},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		NewUCI(&out).Run(strings.NewReader(test.commands))
+		output := out.String()
+		rest := output
+		for _, want := range test.want {
+			i := strings.Index(rest, want)
+			if i < 0 {
+				t.Errorf("commands %q: output %q lacks %q", test.commands, output, want)
+				break
+			}
+			rest = rest[i+len(want):]
+		}
+	}
+}
+
+func TestUCIScore(t *testing.T) {
+	tests := []struct {
+		eval int
+		want string
+	}{
+		{35, "cp 350"},
+		{-2, "cp -20"},
+		{checkmateValue * 99 / 100, "mate 1"},
+		{-checkmateValue * 99 / 100 * 99 / 100, "mate -1"},
+	}
+	for _, test := range tests {
+		if score := uciScore(test.eval); score != test.want {
+			t.Errorf("uciScore(%d) = %q; want %q", test.eval, score, test.want)
+		}
+		fields := strings.Fields("info depth 5 score " + test.want + " pv e2e4")
+		cp, ok := parseUCIScore(fields)
+		if !ok || (cp > 0) != (test.eval > 0) {
+			t.Errorf("parseUCIScore(%q) = %d, %v", test.want, cp, ok)
+		}
+		if eval := centipawnsToEval(evalToCentipawns(test.eval)); FormatEval(eval) != FormatEval(test.eval) {
+			t.Errorf("centipawnsToEval(evalToCentipawns(%d)) = %d", test.eval, eval)
+		}
+	}
+}